@@ -0,0 +1,55 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command dumpsyms prints the Breakpad symbol file (.sym) of an ELF file, built from
+// its pclntab, DWARF and symbol tables.
+package main
+
+import (
+	"debug/elf"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/go-kit/log"
+
+	"gitlab.com/Raven-IO/GoSymTable/symbol/addr2line"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s elf-file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(file string) error {
+	f, err := elf.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return addr2line.WriteBreakpad(os.Stdout, log.NewNopLogger(), file, f, demangle.NewDemangler("simple", false))
+}
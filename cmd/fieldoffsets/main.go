@@ -0,0 +1,94 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command fieldoffsets prints the offsets of struct fields, e.g. "runtime.g.goid",
+// of an ELF file with DWARF as a JSON table, to generate them per binary at deploy time.
+package main
+
+import (
+	"bufio"
+	"debug/elf"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-kit/log"
+
+	"gitlab.com/Raven-IO/GoSymTable/symbol/addr2line"
+)
+
+func main() {
+	queriesFile := flag.String("f", "", "file with one query per line, in addition to the arguments")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-f queries-file] elf-file [type.field...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	queries := flag.Args()[1:]
+	if *queriesFile != "" {
+		qs, err := readQueries(*queriesFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		queries = append(queries, qs...)
+	}
+
+	if err := run(flag.Arg(0), queries); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(file string, queries []string) error {
+	f, err := elf.Open(file)
+	if err != nil {
+		return err
+	}
+
+	// The liner owns f from now on.
+	dl, err := addr2line.DWARF(log.NewNopLogger(), file, f, nil)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer dl.Close()
+
+	return dl.WriteFieldOffsets(os.Stdout, queries)
+}
+
+// readQueries reads the queries of a file, skipping blank lines and "#" comments.
+func readQueries(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var queries []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		queries = append(queries, line)
+	}
+	return queries, s.Err()
+}
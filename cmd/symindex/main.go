@@ -0,0 +1,93 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command symindex builds the symbol indexes of ELF files, named after their build ID,
+// which can be shipped instead of the binaries and searched with symbolsearcher.OpenIndex.
+package main
+
+import (
+	"debug/elf"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"gitlab.com/Raven-IO/GoSymTable/symbol/addr2line"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/elfutils"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/symbolsearcher"
+)
+
+func main() {
+	out := flag.String("o", ".", "output directory of the indexes")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-o dir] elf-file...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+
+	failed := false
+	for _, file := range flag.Args() {
+		path, err := buildIndex(logger, file, *out)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't build symbol index", "file", file, "err", err)
+			failed = true
+			continue
+		}
+		level.Info(logger).Log("msg", "built symbol index", "file", file, "index", path)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func buildIndex(logger log.Logger, file, dir string) (string, error) {
+	f, err := elf.Open(file)
+	if err != nil {
+		return "", err
+	}
+
+	buildID, err := elfutils.BuildID(f)
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+
+	// The liner owns f from now on.
+	lnr, err := addr2line.Symbols(logger, file, f, nil)
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	defer lnr.Close()
+
+	path := filepath.Join(dir, buildID+symbolsearcher.IndexFileExt)
+	w, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := lnr.WriteIndex(w, buildID); err != nil {
+		w.Close()
+		os.Remove(path)
+		return "", err
+	}
+	return path, w.Close()
+}
@@ -0,0 +1,153 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command uprobes prints the addresses and the file offsets to attach uprobes to,
+// for the functions matching a name or for the code of a source line.
+package main
+
+import (
+	"debug/elf"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+
+	"gitlab.com/Raven-IO/GoSymTable/symbol/addr2line"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/elfutils"
+)
+
+type funcLookuper interface {
+	LookupFunctions(m addr2line.NameMatcher) ([]addr2line.FuncMatch, error)
+	Close() error
+}
+
+type lineLookuper interface {
+	LookupLine(file string, line int) ([]addr2line.LineMatch, error)
+}
+
+func main() {
+	fn := flag.String("func", "", "function name to look up")
+	match := flag.String("match", "exact", "how -func matches the function names: exact, glob or regexp")
+	line := flag.String("line", "", "source line to look up, as file:line")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s (-func name [-match mode] | -line file:line) elf-file\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || (*fn == "") == (*line == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *fn, *match, *line); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(file, fn, match, line string) error {
+	f, err := elf.Open(file)
+	if err != nil {
+		return err
+	}
+
+	// The liner owns f from now on.
+	lnr, err := newLiner(file, f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer lnr.Close()
+
+	if fn != "" {
+		m, err := nameMatcher(fn, match)
+		if err != nil {
+			return err
+		}
+		funcs, err := lnr.LookupFunctions(m)
+		if err != nil {
+			return err
+		}
+		if len(funcs) == 0 {
+			return fmt.Errorf("no function matches %q", fn)
+		}
+		for _, res := range funcs {
+			fmt.Printf("%s\t%#x\t%s\n", res.Name, res.Entry, fileOffset(f, res.Entry))
+		}
+		return nil
+	}
+
+	ll, ok := lnr.(lineLookuper)
+	if !ok {
+		return errors.New("no line information")
+	}
+	i := strings.LastIndex(line, ":")
+	if i < 0 {
+		return fmt.Errorf("invalid line %q, expected file:line", line)
+	}
+	n, err := strconv.Atoi(line[i+1:])
+	if err != nil {
+		return fmt.Errorf("invalid line number %q: %w", line[i+1:], err)
+	}
+	lines, err := ll.LookupLine(line[:i], n)
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("no code for %s", line)
+	}
+	for _, res := range lines {
+		fmt.Printf("%s:%d\t%s\t%#x-%#x\t%s\n", res.File, res.Line, res.Function, res.Start, res.End, fileOffset(f, res.Start))
+	}
+	return nil
+}
+
+// newLiner returns the liner with the most precise information about f.
+func newLiner(file string, f *elf.File) (funcLookuper, error) {
+	logger := log.NewNopLogger()
+	demangler := demangle.NewDemangler("simple", false)
+
+	switch {
+	case elfutils.HasGoPclntab(f):
+		return addr2line.Mixed(logger, file, f, demangler)
+	case elfutils.HasDWARF(f):
+		return addr2line.DWARF(logger, file, f, demangler)
+	default:
+		return addr2line.Symbols(logger, file, f, demangler)
+	}
+}
+
+func nameMatcher(name, mode string) (addr2line.NameMatcher, error) {
+	switch mode {
+	case "exact":
+		return addr2line.MatchExact(name), nil
+	case "glob":
+		return addr2line.MatchGlob(name)
+	case "regexp":
+		return addr2line.MatchRegexp(name)
+	}
+	return nil, fmt.Errorf("unknown match mode %q", mode)
+}
+
+func fileOffset(f *elf.File, addr uint64) string {
+	off, err := elfutils.FileOffset(f, addr)
+	if err != nil {
+		return "-"
+	}
+	return fmt.Sprintf("%#x", off)
+}
@@ -14,6 +14,8 @@ import (
 	"github.com/go-kit/log/level"
 	"gitlab.com/Raven-IO/GoSymTable/symbol/addr2line"
 	"gitlab.com/Raven-IO/GoSymTable/symbol/elfutils"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/goname"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/pathmap"
 )
 
 const callStackDepth = 5
@@ -27,6 +29,16 @@ func main() {
 	logger = level.NewFilter(logger, lvl)
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "caller", log.Caller(callStackDepth))
 
+	var rules []pathmap.Rule
+	flag.Func("remap", "rewrite source paths, `from=to` or regexp:expr=replacement (repeatable)", func(s string) error {
+		r, err := pathmap.ParseRule(s)
+		if err != nil {
+			return err
+		}
+		rules = append(rules, r)
+		return nil
+	})
+	goPaths := flag.Bool("go-paths", false, "normalize Go source paths to module@version/relpath")
 	flag.Parse()
 	if len(flag.Args()) <= 0 {
 		stdLog.Fatalf("no elf file provided")
@@ -46,7 +58,13 @@ func main() {
 		lnr, err := addr2line.Go(logger, file, e)
 		if err != nil {
 			level.Error(logger).Log("msg", "can't create liner", "file", file, "err", err)
+			os.Exit(1)
+		}
+
+		if *goPaths {
+			rules = append(rules, lnr.GoPaths())
 		}
+		mapper := pathmap.New(rules...)
 
 		level.Info(logger).Log("len(Syms)", len(lnr.Symtab.Syms))
 		level.Info(logger).Log("len(Funcs)", len(lnr.Symtab.Funcs))
@@ -55,7 +73,7 @@ func main() {
 
 		level.Info(logger).Log("msg", "File Names")
 		for f := range lnr.Symtab.Files {
-			fmt.Println(f)
+			fmt.Println(mapper.Remap(f))
 		}
 
 		fmt.Println("")
@@ -65,7 +83,7 @@ func main() {
 		for _, f := range lnr.Symtab.Funcs {
 			//fmt.Printf("func=%d, name=%s, package=%s, receiver=%s", i, f.BaseName(), f.PackageName(), f.ReceiverName())
 			//fmt.Println("")
-			rtPackages[f.PackageName()] = true
+			rtPackages[goname.Parse(f.Name).ImportPath] = true
 		}
 
 		level.Info(logger).Log("msg", "In-line packages")
@@ -77,10 +95,20 @@ func main() {
 
 	bi, err := buildinfo.ReadFile(file)
 	if err != nil {
-		level.Error(logger).Log("msg", "can't open buildinfo", "file", file, "err", err)
-		os.Exit(1)
+		// Stripped or non-standard binaries may lack the build information,
+		// fall back to the heuristics which only recover the toolchain version.
+		level.Warn(logger).Log("msg", "can't open buildinfo", "file", file, "err", err)
+		version, err := elfutils.DetectGoVersion(e)
+		if err != nil {
+			level.Error(logger).Log("msg", "can't detect Go version", "file", file, "err", err)
+			return
+		}
+		level.Info(logger).Log("msg", "Go version", "version", version.Raw, "source", version.Source, "confidence", version.Confidence)
+		return
 	}
 
+	level.Info(logger).Log("msg", "Go version", "version", bi.GoVersion, "source", "buildinfo", "confidence", elfutils.ConfidenceHigh)
+
 	//	level.Info(logger).Log("buildinfo", bi.String())
 
 	mods := make(map[string]debug.Module)
@@ -0,0 +1,647 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"gitlab.com/Raven-IO/GoSymTable/profile"
+	pb "gitlab.com/Raven-IO/GoSymTable/protogen/go/metastore"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/breakpad"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/elfutils"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/symbolsearcher"
+)
+
+// BreakpadLiner is a liner which utilizes a Breakpad symbol file (.sym): the FUNC records
+// with their line and INLINE records, and the PUBLIC records for the addresses outside of
+// the functions.
+type BreakpadLiner struct {
+	logger log.Logger
+
+	// Base is added to the addresses of the symbol file, which are relative to the load
+	// address of the module. It is BreakpadBase of the ELF file to look up the same
+	// addresses as with its other liners.
+	Base uint64
+
+	sym      *breakpad.SymbolFile
+	filename string
+}
+
+// Breakpad creates a new BreakpadLiner.
+func Breakpad(logger log.Logger, filename string) (*BreakpadLiner, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open symbol file: %w", err)
+	}
+	defer f.Close()
+
+	sym, err := breakpad.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse symbol file: %w", err)
+	}
+	return &BreakpadLiner{
+		logger:   log.With(logger, "liner", "breakpad"),
+		sym:      sym,
+		filename: filename,
+	}, nil
+}
+
+func (bl *BreakpadLiner) Close() error {
+	return nil
+}
+
+func (bl *BreakpadLiner) File() string {
+	return bl.filename
+}
+
+// SymbolFile returns the content of the symbol file.
+func (bl *BreakpadLiner) SymbolFile() *breakpad.SymbolFile {
+	return bl.sym
+}
+
+func (bl *BreakpadLiner) PCRange() ([2]uint64, error) {
+	var (
+		res   [2]uint64
+		found bool
+	)
+	extend := func(start, end uint64) {
+		if !found || start < res[0] {
+			res[0] = start
+		}
+		if !found || end > res[1] {
+			res[1] = end
+		}
+		found = true
+	}
+	for _, fn := range bl.sym.Funcs {
+		extend(fn.Address, fn.Address+fn.Size)
+	}
+	if n := len(bl.sym.Publics); n > 0 {
+		extend(bl.sym.Publics[0].Address, bl.sym.Publics[n-1].Address+1)
+	}
+	if !found {
+		return [2]uint64{}, errors.New("no symbols found")
+	}
+	return [2]uint64{res[0] + bl.Base, res[1] + bl.Base}, nil
+}
+
+// PCToLines looks up the line number information for a program counter (memory address).
+// The functions inlined at addr are returned first, the FUNC record last.
+func (bl *BreakpadLiner) PCToLines(addr uint64) ([]profile.LocationLine, error) {
+	if addr < bl.Base {
+		return nil, &symbolsearcher.NotFoundError{Addr: addr}
+	}
+	rel := addr - bl.Base
+
+	funcs := bl.sym.Funcs
+	i := sort.Search(len(funcs), func(i int) bool {
+		return funcs[i].Address > rel
+	})
+	if i > 0 && (breakpad.Range{Address: funcs[i-1].Address, Size: funcs[i-1].Size}).Contains(rel) {
+		return bl.funcLines(funcs[i-1], rel), nil
+	}
+
+	publics := bl.sym.Publics
+	j := sort.Search(len(publics), func(j int) bool {
+		return publics[j].Address > rel
+	})
+	// The functions before addr end before it, only a later public symbol can cover it.
+	if j == 0 || (i > 0 && funcs[i-1].Address > publics[j-1].Address) {
+		return nil, &symbolsearcher.NotFoundError{Addr: addr}
+	}
+	return []profile.LocationLine{{
+		Function: &pb.Function{
+			Name:       publics[j-1].Name,
+			SystemName: publics[j-1].Name,
+			Filename:   "?",
+		},
+	}}, nil
+}
+
+// funcLines returns the lines of rel, an address of fn, the innermost inlined function first.
+func (bl *BreakpadLiner) funcLines(fn *breakpad.Func, rel uint64) []profile.LocationLine {
+	// The chain of inlined calls, from the one in fn to the innermost.
+	var chain []breakpad.Inline
+	for _, in := range fn.Inlines {
+		if in.Depth != len(chain) {
+			continue
+		}
+		for _, r := range in.Ranges {
+			if r.Contains(rel) {
+				chain = append(chain, in)
+				break
+			}
+		}
+	}
+
+	file, line := "?", int64(0)
+	i := sort.Search(len(fn.Lines), func(i int) bool {
+		return fn.Lines[i].Address > rel
+	})
+	if i > 0 && (breakpad.Range{Address: fn.Lines[i-1].Address, Size: fn.Lines[i-1].Size}).Contains(rel) {
+		file, line = bl.file(fn.Lines[i-1].File), fn.Lines[i-1].Line
+	}
+
+	lines := make([]profile.LocationLine, 0, len(chain)+1)
+	for d := len(chain); d >= 0; d-- {
+		name := fn.Name
+		if d > 0 {
+			name = bl.sym.InlineOrigins[chain[d-1].Origin]
+		}
+		lines = append(lines, profile.LocationLine{
+			Line: line,
+			Function: &pb.Function{
+				Name:       name,
+				SystemName: name,
+				Filename:   file,
+			},
+		})
+		if d > 0 {
+			// The caller is at the call site.
+			file, line = bl.file(chain[d-1].CallFile), chain[d-1].CallLine
+		}
+	}
+	return lines
+}
+
+func (bl *BreakpadLiner) file(i int) string {
+	if name, ok := bl.sym.Files[i]; ok {
+		return name
+	}
+	return "?"
+}
+
+// BreakpadBase returns the load address of f which the addresses of its Breakpad symbol
+// file are relative to: the address of the loadable segment at file offset 0, if any.
+func BreakpadBase(f *elf.File) uint64 {
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Off == 0 {
+			return prog.Vaddr
+		}
+	}
+	return 0
+}
+
+// WriteBreakpad writes the Breakpad symbol file of f, see BreakpadSymbols.
+func WriteBreakpad(w io.Writer, logger log.Logger, filename string, f *elf.File, demangler *demangle.Demangler) error {
+	sym, err := BreakpadSymbols(logger, filename, f, demangler)
+	if err != nil {
+		return err
+	}
+	return sym.Encode(w)
+}
+
+// BreakpadSymbols builds the Breakpad symbol file of f from its liners: the FUNC records
+// of Go functions come from the pclntab, the others from DWARF, which also provides the
+// INLINE records, and the symbols outside of those functions are PUBLIC records.
+// The caller keeps the ownership of f.
+func BreakpadSymbols(logger log.Logger, filename string, f *elf.File, demangler *demangle.Demangler) (*breakpad.SymbolFile, error) {
+	logger = log.With(logger, "liner", "breakpad")
+
+	id, ok := elfutils.GNUBuildID(f)
+	if !ok {
+		id = textHash(f)
+	}
+	b := &breakpadBuilder{
+		sym: &breakpad.SymbolFile{
+			Module: breakpad.Module{
+				OS:   "Linux",
+				Arch: breakpadArch(f.Machine),
+				ID:   breakpad.DebugID(id),
+				Name: filepath.Base(filename),
+			},
+			CodeID:        strings.ToUpper(hex.EncodeToString(id)),
+			Files:         make(map[int]string),
+			InlineOrigins: make(map[int]string),
+		},
+		demangler: demangler,
+		base:      BreakpadBase(f),
+		files:     make(map[string]int),
+		origins:   make(map[string]int),
+		funcs:     make(map[uint64]*breakpad.Func),
+		dwarfFunc: make(map[*breakpad.Func]bool),
+	}
+
+	if elfutils.HasGoPclntab(f) {
+		gl, err := Go(logger, filename, f)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.addGo(gl); err != nil {
+			return nil, err
+		}
+	}
+	if elfutils.HasDWARF(f) {
+		dl, err := DWARF(logger, filename, f, demangler)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.addDWARF(dl.debugData); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, fn := range b.funcs {
+		b.sym.Funcs = append(b.sym.Funcs, fn)
+	}
+	b.sym.Sort()
+	b.assignLines()
+
+	if sl, err := Symbols(logger, filename, f, demangler); err == nil {
+		b.addPublics(sl)
+	} else {
+		level.Debug(logger).Log("msg", "failed to create symtab liner", "err", err)
+	}
+	return b.sym, nil
+}
+
+// breakpadBuilder accumulates the records of a symbol file, at addresses relative to base.
+type breakpadBuilder struct {
+	sym       *breakpad.SymbolFile
+	demangler *demangle.Demangler
+	base      uint64
+
+	files   map[string]int
+	origins map[string]int
+	// funcs are the functions by address.
+	funcs map[uint64]*breakpad.Func
+	// dwarfFunc marks the functions whose lines are in dwarfLines.
+	dwarfFunc  map[*breakpad.Func]bool
+	dwarfLines []dwarfLine
+}
+
+// dwarfLine is a row of a DWARF line table, covering the code up to the next row.
+type dwarfLine struct {
+	start, end uint64
+	file       string
+	line       int64
+}
+
+func (b *breakpadBuilder) file(name string) int {
+	i, ok := b.files[name]
+	if !ok {
+		i = len(b.files)
+		b.files[name] = i
+		b.sym.Files[i] = name
+	}
+	return i
+}
+
+func (b *breakpadBuilder) origin(name string) int {
+	i, ok := b.origins[name]
+	if !ok {
+		i = len(b.origins)
+		b.origins[name] = i
+		b.sym.InlineOrigins[i] = name
+	}
+	return i
+}
+
+// addGo adds the functions of the pclntab and their lines. The Go inlining tables
+// aren't decoded, the DWARF data adds the inlined calls if available.
+func (b *breakpadBuilder) addGo(gl *GoLiner) error {
+	t, err := gl.table()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < t.Len(); i++ {
+		fn, ok := t.Func(i)
+		if !ok || fn.End <= fn.Entry {
+			continue
+		}
+		bf := &breakpad.Func{Address: fn.Entry - b.base, Size: fn.End - fn.Entry, Name: fn.Name}
+		for _, r := range t.FuncLines(i) {
+			if r.Line <= 0 {
+				continue
+			}
+			bf.Lines = append(bf.Lines, breakpad.Line{
+				Address: r.Start - b.base,
+				Size:    r.End - r.Start,
+				Line:    int64(r.Line),
+				File:    b.file(r.File),
+			})
+		}
+		b.funcs[bf.Address] = bf
+	}
+	return nil
+}
+
+// addDWARF adds the subprograms not in the pclntab, the rows of the line tables
+// and the inlined subroutines.
+func (b *breakpadBuilder) addDWARF(d *dwarf.Data) error {
+	r := d.Reader()
+	for {
+		cu, err := r.Next()
+		if err != nil {
+			return fmt.Errorf("failed to read DWARF entry: %w", err)
+		}
+		if cu == nil {
+			return nil
+		}
+		if cu.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+
+		var files []*dwarf.LineFile
+		if lr, err := d.LineReader(cu); err == nil && lr != nil {
+			files = lr.Files()
+			b.addDWARFLines(lr)
+		}
+		if cu.Children {
+			if err := b.addDWARFChildren(d, r, files, nil, 0); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (b *breakpadBuilder) addDWARFLines(lr *dwarf.LineReader) {
+	var (
+		prev     dwarf.LineEntry
+		havePrev bool
+	)
+	for {
+		var le dwarf.LineEntry
+		if err := lr.Next(&le); err != nil {
+			return
+		}
+		if havePrev && prev.File != nil && prev.Line > 0 && le.Address > prev.Address && prev.Address >= b.base {
+			b.dwarfLines = append(b.dwarfLines, dwarfLine{
+				start: prev.Address - b.base,
+				end:   le.Address - b.base,
+				file:  prev.File.Name,
+				line:  int64(prev.Line),
+			})
+		}
+		prev, havePrev = le, !le.EndSequence
+	}
+}
+
+// addDWARFChildren reads the children of the entry just read. The inlined subroutines
+// are added to fns, the functions of the enclosing subprogram, at depth.
+func (b *breakpadBuilder) addDWARFChildren(d *dwarf.Data, r *dwarf.Reader, files []*dwarf.LineFile, fns []*breakpad.Func, depth int) error {
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return fmt.Errorf("failed to read DWARF entry: %w", err)
+		}
+		if e == nil || e.Tag == 0 {
+			return nil
+		}
+
+		childFns, childDepth := fns, depth
+		switch e.Tag {
+		case dwarf.TagSubprogram:
+			childFns, childDepth = b.addDWARFFunc(d, e), 0
+		case dwarf.TagInlinedSubroutine:
+			b.addDWARFInline(d, e, files, fns, depth)
+			childDepth = depth + 1
+		}
+		if e.Children {
+			if err := b.addDWARFChildren(d, r, files, childFns, childDepth); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// addDWARFFunc adds a function per address range of a subprogram, unless the pclntab
+// already had it, and returns them.
+func (b *breakpadBuilder) addDWARFFunc(d *dwarf.Data, e *dwarf.Entry) []*breakpad.Func {
+	ranges, err := d.Ranges(e)
+	if err != nil || len(ranges) == 0 {
+		// Declarations and abstract instances of inlined functions have no code.
+		return nil
+	}
+
+	name := dwarfEntryName(d, e, b.demangler)
+	var fns []*breakpad.Func
+	for _, rng := range ranges {
+		if rng[0] < b.base || rng[1] <= rng[0] {
+			continue
+		}
+		addr := rng[0] - b.base
+		if fn, ok := b.funcs[addr]; ok {
+			fns = append(fns, fn)
+			continue
+		}
+		fn := &breakpad.Func{Address: addr, Size: rng[1] - rng[0], Name: name}
+		b.funcs[addr] = fn
+		b.dwarfFunc[fn] = true
+		fns = append(fns, fn)
+	}
+	return fns
+}
+
+// addDWARFInline adds an inlined subroutine to the function of fns containing it.
+func (b *breakpadBuilder) addDWARFInline(d *dwarf.Data, e *dwarf.Entry, files []*dwarf.LineFile, fns []*breakpad.Func, depth int) {
+	ranges, err := d.Ranges(e)
+	if err != nil || len(ranges) == 0 || len(fns) == 0 {
+		return
+	}
+	off, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+	if !ok {
+		return
+	}
+	origin, err := dwarfEntryAt(d, off)
+	if err != nil {
+		return
+	}
+
+	in := breakpad.Inline{
+		Depth:  depth,
+		Origin: b.origin(dwarfEntryName(d, origin, b.demangler)),
+	}
+	in.CallLine, _ = e.Val(dwarf.AttrCallLine).(int64)
+	callFile := "?"
+	if i, ok := e.Val(dwarf.AttrCallFile).(int64); ok && i >= 0 && int(i) < len(files) && files[i] != nil {
+		callFile = files[i].Name
+	}
+	in.CallFile = b.file(callFile)
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+	var fn *breakpad.Func
+	for _, rng := range ranges {
+		if rng[0] < b.base || rng[1] <= rng[0] {
+			continue
+		}
+		in.Ranges = append(in.Ranges, breakpad.Range{Address: rng[0] - b.base, Size: rng[1] - rng[0]})
+		for _, f := range fns {
+			if fn == nil && (breakpad.Range{Address: f.Address, Size: f.Size}).Contains(rng[0]-b.base) {
+				fn = f
+			}
+		}
+	}
+	if fn != nil {
+		fn.Inlines = append(fn.Inlines, in)
+	}
+}
+
+// assignLines adds the rows of the DWARF line tables to the functions created from DWARF,
+// which are sorted by address.
+func (b *breakpadBuilder) assignLines() {
+	funcs := b.sym.Funcs
+	for _, l := range b.dwarfLines {
+		i := sort.Search(len(funcs), func(i int) bool {
+			return funcs[i].Address > l.start
+		})
+		if i == 0 {
+			continue
+		}
+		fn := funcs[i-1]
+		if !b.dwarfFunc[fn] || l.start >= fn.Address+fn.Size {
+			continue
+		}
+		line := breakpad.Line{
+			Address: l.start,
+			Size:    min(l.end, fn.Address+fn.Size) - l.start,
+			Line:    l.line,
+			File:    b.file(l.file),
+		}
+		if n := len(fn.Lines); n > 0 {
+			if last := &fn.Lines[n-1]; last.Address+last.Size == line.Address && last.Line == line.Line && last.File == line.File {
+				last.Size += line.Size
+				continue
+			}
+		}
+		fn.Lines = append(fn.Lines, line)
+	}
+	for _, fn := range funcs {
+		sort.SliceStable(fn.Lines, func(i, j int) bool {
+			return fn.Lines[i].Address < fn.Lines[j].Address
+		})
+	}
+}
+
+// addPublics adds the function symbols outside of the functions, the best of the aliases
+// at an address.
+func (b *breakpadBuilder) addPublics(sl *SymtabLiner) {
+	funcs := b.sym.Funcs
+	syms := sl.searcher.Symbols()
+	for i, sym := range syms {
+		// The aliases are sorted from the worst to the best.
+		if sym.Value < b.base || (i+1 < len(syms) && syms[i+1].Value == sym.Value) {
+			continue
+		}
+		addr := sym.Value - b.base
+		j := sort.Search(len(funcs), func(j int) bool {
+			return funcs[j].Address > addr
+		})
+		if j > 0 && (breakpad.Range{Address: funcs[j-1].Address, Size: funcs[j-1].Size}).Contains(addr) {
+			continue
+		}
+		fn := sl.function(sym)
+		name := fn.Name
+		if name == "" {
+			name = fn.SystemName
+		}
+		b.sym.Publics = append(b.sym.Publics, breakpad.Public{Address: addr, Name: name})
+	}
+}
+
+// dwarfEntryName returns the demangled linkage name of a subprogram, or its name. The names
+// of concrete instances and of out-of-line definitions are those of their origin.
+func dwarfEntryName(d *dwarf.Data, e *dwarf.Entry, demangler *demangle.Demangler) string {
+	for i := 0; i < 8 && e != nil; i++ {
+		if linkageName, ok := e.Val(dwarf.AttrLinkageName).(string); ok && linkageName != "" {
+			if name := demangler.Demangle(&pb.Function{SystemName: linkageName}).Name; name != "" {
+				return name
+			}
+			return linkageName
+		}
+		if name, ok := e.Val(dwarf.AttrName).(string); ok && name != "" {
+			return name
+		}
+
+		off, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+		if !ok {
+			off, ok = e.Val(dwarf.AttrSpecification).(dwarf.Offset)
+		}
+		if !ok {
+			break
+		}
+		e, _ = dwarfEntryAt(d, off)
+	}
+	return "?"
+}
+
+func dwarfEntryAt(d *dwarf.Data, off dwarf.Offset) (*dwarf.Entry, error) {
+	r := d.Reader()
+	r.Seek(off)
+	e, err := r.Next()
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		return nil, fmt.Errorf("no DWARF entry at %#x", off)
+	}
+	return e, nil
+}
+
+// textHash identifies the binaries without a GNU build ID as dump_syms does:
+// the XOR of the 16-byte blocks of the first page of .text.
+func textHash(f *elf.File) []byte {
+	id := make([]byte, 16)
+	sec := f.Section(".text")
+	if sec == nil || sec.Type != elf.SHT_PROGBITS {
+		return id
+	}
+	data := make([]byte, min(sec.Size, 4096))
+	n, _ := sec.ReadAt(data, 0)
+	for off := 0; off+16 <= n; off += 16 {
+		for i := range id {
+			id[i] ^= data[off+i]
+		}
+	}
+	return id
+}
+
+// breakpadArch returns the name of the architecture in the MODULE record.
+func breakpadArch(m elf.Machine) string {
+	switch m {
+	case elf.EM_386:
+		return "x86"
+	case elf.EM_X86_64:
+		return "x86_64"
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_AARCH64:
+		return "arm64"
+	case elf.EM_MIPS:
+		return "mips"
+	case elf.EM_PPC:
+		return "ppc"
+	case elf.EM_PPC64:
+		return "ppc64"
+	case elf.EM_RISCV:
+		return "riscv64"
+	case elf.EM_S390:
+		return "s390"
+	}
+	return strings.ToLower(strings.TrimPrefix(m.String(), "EM_"))
+}
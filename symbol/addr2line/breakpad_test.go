@@ -0,0 +1,169 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/Raven-IO/GoSymTable/profile"
+	metastorev1alpha1 "gitlab.com/Raven-IO/GoSymTable/protogen/go/metastore"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/breakpad"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/symbolsearcher"
+)
+
+// writeBreakpad writes the symbol file of an ELF file of testdata and returns a liner of it.
+func writeBreakpad(t *testing.T, name string) *BreakpadLiner {
+	t.Helper()
+	f, err := elf.Open(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	defer f.Close()
+
+	filename := filepath.Join(t.TempDir(), name+".sym")
+	out, err := os.Create(filename)
+	require.NoError(t, err)
+	require.NoError(t, WriteBreakpad(out, log.NewNopLogger(), name, f, nil))
+	require.NoError(t, out.Close())
+
+	bl, err := Breakpad(log.NewNopLogger(), filename)
+	require.NoError(t, err)
+	bl.Base = BreakpadBase(f)
+	return bl
+}
+
+func breakpadLines(frames ...string) []profile.LocationLine {
+	lines := make([]profile.LocationLine, 0, len(frames))
+	for _, frame := range frames {
+		name, pos, _ := strings.Cut(frame, " ")
+		file, line := "?", int64(0)
+		if pos != "" {
+			i := strings.LastIndexByte(pos, ':')
+			n, _ := strconv.Atoi(pos[i+1:])
+			file, line = pos[:i], int64(n)
+		}
+		lines = append(lines, profile.LocationLine{
+			Line:     line,
+			Function: &metastorev1alpha1.Function{Name: name, SystemName: name, Filename: file},
+		})
+	}
+	return lines
+}
+
+func TestBreakpad_Inline(t *testing.T) {
+	bl := writeBreakpad(t, "inline-c")
+	defer bl.Close()
+
+	// The GNU build ID is 7de7f117e042b260213517347cb23134d9f54468.
+	sym := bl.SymbolFile()
+	require.Equal(t, breakpad.Module{OS: "Linux", Arch: "x86_64", ID: "17F1E77D42E060B2213517347CB231340", Name: "inline-c"}, sym.Module)
+	require.Equal(t, "7DE7F117E042B260213517347CB23134D9F54468", sym.CodeID)
+	require.Equal(t, uint64(0x400000), bl.Base)
+
+	tests := []struct {
+		addr uint64
+		want []profile.LocationLine
+	}{
+		{addr: 0x401020, want: breakpadLines("leaf inline/inline.c:6", "middle inline/inline.c:10", "main inline/inline.c:15")},
+		{addr: 0x40102a, want: breakpadLines("main inline/inline.c:17")},
+		{addr: 0x40102c, want: breakpadLines("middle inline/inline.c:11", "main inline/inline.c:15")},
+		{addr: 0x401036, want: breakpadLines("main inline/inline.c:17")},
+		// Symbols without debug information are PUBLIC records.
+		{addr: 0x401045, want: breakpadLines("_start")},
+	}
+	for _, tt := range tests {
+		got, err := bl.PCToLines(tt.addr)
+		require.NoError(t, err, "%#x", tt.addr)
+		require.Equal(t, tt.want, got, "%#x", tt.addr)
+	}
+
+	_, err := bl.PCToLines(0x400fff)
+	var nfErr *symbolsearcher.NotFoundError
+	require.ErrorAs(t, err, &nfErr)
+}
+
+func TestBreakpad_Go(t *testing.T) {
+	bl := writeBreakpad(t, "cgo-go")
+	defer bl.Close()
+
+	tests := []struct {
+		addr uint64
+		want []profile.LocationLine
+	}{
+		// Go functions come from the pclntab, the C ones from DWARF.
+		{addr: 0x4813d0, want: breakpadLines("main.goCaller cgo/main.go:29")},
+		{addr: 0x4814a7, want: breakpadLines("c_leaf /_/cgo/main.go:17")},
+	}
+	for _, tt := range tests {
+		got, err := bl.PCToLines(tt.addr)
+		require.NoError(t, err, "%#x", tt.addr)
+		require.Equal(t, tt.want, got, "%#x", tt.addr)
+	}
+
+	// The Go inlined calls come from DWARF.
+	var inlines int
+	for _, fn := range bl.SymbolFile().Funcs {
+		inlines += len(fn.Inlines)
+	}
+	require.NotZero(t, inlines)
+}
+
+func TestBreakpadLiner_Publics(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "a.sym")
+	require.NoError(t, os.WriteFile(filename, []byte(`MODULE Linux x86_64 000000000000000000000000000000000 a
+FILE 0 a.c
+FUNC 1000 10 0 f
+1000 8 3 0
+PUBLIC 900 0 before
+PUBLIC 1020 0 after
+`), 0o644))
+	bl, err := Breakpad(log.NewNopLogger(), filename)
+	require.NoError(t, err)
+	bl.Base = 0x10000
+
+	tests := []struct {
+		addr    uint64
+		want    []profile.LocationLine
+		wantErr bool
+	}{
+		{addr: 0x10950, want: breakpadLines("before")},
+		{addr: 0x11004, want: breakpadLines("f a.c:3")},
+		// No line record.
+		{addr: 0x1100c, want: breakpadLines("f")},
+		// Past the end of f, the public symbol before it doesn't cover the address.
+		{addr: 0x11018, wantErr: true},
+		{addr: 0x11030, want: breakpadLines("after")},
+		{addr: 0x10800, wantErr: true},
+		{addr: 0x800, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := bl.PCToLines(tt.addr)
+		if tt.wantErr {
+			require.Error(t, err, "%#x", tt.addr)
+			continue
+		}
+		require.NoError(t, err, "%#x", tt.addr)
+		require.Equal(t, tt.want, got, "%#x", tt.addr)
+	}
+
+	pcRange, err := bl.PCRange()
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{0x10900, 0x11021}, pcRange)
+}
@@ -0,0 +1,199 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"debug/elf"
+	"fmt"
+	"sort"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"gitlab.com/Raven-IO/GoSymTable/profile"
+	pb "gitlab.com/Raven-IO/GoSymTable/protogen/go/metastore"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/elfutils"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/symbolsearcher"
+)
+
+// DataSymbol describes the global variable enclosing a data address.
+type DataSymbol struct {
+	// Name is the (demangled) name of the variable.
+	Name string
+	// SystemName is the name of the variable as it appears in the object file.
+	SystemName string
+	// TypeName is the name of the variable type, empty if unknown.
+	TypeName string
+	// Start is the address of the variable.
+	Start uint64
+	// Size is the size of the variable in bytes, 0 if unknown.
+	Size uint64
+	// Offset is the offset of the address inside the variable.
+	Offset uint64
+	// Field is the path of the member containing the address, e.g. "hdr.flags".
+	Field string
+}
+
+// DataLiner is a liner which symbolizes addresses of global variables.
+// It uses DWARF variables (DW_TAG_variable with a DW_AT_location) when available
+// and falls back to data object symbols (STT_OBJECT) from .symtab and .dynsym.
+type DataLiner struct {
+	logger log.Logger
+
+	demangler *demangle.Demangler
+	vars      []elfutils.Variable
+	searcher  symbolsearcher.Searcher
+
+	filename string
+	f        *elf.File
+}
+
+// Data creates a new DataLiner.
+func Data(logger log.Logger, filename string, f *elf.File, demangler *demangle.Demangler) (*DataLiner, error) {
+	logger = log.With(logger, "liner", "data")
+
+	var vars []elfutils.Variable
+	if debugData, err := f.DWARF(); err == nil {
+		vars, err = elfutils.GlobalVariables(debugData, f.ByteOrder)
+		if err != nil {
+			level.Debug(logger).Log("msg", "failed to read DWARF variables", "err", err)
+		}
+	}
+
+	symbols, err := symtab(f)
+	if err != nil && len(vars) == 0 {
+		return nil, fmt.Errorf("failed to fetch symbols from object file: %w", err)
+	}
+
+	// Addresses past the end of a variable, e.g. in alignment padding, aren't attributed to it.
+	searcher := symbolsearcher.NewData(symbols)
+	searcher.Strict = true
+
+	return &DataLiner{
+		logger:    logger,
+		demangler: demangler,
+		vars:      vars,
+		searcher:  searcher,
+		filename:  filename,
+		f:         f,
+	}, nil
+}
+
+func (dl *DataLiner) Close() error {
+	return dl.f.Close()
+}
+
+func (dl *DataLiner) File() string {
+	return dl.filename
+}
+
+func (dl *DataLiner) PCRange() ([2]uint64, error) {
+	r, err := dl.searcher.PCRange()
+	if len(dl.vars) == 0 {
+		return r, err
+	}
+
+	first, last := dl.vars[0], dl.vars[len(dl.vars)-1]
+	if err != nil {
+		return [2]uint64{first.Address, last.Address + last.Size}, nil
+	}
+	if first.Address < r[0] {
+		r[0] = first.Address
+	}
+	if last.Address+last.Size > r[1] {
+		r[1] = last.Address + last.Size
+	}
+	return r, nil
+}
+
+// PCToLines returns the variable enclosing a data address as a location line.
+// The member path, if any, is appended to the variable name.
+func (dl *DataLiner) PCToLines(addr uint64) ([]profile.LocationLine, error) {
+	sym, err := dl.DataSymbol(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	name := sym.Name
+	if sym.Field != "" {
+		name = name + "." + sym.Field
+	}
+	return []profile.LocationLine{{
+		Function: &pb.Function{
+			Name:       name,
+			SystemName: sym.SystemName,
+			Filename:   "?",
+		},
+	}}, nil
+}
+
+// DataSymbol looks up the global variable enclosing addr.
+func (dl *DataLiner) DataSymbol(addr uint64) (*DataSymbol, error) {
+	if v, ok := dl.searchVariable(addr); ok {
+		sym := &DataSymbol{
+			SystemName: v.LinkageName,
+			Start:      v.Address,
+			Size:       v.Size,
+			Offset:     addr - v.Address,
+		}
+		if sym.SystemName == "" {
+			sym.SystemName = v.Name
+		}
+		sym.Name = dl.demangle(sym.SystemName)
+		if v.Type != nil {
+			sym.TypeName = v.Type.String()
+			sym.Field = elfutils.FieldPath(v.Type, int64(sym.Offset))
+		}
+		return sym, nil
+	}
+
+	s, err := dl.searcher.SearchSymbol(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &DataSymbol{
+		Name:       dl.demangle(s.Name),
+		SystemName: s.Name,
+		Start:      s.Value,
+		Size:       s.Size,
+		Offset:     addr - s.Value,
+	}, nil
+}
+
+// searchVariable finds the DWARF variable whose extent contains addr.
+func (dl *DataLiner) searchVariable(addr uint64) (elfutils.Variable, bool) {
+	i := sort.Search(len(dl.vars), func(i int) bool {
+		return dl.vars[i].Address > addr
+	})
+	for i--; i >= 0; i-- {
+		v := dl.vars[i]
+		if addr == v.Address || addr < v.Address+v.Size {
+			return v, true
+		}
+		// Variables don't overlap, except for aliases at the same address.
+		if i > 0 && dl.vars[i-1].Address != v.Address {
+			break
+		}
+	}
+	return elfutils.Variable{}, false
+}
+
+func (dl *DataLiner) demangle(name string) string {
+	fn := dl.demangler.Demangle(&pb.Function{SystemName: name})
+	if fn.Name == "" {
+		return name
+	}
+	return fn.Name
+}
@@ -0,0 +1,99 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package addr2line
+
+import (
+	"debug/elf"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
+)
+
+func TestDataLiner_DataSymbol(t *testing.T) {
+	filename := "testdata/data-c-with-debuginfo"
+	elfFile, err := elf.Open(filename)
+	require.NoError(t, err)
+	defer elfFile.Close()
+
+	lnr, err := Data(log.NewNopLogger(), filename, elfFile, demangle.NewDemangler("simple", false))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		addr uint64
+		want *DataSymbol
+	}{
+		{
+			name: "nested struct member",
+			addr: 0x404024,
+			want: &DataSymbol{
+				Name:       "global_table",
+				SystemName: "global_table",
+				TypeName:   "struct table",
+				Start:      0x404020,
+				Size:       72,
+				Offset:     4,
+				Field:      "hdr.flags",
+			},
+		},
+		{
+			name: "array element inside struct",
+			addr: 0x404038,
+			want: &DataSymbol{
+				Name:       "global_table",
+				SystemName: "global_table",
+				TypeName:   "struct table",
+				Start:      0x404020,
+				Size:       72,
+				Offset:     24,
+				Field:      "items[2]",
+			},
+		},
+		{
+			name: "static variable",
+			addr: 0x404068,
+			want: &DataSymbol{
+				Name:       "static_counter",
+				SystemName: "static_counter",
+				TypeName:   "int",
+				Start:      0x404068,
+				Size:       4,
+			},
+		},
+		{
+			name: "bss array element",
+			addr: 0x4040ac,
+			want: &DataSymbol{
+				Name:       "uninitialized_buffer",
+				SystemName: "uninitialized_buffer",
+				TypeName:   "[16]int",
+				Start:      0x4040a0,
+				Size:       64,
+				Offset:     12,
+				Field:      "[3]",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lnr.DataSymbol(tt.addr)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -16,12 +16,19 @@ package addr2line
 import (
 	"debug/dwarf"
 	"debug/elf"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"runtime/debug"
+	"sort"
+	"sync"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
 	"gitlab.com/Raven-IO/GoSymTable/symbol/elfutils"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/pathmap"
 
 	"gitlab.com/Raven-IO/GoSymTable/profile"
 )
@@ -30,20 +37,43 @@ import (
 type DwarfLiner struct {
 	logger log.Logger
 
+	// PathMapper rewrites the file names of the returned lines.
+	PathMapper *pathmap.Mapper
+
 	debugData *dwarf.Data
 	dbgFile   elfutils.DebugInfoFile
 	f         *elf.File
 	filename  string
+
+	rangesOnce sync.Once
+	ranges     [][2]uint64
+	rangesErr  error
+
+	typesOnce sync.Once
+	types     *elfutils.TypeIndex
+	typesErr  error
 }
 
 // DWARF creates a new DwarfLiner.
 func DWARF(logger log.Logger, filename string, f *elf.File, demangler *demangle.Demangler) (*DwarfLiner, error) {
+	return DWARFWithSearchPaths(logger, filename, f, demangler, nil)
+}
+
+// DWARFWithSearchPaths creates a new DwarfLiner which looks up the split DWARF
+// files (.dwo, .dwp) of executables built with -gsplit-dwarf in searchPaths,
+// in addition to the compilation directory and the directory of the executable.
+func DWARFWithSearchPaths(logger log.Logger, filename string, f *elf.File, demangler *demangle.Demangler, searchPaths []string) (*DwarfLiner, error) {
 	debugData, err := f.DWARF()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read DWARF data: %w", err)
 	}
 
-	dbgFile, err := elfutils.NewDebugInfoFile(debugData, demangler)
+	split, err := elfutils.NewSplitDWARF(filename, f, searchPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	dbgFile, err := elfutils.NewSplitDebugInfoFile(debugData, split, demangler)
 	if err != nil {
 		return nil, err
 	}
@@ -66,52 +96,193 @@ func (dl *DwarfLiner) File() string {
 }
 
 func (dl *DwarfLiner) PCRange() ([2]uint64, error) {
-	r := dl.debugData.Reader()
+	ranges, err := dl.PCRanges()
+	if err != nil {
+		return [2]uint64{}, err
+	}
+	if len(ranges) == 0 {
+		return [2]uint64{}, errors.New("no address ranges found")
+	}
+	return [2]uint64{ranges[0][0], ranges[len(ranges)-1][1]}, nil
+}
 
-	minSet := false
-	var min, max uint64
+// PCRanges returns the sorted list of address intervals [start, end) covered by the DWARF data.
+// The intervals are computed once, from .debug_aranges or the compile unit ranges.
+func (dl *DwarfLiner) PCRanges() ([][2]uint64, error) {
+	dl.rangesOnce.Do(func() {
+		dl.ranges, dl.rangesErr = elfutils.CompileUnitRanges(dl.f, dl.debugData)
+	})
+	return dl.ranges, dl.rangesErr
+}
+
+// PCToLines returns the resolved source lines for a program counter (memory address).
+func (dl *DwarfLiner) PCToLines(addr uint64) (lines []profile.LocationLine, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("recovered stack trace:\n", string(debug.Stack()))
+			err = fmt.Errorf("recovering from panic in DWARF add2line: %v", r)
+		}
+	}()
+
+	lines, err = dl.dbgFile.SourceLines(addr)
+	if err != nil {
+		return nil, err
+	}
+	return remapLines(dl.PathMapper, lines), nil
+}
+
+// LookupFunctions returns the subprograms with code whose name or linkage name is matched by m.
+// The split units of executables built with -gsplit-dwarf aren't searched.
+func (dl *DwarfLiner) LookupFunctions(m NameMatcher) ([]FuncMatch, error) {
+	var res []FuncMatch
+	r := dl.debugData.Reader()
 	for {
 		e, err := r.Next()
 		if err != nil {
-			return [2]uint64{}, fmt.Errorf("read DWARF entry: %w", err)
+			return nil, fmt.Errorf("failed to read DWARF entry: %w", err)
 		}
 		if e == nil {
 			break
 		}
+		if e.Tag != dwarf.TagSubprogram {
+			continue
+		}
+
+		name, _ := e.Val(dwarf.AttrName).(string)
+		linkageName, _ := e.Val(dwarf.AttrLinkageName).(string)
+		if !(name != "" && m(name)) && !(linkageName != "" && m(linkageName)) {
+			continue
+		}
 
 		ranges, err := dl.debugData.Ranges(e)
+		if err != nil || len(ranges) == 0 {
+			// Declarations and abstract instances of inlined functions have no code.
+			continue
+		}
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+		entry := ranges[0][0]
+		if f := e.AttrField(dwarf.AttrLowpc); f != nil && f.Class == dwarf.ClassAddress {
+			entry, _ = f.Val.(uint64)
+		}
+
+		systemName := linkageName
+		if systemName == "" {
+			systemName = name
+		}
+		res = append(res, FuncMatch{
+			Name:       name,
+			SystemName: systemName,
+			Entry:      entry,
+			Ranges:     ranges,
+		})
+	}
+	sortFuncMatches(res)
+	return res, nil
+}
+
+// LookupLine returns the address intervals of the code generated for line of file,
+// see matchFile, from the line tables. The file names are matched before and after
+// PathMapper rewrites them. Function is the outermost function of the interval.
+func (dl *DwarfLiner) LookupLine(file string, line int) ([]LineMatch, error) {
+	return dl.lookupLine(file, line, dl.PathMapper)
+}
+
+func (dl *DwarfLiner) lookupLine(file string, line int, mapper *pathmap.Mapper) ([]LineMatch, error) {
+	match := matchFile(file)
+	var res []LineMatch
+
+	r := dl.debugData.Reader()
+	for {
+		cu, err := r.Next()
 		if err != nil {
-			return [2]uint64{}, err
+			return nil, fmt.Errorf("failed to read DWARF entry: %w", err)
 		}
-		for _, pcs := range ranges {
-			if !minSet {
-				min = pcs[0]
-				minSet = true
-			}
-			if pcs[1] > max {
-				max = pcs[1]
+		if cu == nil {
+			break
+		}
+		if cu.Tag != dwarf.TagCompileUnit {
+			r.SkipChildren()
+			continue
+		}
+		lr, err := dl.debugData.LineReader(cu)
+		r.SkipChildren()
+		if err != nil || lr == nil {
+			continue
+		}
+
+		var (
+			prev     dwarf.LineEntry
+			havePrev bool
+		)
+		for {
+			var le dwarf.LineEntry
+			if err := lr.Next(&le); err != nil {
+				if !errors.Is(err, io.EOF) {
+					level.Debug(dl.logger).Log("msg", "failed to read line table", "err", err)
+				}
+				break
 			}
-			if pcs[0] < min {
-				min = pcs[0]
+			if havePrev && prev.Line == line && prev.File != nil && le.Address > prev.Address &&
+				(match(prev.File.Name) || match(mapper.Remap(prev.File.Name))) {
+				name := mapper.Remap(prev.File.Name)
+				if n := len(res); n > 0 && res[n-1].End == prev.Address && res[n-1].File == name {
+					res[n-1].End = le.Address
+				} else {
+					res = append(res, LineMatch{File: name, Line: line, Start: prev.Address, End: le.Address})
+				}
 			}
+			prev, havePrev = le, !le.EndSequence
 		}
 	}
 
-	return [2]uint64{min, max}, nil
+	sortLineMatches(res)
+	for i := range res {
+		if lines, err := dl.dbgFile.SourceLines(res[i].Start); err == nil && len(lines) > 0 && lines[0].Function != nil {
+			res[i].Function = lines[0].Function.Name
+		}
+	}
+	return res, nil
 }
 
-// PCToLines returns the resolved source lines for a program counter (memory address).
-func (dl *DwarfLiner) PCToLines(addr uint64) (lines []profile.LocationLine, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("recovered stack trace:\n", string(debug.Stack()))
-			err = fmt.Errorf("recovering from panic in DWARF add2line: %v", r)
-		}
-	}()
+// Parameters returns the formal parameters of the function named name and their locations,
+// see elfutils.FunctionParameters. The split units of executables built with -gsplit-dwarf
+// aren't searched.
+func (dl *DwarfLiner) Parameters(name string) ([]elfutils.Parameter, error) {
+	return elfutils.FunctionParameters(dl.f, dl.debugData, name)
+}
 
-	lines, err = dl.dbgFile.SourceLines(addr)
+// Types returns the index of the named types of the DWARF data, built on first use.
+func (dl *DwarfLiner) Types() (*elfutils.TypeIndex, error) {
+	dl.typesOnce.Do(func() {
+		dl.types, dl.typesErr = elfutils.NewTypeIndex(dl.debugData)
+	})
+	return dl.types, dl.typesErr
+}
+
+// FieldOffset returns the offset, the size and the type of a field, e.g. "runtime.g.goid",
+// see elfutils.TypeIndex.FieldOffset.
+func (dl *DwarfLiner) FieldOffset(query string) (elfutils.FieldOffset, error) {
+	types, err := dl.Types()
 	if err != nil {
-		return nil, err
+		return elfutils.FieldOffset{}, err
+	}
+	return types.FieldOffset(query)
+}
+
+// WriteFieldOffsets writes the offsets of the fields of queries as a JSON object keyed by query,
+// e.g. {"runtime.g.goid": {"offset": 152, "size": 8, "type": "uint64"}}. The queries that can't
+// be resolved are left out of the table and reported in the returned error.
+func (dl *DwarfLiner) WriteFieldOffsets(w io.Writer, queries []string) error {
+	types, err := dl.Types()
+	if err != nil {
+		return err
+	}
+	table, resolveErr := types.FieldOffsets(queries)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(table); err != nil {
+		return fmt.Errorf("failed to write field offsets: %w", err)
 	}
-	return lines, nil
+	return resolveErr
 }
@@ -15,12 +15,16 @@
 package addr2line
 
 import (
+	"bytes"
 	"debug/elf"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/go-kit/log"
 	"github.com/stretchr/testify/require"
 	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/elfutils"
 
 	metastorev1alpha1 "gitlab.com/Raven-IO/GoSymTable/protogen/go/metastore"
 )
@@ -49,3 +53,277 @@ func TestDwarfSymbolizer(t *testing.T) {
 		Filename: "src/basic-cpp.cpp",
 	}, gotLines[0].Function)
 }
+
+func TestDwarfSymbolizerSplitDWARF(t *testing.T) {
+	logger := log.NewNopLogger()
+	demangler := demangle.NewDemangler("simple", true)
+
+	for _, filename := range []string{
+		// Split units in split-dwarf.dwo.
+		"testdata/split-dwarf-cpp",
+		// DWARF 4 split units with the GNU forms, packaged in split-dwarf-dwp-cpp.dwp.
+		"testdata/split-dwarf-dwp-cpp",
+	} {
+		t.Run(filename, func(t *testing.T) {
+			elfFile, err := elf.Open(filename)
+			require.NoError(t, err)
+			defer elfFile.Close()
+
+			dwarf, err := DWARFWithSearchPaths(logger, filename, elfFile, demangler, []string{"testdata"})
+			require.NoError(t, err)
+
+			gotLines, err := dwarf.PCToLines(0x40110a)
+			require.NoError(t, err)
+			require.Equal(t, &metastorev1alpha1.Function{
+				Name:     "leaf",
+				Filename: "split-dwarf.cpp",
+			}, gotLines[0].Function)
+
+			gotLines, err = dwarf.PCToLines(0x401120)
+			require.NoError(t, err)
+			require.Equal(t, &metastorev1alpha1.Function{
+				Name:     "middle",
+				Filename: "split-dwarf.cpp",
+			}, gotLines[0].Function)
+		})
+	}
+}
+
+func TestDwarfSymbolizerSplitDWARFMissing(t *testing.T) {
+	// Without split-dwarf.dwo next to it.
+	b, err := os.ReadFile("testdata/split-dwarf-cpp")
+	require.NoError(t, err)
+	filename := filepath.Join(t.TempDir(), "split-dwarf-cpp")
+	require.NoError(t, os.WriteFile(filename, b, 0o600))
+
+	elfFile, err := elf.Open(filename)
+	require.NoError(t, err)
+	defer elfFile.Close()
+
+	dwarf, err := DWARF(log.NewNopLogger(), filename, elfFile, demangle.NewDemangler("simple", true))
+	require.NoError(t, err)
+
+	// The failure is the same for every address of the unit.
+	_, err = dwarf.PCToLines(0x40110a)
+	require.ErrorContains(t, err, "failed to find split DWARF file split-dwarf.dwo")
+	_, again := dwarf.PCToLines(0x401120)
+	require.Equal(t, err, again)
+}
+
+func TestDwarfSymbolizerLTO(t *testing.T) {
+	filename := "testdata/lto-cpp"
+	elfFile, err := elf.Open(filename)
+	require.NoError(t, err)
+	defer elfFile.Close()
+
+	dwarf, err := DWARF(log.NewNopLogger(), filename, elfFile, demangle.NewDemangler("simple", true))
+	require.NoError(t, err)
+
+	// The functions of the <artificial> compile unit refer to the ones of main.cpp and
+	// lib.cpp, Scaler::scale to its declaration in the class.
+	for pc, name := range map[uint64]string{0x401020: "main", 0x401140: "scale"} {
+		lines, err := dwarf.PCToLines(pc)
+		require.NoError(t, err)
+		require.Equal(t, name, lines[0].Function.Name)
+	}
+}
+
+func TestDwarfLiner_PCRange(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		filename string
+		want     [][2]uint64
+	}{
+		{
+			name:     "aranges",
+			filename: "testdata/basic-cpp-no-fp-with-debuginfo",
+			want:     [][2]uint64{{0x401106, 0x401174}},
+		},
+		{
+			// The ranges of the compile unit entries.
+			name:     "no aranges",
+			filename: "testdata/aranges-c",
+			want:     [][2]uint64{{0x401106, 0x401122}},
+		},
+		{
+			// other.c isn't listed by .debug_aranges, main.c is.
+			name:     "partial aranges",
+			filename: "testdata/aranges-c-partial",
+			want:     [][2]uint64{{0x401106, 0x401125}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			elfFile, err := elf.Open(tc.filename)
+			require.NoError(t, err)
+			defer elfFile.Close()
+
+			dwarf, err := DWARF(log.NewNopLogger(), tc.filename, elfFile, demangle.NewDemangler("simple", true))
+			require.NoError(t, err)
+
+			ranges, err := dwarf.PCRanges()
+			require.NoError(t, err)
+			require.Equal(t, tc.want, ranges)
+
+			pcRange, err := dwarf.PCRange()
+			require.NoError(t, err)
+			require.Equal(t, [2]uint64{tc.want[0][0], tc.want[len(tc.want)-1][1]}, pcRange)
+		})
+	}
+}
+
+func TestDwarfLiner_Lookup(t *testing.T) {
+	filename := "testdata/basic-cpp-no-fp-with-debuginfo"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+
+	dl, err := DWARF(log.NewNopLogger(), filename, f, demangle.NewDemangler("simple", true))
+	require.NoError(t, err)
+	defer dl.Close()
+
+	funcs, err := dl.LookupFunctions(MatchExact("top2"))
+	require.NoError(t, err)
+	require.Len(t, funcs, 1)
+	require.Equal(t, "top2", funcs[0].Name)
+	require.LessOrEqual(t, funcs[0].Ranges[0][0], uint64(0x401125))
+	require.Greater(t, funcs[0].Ranges[0][1], uint64(0x401125))
+
+	// Line 8, the opening brace, has no code, line 10 has several entries.
+	lines, err := dl.LookupLine("basic-cpp.cpp", 8)
+	require.NoError(t, err)
+	require.Empty(t, lines)
+	lines, err = dl.LookupLine("basic-cpp.cpp", 10)
+	require.NoError(t, err)
+	require.Equal(t, []LineMatch{
+		{File: "src/basic-cpp.cpp", Line: 10, Function: "top2", Start: 0x401125, End: 0x40113e},
+	}, lines)
+}
+
+func TestDwarfLiner_Parameters(t *testing.T) {
+	reg := func(r uint64) []elfutils.LocationPiece {
+		return []elfutils.LocationPiece{{Kind: elfutils.LocationRegister, Register: r}}
+	}
+
+	// System V ABI: rdi, rsi, rdx, rcx, r8, r9, then the stack above the return address.
+	for _, filename := range []string{"testdata/params-c", "testdata/params-c-dwarf4"} {
+		t.Run(filename, func(t *testing.T) {
+			f, err := elf.Open(filename)
+			require.NoError(t, err)
+			dl, err := DWARF(log.NewNopLogger(), filename, f, nil)
+			require.NoError(t, err)
+			defer dl.Close()
+
+			params, err := dl.Parameters("spill")
+			require.NoError(t, err)
+			var names []string
+			for _, p := range params {
+				names = append(names, p.Name)
+			}
+			require.Equal(t, []string{"a", "b", "c", "d", "e", "f", "g", "p"}, names)
+
+			const entry = 0x401160
+			want := [][]elfutils.LocationPiece{
+				reg(5), reg(4), reg(1), reg(2), reg(8), reg(9),
+				{{Kind: elfutils.LocationFrameBase, Offset: 0}},
+				{{Kind: elfutils.LocationFrameBase, Offset: 8}},
+			}
+			for i, p := range params {
+				loc, ok := p.LocationAt(entry)
+				require.True(t, ok, p.Name)
+				require.Equal(t, want[i], loc.Pieces, p.Name)
+			}
+			require.Equal(t, "Rdi", elfutils.RegisterName(f.Machine, params[0].Locations[0].Pieces[0].Register))
+
+			// The struct is split between two registers later on.
+			loc, ok := params[7].LocationAt(0x401180)
+			require.True(t, ok)
+			require.Equal(t, []elfutils.LocationPiece{
+				{Kind: elfutils.LocationRegister, Register: 2, Size: 8},
+				{Kind: elfutils.LocationRegister, Register: 1, Size: 8},
+			}, loc.Pieces)
+
+			_, err = dl.Parameters("missing")
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDwarfLiner_ParametersGo(t *testing.T) {
+	filename := "testdata/cgo-go"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+	dl, err := DWARF(log.NewNopLogger(), filename, f, nil)
+	require.NoError(t, err)
+	defer dl.Close()
+
+	// ABIInternal on amd64: rax, rbx, rcx, rdi, rsi, r8, r9, r10, r11.
+	params, err := dl.Parameters("runtime.concatstring2")
+	require.NoError(t, err)
+	require.Len(t, params, 4)
+
+	const entry = 0x45f660
+	loc, ok := params[1].LocationAt(entry)
+	require.True(t, ok)
+	require.Equal(t, "a0", params[1].Name)
+	require.Equal(t, []elfutils.LocationPiece{
+		{Kind: elfutils.LocationRegister, Register: 3, Size: 8},
+		{Kind: elfutils.LocationRegister, Register: 2, Size: 8},
+	}, loc.Pieces)
+
+	require.Equal(t, "~r0", params[3].Name)
+	require.True(t, params[3].Result)
+	require.Empty(t, params[3].Locations)
+}
+
+func TestDwarfLiner_FieldOffsets(t *testing.T) {
+	filename := "testdata/cgo-go"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+	dl, err := DWARF(log.NewNopLogger(), filename, f, nil)
+	require.NoError(t, err)
+	defer dl.Close()
+
+	tests := []struct {
+		query   string
+		want    elfutils.FieldOffset
+		wantErr string
+	}{
+		{query: "runtime.g.goid", want: elfutils.FieldOffset{Offset: 152, Size: 8, Type: "uint64"}},
+		{query: "runtime.m.curg", want: elfutils.FieldOffset{Offset: 184, Size: 8, Type: "*runtime.g"}},
+		{query: "runtime.g.sched.sp", want: elfutils.FieldOffset{Offset: 56, Size: 8, Type: "uintptr"}},
+		{query: "runtime.p.runq[1]", want: elfutils.FieldOffset{Offset: 416, Size: 8, Type: "runtime.guintptr"}},
+		{query: "runtime.g", want: elfutils.FieldOffset{Offset: 0, Size: 456, Type: "runtime.g"}},
+		{query: "runtime.g.nope", wantErr: "runtime.g has no field nope"},
+		{query: "runtime.m.curg.goid", wantErr: "*runtime.g isn't a struct, can't select goid"},
+		{query: "runtime.p.runq[256]", wantErr: "index 256 out of range of [256]runtime.guintptr"},
+		{query: "nope.x", wantErr: "no type found for nope.x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := dl.FieldOffset(tt.query)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	var buf bytes.Buffer
+	err = dl.WriteFieldOffsets(&buf, []string{"runtime.g.goid", "runtime.g.nope"})
+	require.EqualError(t, err, "runtime.g.nope: runtime.g has no field nope")
+	require.JSONEq(t, `{"runtime.g.goid": {"offset": 152, "size": 8, "type": "uint64"}}`, buf.String())
+}
+
+func TestDwarfLiner_FieldOffsetsC(t *testing.T) {
+	filename := "testdata/params-c"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+	dl, err := DWARF(log.NewNopLogger(), filename, f, nil)
+	require.NoError(t, err)
+	defer dl.Close()
+
+	got, err := dl.FieldOffset("pair.b")
+	require.NoError(t, err)
+	require.Equal(t, elfutils.FieldOffset{Offset: 8, Size: 8, Type: "long int"}, got)
+}
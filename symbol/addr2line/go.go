@@ -14,16 +14,24 @@
 package addr2line
 
 import (
+	"debug/buildinfo"
 	"debug/elf"
 	"debug/gosym"
 	"errors"
 	"fmt"
+	"path"
 	"runtime/debug"
+	"strings"
+	"sync"
 
 	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 
 	"gitlab.com/Raven-IO/GoSymTable/profile"
 	pb "gitlab.com/Raven-IO/GoSymTable/protogen/go/metastore"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/elfutils"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/goname"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/pathmap"
 )
 
 // GoLiner is a liner which utilizes .gopclntab section to symbolize addresses.
@@ -31,27 +39,112 @@ import (
 type GoLiner struct {
 	logger log.Logger
 
-	Symtab   *gosym.Table
+	// Symtab is nil for liners created with GoLazy.
+	Symtab *gosym.Table
+	// Version is the detected version of the Go toolchain that built the binary.
+	Version elfutils.GoVersion
+	// Funcs holds the metadata of the functions, nil if the _func records can't be decoded.
+	Funcs *elfutils.GoFuncTable
+	// DropWrappers makes StackToLines elide wrapper frames the same way the Go runtime's traceback does.
+	DropWrappers bool
+	// PathMapper rewrites the file names of the returned lines, see GoPaths.
+	PathMapper *pathmap.Mapper
+
+	// lazy replaces Symtab and Funcs for liners created with GoLazy.
+	lazy    *elfutils.GoLazyTable
+	mapping *elfutils.MappedFile
+	// pclntab is kept for the reverse lookups of liners created with Go.
+	pclntab *elfutils.GoPclntab
+
+	tableOnce sync.Once
+	tab       *elfutils.GoLazyTable
+	tableErr  error
+
 	f        *elf.File
 	filename string
 }
 
 // Go creates a new GoLiner.
 func Go(logger log.Logger, filename string, f *elf.File) (*GoLiner, error) {
-	tab, err := gosymtab(f)
+	logger = log.With(logger, "liner", "go")
+
+	version, err := elfutils.DetectGoVersion(f)
+	if err != nil {
+		level.Debug(logger).Log("msg", "failed to detect Go version", "err", err)
+	}
+
+	pclntab, err := elfutils.FindGoPclntab(f)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create go symbtab: %w", err)
 	}
 
+	tab, err := gosymtab(f, pclntab, version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create go symbtab: %w", err)
+	}
+
+	funcs, err := elfutils.NewGoFuncTable(pclntab, version, f.ByteOrder)
+	if err != nil {
+		level.Debug(logger).Log("msg", "failed to decode function metadata", "err", err)
+	}
+
 	return &GoLiner{
-		logger:   log.With(logger, "liner", "go"),
+		logger:   logger,
 		Symtab:   tab,
+		Version:  version,
+		Funcs:    funcs,
+		pclntab:  pclntab,
+		f:        f,
+		filename: filename,
+	}, nil
+}
+
+// GoLazy creates a new GoLiner for very large binaries. The file is memory mapped and
+// the pclntab is decoded on demand for each looked up address, instead of being copied to
+// the heap and fully decoded. Symtab and Funcs are nil, the other fields and methods work
+// the same as for a liner created with Go, see LookupFunc for the functions of Symtab.
+func GoLazy(logger log.Logger, filename string, f *elf.File) (*GoLiner, error) {
+	logger = log.With(logger, "liner", "go")
+
+	mapping, err := elfutils.MmapFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map file: %w", err)
+	}
+
+	version, err := elfutils.MapGoVersion(f, mapping.Data)
+	if err != nil {
+		level.Debug(logger).Log("msg", "failed to detect Go version", "err", err)
+	}
+
+	pclntab, err := elfutils.MapGoPclntab(f, mapping.Data)
+	if err != nil {
+		mapping.Close()
+		return nil, fmt.Errorf("failed to find go pclntab: %w", err)
+	}
+
+	lazy, err := elfutils.NewGoLazyTable(pclntab, version, f.ByteOrder)
+	if err != nil {
+		mapping.Close()
+		return nil, fmt.Errorf("failed to decode go pclntab: %w", err)
+	}
+
+	return &GoLiner{
+		logger:   logger,
+		Version:  version,
+		lazy:     lazy,
+		mapping:  mapping,
 		f:        f,
 		filename: filename,
 	}, nil
 }
 
 func (gl *GoLiner) Close() error {
+	if gl.mapping != nil {
+		if err := gl.mapping.Close(); err != nil {
+			gl.f.Close()
+			return err
+		}
+	}
 	return gl.f.Close()
 }
 
@@ -60,6 +153,10 @@ func (gl *GoLiner) File() string {
 }
 
 func (gl *GoLiner) PCRange() ([2]uint64, error) {
+	if gl.lazy != nil {
+		return gl.lazy.PCRange(), nil
+	}
+
 	minSet := false
 	var minAddr, maxAddr uint64
 
@@ -77,7 +174,16 @@ func (gl *GoLiner) PCRange() ([2]uint64, error) {
 }
 
 // PCToLines looks up the line number information for a program counter (memory address).
-func (gl *GoLiner) PCToLines(addr uint64) (lines []profile.LocationLine, err error) {
+func (gl *GoLiner) PCToLines(addr uint64) ([]profile.LocationLine, error) {
+	lines, err := gl.pcToLines(addr)
+	if err != nil {
+		return nil, err
+	}
+	return remapLines(gl.PathMapper, lines), nil
+}
+
+// pcToLines is PCToLines without rewriting the file names.
+func (gl *GoLiner) pcToLines(addr uint64) (lines []profile.LocationLine, err error) {
 	defer func() {
 		// PCToLine panics with "invalid memory address or nil pointer dereference",
 		//	- when it refers to an address that doesn't actually exist.
@@ -88,10 +194,21 @@ func (gl *GoLiner) PCToLines(addr uint64) (lines []profile.LocationLine, err err
 	}()
 
 	name := "?"
+	var (
+		file string
+		line int
+	)
 	// TODO(kakkoyun): Do we need to consider the base address for any part of Go binaries?
-	file, line, fn := gl.Symtab.PCToLine(addr)
-	if fn != nil {
-		name = fn.Name
+	if gl.lazy != nil {
+		if f, l, fn, ok := gl.lazy.PCToLine(addr); ok {
+			file, line, name = f, l, fn
+		}
+	} else {
+		var fn *gosym.Func
+		file, line, fn = gl.Symtab.PCToLine(addr)
+		if fn != nil {
+			name = fn.Name
+		}
 	}
 
 	// TODO(kakkoyun): These lines miss the inline functions.
@@ -106,40 +223,243 @@ func (gl *GoLiner) PCToLines(addr uint64) (lines []profile.LocationLine, err err
 	return lines, nil
 }
 
-// gosymtab returns the Go symbol table (.gosymtab section) decoded from the ELF file.
-func gosymtab(objFile *elf.File) (*gosym.Table, error) {
-	// The .gopclntab section contains tables and meta data required for symbolization,
-	// see https://github.com/DataDog/go-profiler-notes/blob/main/stack-traces.md#gopclntab.
-	var err error
-	var pclntab []byte
-	if sec := objFile.Section(".gopclntab"); sec != nil {
-		if sec.Type == elf.SHT_NOBITS {
-			return nil, errors.New(".gopclntab section has no bits")
+// FuncInfo returns the metadata of the function containing addr.
+func (gl *GoLiner) FuncInfo(addr uint64) (elfutils.GoFunc, bool) {
+	if gl.lazy != nil {
+		return gl.lazy.FuncAt(addr)
+	}
+	if gl.Funcs == nil {
+		return elfutils.GoFunc{}, false
+	}
+	return gl.Funcs.Lookup(addr)
+}
+
+// StackToLines symbolizes the program counters of a stack, leaf first.
+// If DropWrappers is set, the frames of wrapper functions are left out unless they call
+// gopanic, sigpanic or panicwrap, as runtime.elideWrapperCalling does.
+func (gl *GoLiner) StackToLines(stack []uint64) ([][]profile.LocationLine, error) {
+	res := make([][]profile.LocationLine, 0, len(stack))
+	callee := elfutils.GoFuncIDNormal
+	for _, addr := range stack {
+		fn, ok := gl.FuncInfo(addr)
+		if gl.DropWrappers && ok && fn.IsWrapper() && elideWrapperCalling(callee) {
+			callee = fn.FuncID
+			continue
+		}
+		callee = elfutils.GoFuncIDNormal
+		if ok {
+			callee = fn.FuncID
 		}
 
-		pclntab, err = sec.Data()
+		lines, err := gl.PCToLines(addr)
 		if err != nil {
-			return nil, fmt.Errorf("could not find .gopclntab section: %w", err)
+			return nil, err
 		}
+		res = append(res, lines)
 	}
+	return res, nil
+}
+
+// elideWrapperCalling reports whether a wrapper frame calling a function with the given funcID is hidden.
+// Wrappers that panicked are kept, as they point at the culprit, e.g. a nil pointer receiver.
+func elideWrapperCalling(callee elfutils.GoFuncID) bool {
+	return callee != "gopanic" && callee != "sigpanic" && callee != "panicwrap"
+}
 
-	if len(pclntab) <= 0 {
-		return nil, errors.New(".gopclntab section has no bits")
+// GoPaths returns a rule normalizing the file names of the binary to "module@version/relpath",
+// see pathmap.GoModules. The GOROOT and the directory of the main module are inferred from
+// the file names of the runtime and of the main module packages.
+func (gl *GoLiner) GoPaths() pathmap.Rule {
+	bi, err := buildinfo.ReadFile(gl.filename)
+	if err != nil {
+		level.Debug(gl.logger).Log("msg", "failed to read build info", "err", err)
+		bi = &debug.BuildInfo{GoVersion: gl.Version.Raw}
+	}
+	t, err := gl.table()
+	if err != nil {
+		level.Debug(gl.logger).Log("msg", "failed to infer the source directories", "err", err)
+		return pathmap.GoModules(bi, "", "")
 	}
 
-	var symtab []byte
-	if sec := objFile.Section(".gosymtab"); sec != nil {
-		symtab, _ = sec.Data()
+	var goroot, mainDir string
+	main := bi.Main.Path
+	for i := 0; i < t.Len() && (goroot == "" || main != "" && mainDir == ""); i++ {
+		fn, ok := t.Func(i)
+		if !ok {
+			continue
+		}
+		pkg := goname.Parse(fn.Name).ImportPath
+		if goroot == "" && pkg == "runtime" {
+			file, _, _, _ := t.PCToLine(fn.Entry)
+			if root, ok := strings.CutSuffix(file, "/src/runtime/proc.go"); ok {
+				goroot = root
+			}
+			continue
+		}
+		if main == "" || mainDir != "" {
+			continue
+		}
+		if pkg == "main" {
+			// The symbols of the main package are prefixed by "main", not by its import path.
+			pkg = bi.Path
+		}
+		if pkg != main && !strings.HasPrefix(pkg, main+"/") {
+			continue
+		}
+		file, _, _, _ := t.PCToLine(fn.Entry)
+		if !strings.HasPrefix(file, "/") {
+			// Built with -trimpath, the file names are import paths.
+			main = ""
+			continue
+		}
+		// The package directory is the module directory followed by the package path in the module.
+		dir := path.Dir(file)
+		if rel := strings.TrimPrefix(pkg, main); strings.HasSuffix(dir, rel) {
+			mainDir = strings.TrimSuffix(dir, rel)
+		}
 	}
+	return pathmap.GoModules(bi, goroot, mainDir)
+}
 
-	var text uint64
-	if sec := objFile.Section(".text"); sec != nil {
-		text = sec.Addr
+// gosymtab returns the Go symbol table (.gosymtab section) decoded from the ELF file.
+//
+// The .gopclntab section contains tables and meta data required for symbolization,
+// see https://github.com/DataDog/go-profiler-notes/blob/main/stack-traces.md#gopclntab.
+// Stripped and externally linked binaries may not have it, hence the lookup by the caller.
+func gosymtab(objFile *elf.File, pclntab *elfutils.GoPclntab, version elfutils.GoVersion) (*gosym.Table, error) {
+	// Only Go 1.2 and earlier fill .gosymtab, later versions keep everything in the pclntab.
+	// The section is still read when the version is unknown.
+	var symtab []byte
+	if version.Major == 0 || !version.AtLeast(1, 3) {
+		if sec := objFile.Section(".gosymtab"); sec != nil {
+			symtab, _ = sec.Data()
+		}
 	}
 
-	table, err := gosym.NewTable(symtab, gosym.NewLineTable(pclntab, text))
+	table, err := gosym.NewTable(symtab, gosym.NewLineTable(pclntab.Data, pclntab.TextStart))
 	if err != nil {
 		return nil, fmt.Errorf("failed to build symtab or pclinetab: %w", err)
 	}
 	return table, nil
 }
+
+// table returns the pclntab decoder used by the reverse lookups.
+// The liners created with Go build it once, on first use.
+func (gl *GoLiner) table() (*elfutils.GoLazyTable, error) {
+	if gl.lazy != nil {
+		return gl.lazy, nil
+	}
+	gl.tableOnce.Do(func() {
+		gl.tab, gl.tableErr = elfutils.NewGoLazyTable(gl.pclntab, gl.Version, gl.f.ByteOrder)
+		if gl.tableErr != nil {
+			gl.tableErr = fmt.Errorf("failed to decode go pclntab: %w", gl.tableErr)
+		}
+	})
+	return gl.tab, gl.tableErr
+}
+
+// LookupFunctions returns the functions of the pclntab whose name is matched by m.
+func (gl *GoLiner) LookupFunctions(m NameMatcher) ([]FuncMatch, error) {
+	t, err := gl.table()
+	if err != nil {
+		return nil, err
+	}
+
+	var res []FuncMatch
+	for i := 0; i < t.Len(); i++ {
+		fn, ok := t.Func(i)
+		if !ok || !m(fn.Name) {
+			continue
+		}
+		res = append(res, FuncMatch{
+			Name:       fn.Name,
+			SystemName: fn.Name,
+			Entry:      fn.Entry,
+			Ranges:     [][2]uint64{{fn.Entry, fn.End}},
+		})
+	}
+	return res, nil
+}
+
+// LookupLine returns the address intervals of the code generated for line of file,
+// see matchFile. The file names are matched before and after PathMapper rewrites them.
+func (gl *GoLiner) LookupLine(file string, line int) ([]LineMatch, error) {
+	return gl.lookupLine(file, line, gl.PathMapper)
+}
+
+func (gl *GoLiner) lookupLine(file string, line int, mapper *pathmap.Mapper) ([]LineMatch, error) {
+	t, err := gl.table()
+	if err != nil {
+		return nil, err
+	}
+
+	match := matchFile(file)
+	var res []LineMatch
+	for _, r := range t.LineRanges(func(name string) bool {
+		return match(name) || match(mapper.Remap(name))
+	}, line) {
+		res = append(res, LineMatch{
+			File:     mapper.Remap(r.File),
+			Line:     r.Line,
+			Function: r.Func,
+			Start:    r.Start,
+			End:      r.End,
+		})
+	}
+	return res, nil
+}
+
+// ProbeSites are the file offsets of a Go function to attach uprobes to.
+type ProbeSites struct {
+	// Entry is the offset of the first instruction of the function.
+	Entry uint64
+	// Returns are the offsets of the return instructions, in address order.
+	Returns []uint64
+}
+
+// LookupFunc returns the function named name, like Symtab.LookupFunc but also for liners
+// created with GoLazy, or nil if there is none.
+func (gl *GoLiner) LookupFunc(name string) *gosym.Func {
+	if gl.Symtab != nil {
+		return gl.Symtab.LookupFunc(name)
+	}
+	for i := 0; i < gl.lazy.Len(); i++ {
+		fn, ok := gl.lazy.Func(i)
+		if !ok || fn.Name != name {
+			continue
+		}
+		return &gosym.Func{
+			Entry: fn.Entry,
+			End:   fn.End,
+			Sym:   &gosym.Sym{Value: fn.Entry, Type: 'T', Name: fn.Name},
+		}
+	}
+	return nil
+}
+
+// ProbeSites disassembles fn, a function of Symtab or returned by LookupFunc, and returns the file offsets of its
+// entry and of its return instructions, see elfutils.ReturnAddrs. Only amd64 and arm64
+// binaries are supported.
+func (gl *GoLiner) ProbeSites(fn *gosym.Func) (ProbeSites, error) {
+	if fn == nil {
+		return ProbeSites{}, errors.New("no function to probe")
+	}
+	entry, err := elfutils.FileOffset(gl.f, fn.Entry)
+	if err != nil {
+		return ProbeSites{}, err
+	}
+	addrs, err := elfutils.ReturnAddrs(gl.f, fn.Entry, fn.End)
+	if err != nil {
+		return ProbeSites{}, fmt.Errorf("failed to find the return instructions of %s: %w", fn.Name, err)
+	}
+
+	sites := ProbeSites{Entry: entry, Returns: make([]uint64, 0, len(addrs))}
+	for _, addr := range addrs {
+		off, err := elfutils.FileOffset(gl.f, addr)
+		if err != nil {
+			return ProbeSites{}, err
+		}
+		sites.Returns = append(sites.Returns, off)
+	}
+	return sites, nil
+}
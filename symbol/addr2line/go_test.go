@@ -0,0 +1,241 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"debug/elf"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/Raven-IO/GoSymTable/symbol/pathmap"
+)
+
+func TestGoLiner_StackToLines(t *testing.T) {
+	filename := "../elfutils/testdata/main"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+
+	liner, err := Go(log.NewNopLogger(), filename, f)
+	require.NoError(t, err)
+	defer liner.Close()
+
+	const (
+		gopanic = 0x430f90
+		wrapper = 0x45efd0 // runtime.(*errorString).Error, autogenerated.
+		main    = 0x480ef0
+	)
+
+	fn, ok := liner.FuncInfo(wrapper)
+	require.True(t, ok)
+	require.True(t, fn.IsWrapper())
+
+	names := func(stack []uint64) []string {
+		frames, err := liner.StackToLines(stack)
+		require.NoError(t, err)
+		var res []string
+		for _, lines := range frames {
+			res = append(res, lines[0].Function.Name)
+		}
+		return res
+	}
+
+	require.Equal(t, []string{"runtime.(*errorString).Error", "main.main"}, names([]uint64{wrapper, main}))
+
+	liner.DropWrappers = true
+	require.Equal(t, []string{"main.main"}, names([]uint64{wrapper, main}))
+	// A wrapper which panicked is kept.
+	require.Equal(t, []string{"runtime.gopanic", "runtime.(*errorString).Error", "main.main"}, names([]uint64{gopanic, wrapper, main}))
+}
+
+func TestGoLazy(t *testing.T) {
+	for _, filename := range []string{"../elfutils/testdata/main", "testdata/cgo-go"} {
+		t.Run(filename, func(t *testing.T) {
+			f, err := elf.Open(filename)
+			require.NoError(t, err)
+			eager, err := Go(log.NewNopLogger(), filename, f)
+			require.NoError(t, err)
+			defer eager.Close()
+
+			f, err = elf.Open(filename)
+			require.NoError(t, err)
+			lazy, err := GoLazy(log.NewNopLogger(), filename, f)
+			require.NoError(t, err)
+			defer lazy.Close()
+			require.Nil(t, lazy.Symtab)
+			require.Equal(t, eager.Version, lazy.Version)
+
+			want, err := eager.PCRange()
+			require.NoError(t, err)
+			got, err := lazy.PCRange()
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+
+			// Every function, at its entry and in its middle, decodes the same as with debug/gosym.
+			for _, fn := range eager.Symtab.Funcs {
+				for _, pc := range []uint64{fn.Entry, fn.Entry + (fn.End-fn.Entry)/2} {
+					want, err := eager.PCToLines(pc)
+					require.NoError(t, err)
+					got, err := lazy.PCToLines(pc)
+					require.NoError(t, err)
+					if want[0].Line < 0 {
+						// debug/gosym decodes the start of the pctab for functions without a line table.
+						require.Equal(t, int64(-1), got[0].Line, "pc %#x", pc)
+						require.Equal(t, want[0].Function.Name, got[0].Function.Name, "pc %#x", pc)
+					} else {
+						require.Equal(t, want, got, "pc %#x", pc)
+					}
+
+					wantFn, _ := eager.FuncInfo(pc)
+					gotFn, _ := lazy.FuncInfo(pc)
+					require.Equal(t, wantFn, gotFn, "pc %#x", pc)
+				}
+			}
+
+			// Outside of the Go text.
+			lines, err := lazy.PCToLines(want[1] + 0x1000)
+			require.NoError(t, err)
+			require.Equal(t, "?", lines[0].Function.Name)
+		})
+	}
+}
+
+func TestGoLiner_GoPaths(t *testing.T) {
+	filename := "../elfutils/testdata/main"
+	for name, newLiner := range map[string]func(log.Logger, string, *elf.File) (*GoLiner, error){"eager": Go, "lazy": GoLazy} {
+		t.Run(name, func(t *testing.T) {
+			f, err := elf.Open(filename)
+			require.NoError(t, err)
+
+			liner, err := newLiner(log.NewNopLogger(), filename, f)
+			require.NoError(t, err)
+			defer liner.Close()
+
+			liner.PathMapper = pathmap.New(liner.GoPaths())
+
+			lines, err := liner.PCToLines(0x430f90) // runtime.gopanic
+			require.NoError(t, err)
+			require.Equal(t, "std@go1.19.1/runtime/panic.go", lines[0].Function.Filename)
+
+			// Built as command-line-arguments, the main package isn't part of a module.
+			lines, err = liner.PCToLines(0x480ef0)
+			require.NoError(t, err)
+			require.Equal(t, "/Users/brancz/src/github.com/parca-dev/parca/pkg/symbol/elfutils/testdata/main.go", lines[0].Function.Filename)
+		})
+	}
+}
+
+func TestGoLiner_Lookup(t *testing.T) {
+	filename := "testdata/cgo-go"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+	eager, err := Go(log.NewNopLogger(), filename, f)
+	require.NoError(t, err)
+	defer eager.Close()
+
+	f, err = elf.Open(filename)
+	require.NoError(t, err)
+	lazy, err := GoLazy(log.NewNopLogger(), filename, f)
+	require.NoError(t, err)
+	defer lazy.Close()
+
+	for _, gl := range []*GoLiner{eager, lazy} {
+		funcs, err := gl.LookupFunctions(MatchExact("main.goCaller"))
+		require.NoError(t, err)
+		require.Equal(t, []FuncMatch{{
+			Name:       "main.goCaller",
+			SystemName: "main.goCaller",
+			Entry:      0x4813c0,
+			Ranges:     [][2]uint64{{0x4813c0, 0x481400}},
+		}}, funcs)
+
+		lines, err := gl.LookupLine("main.go", 28)
+		require.NoError(t, err)
+		require.Equal(t, []LineMatch{
+			{File: "cgo/main.go", Line: 28, Function: "main.goCaller", Start: 0x4813c0, End: 0x4813ce},
+			{File: "cgo/main.go", Line: 28, Function: "main.goCaller", Start: 0x4813f2, End: 0x4813f9},
+		}, lines)
+
+		// Every address of the ranges maps back to the line.
+		for _, l := range lines {
+			for pc := l.Start; pc < l.End; pc++ {
+				got, err := gl.PCToLines(pc)
+				require.NoError(t, err)
+				require.Equal(t, int64(28), got[0].Line, "pc %#x", pc)
+			}
+		}
+
+		lines, err = gl.LookupLine("other/main.go", 28)
+		require.NoError(t, err)
+		require.Empty(t, lines)
+	}
+}
+
+func TestGoLiner_Stripped(t *testing.T) {
+	// There is no runtime.text symbol, the C code comes first in .text and the text start
+	// of the pclntab header is left to 0 by the external linking: only runtime.firstmoduledata has it.
+	filename := "testdata/cgo-go-stripped"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+	eager, err := Go(log.NewNopLogger(), filename, f)
+	require.NoError(t, err)
+	defer eager.Close()
+
+	f, err = elf.Open(filename)
+	require.NoError(t, err)
+	lazy, err := GoLazy(log.NewNopLogger(), filename, f)
+	require.NoError(t, err)
+	defer lazy.Close()
+
+	for _, gl := range []*GoLiner{eager, lazy} {
+		for name, entry := range map[string]uint64{"main.main": 0x481400, "main.goCaller": 0x4813c0} {
+			funcs, err := gl.LookupFunctions(MatchExact(name))
+			require.NoError(t, err)
+			require.Len(t, funcs, 1)
+			require.Equal(t, entry, funcs[0].Entry, name)
+
+			lines, err := gl.PCToLines(entry)
+			require.NoError(t, err)
+			require.Equal(t, name, lines[len(lines)-1].Function.Name)
+		}
+	}
+}
+
+func TestGoLiner_ProbeSites(t *testing.T) {
+	filename := "testdata/cgo-go"
+	for name, newLiner := range map[string]func(log.Logger, string, *elf.File) (*GoLiner, error){"eager": Go, "lazy": GoLazy} {
+		t.Run(name, func(t *testing.T) {
+			f, err := elf.Open(filename)
+			require.NoError(t, err)
+
+			gl, err := newLiner(log.NewNopLogger(), filename, f)
+			require.NoError(t, err)
+			defer gl.Close()
+
+			sites, err := gl.ProbeSites(gl.LookupFunc("main.goCaller"))
+			require.NoError(t, err)
+			require.Equal(t, ProbeSites{Entry: 0x813c0, Returns: []uint64{0x813f1}}, sites)
+
+			// Assembly function with many returns, as listed by go tool objdump.
+			sites, err = gl.ProbeSites(gl.LookupFunc("runtime.memmove"))
+			require.NoError(t, err)
+			require.Equal(t, uint64(0x7d9a0), sites.Entry)
+			require.Len(t, sites.Returns, 17)
+			require.Equal(t, []uint64{0x7da62, 0x7da7b, 0x7dacc, 0x7dacd, 0x7dad2}, sites.Returns[:5])
+
+			require.Nil(t, gl.LookupFunc("main.missing"))
+		})
+	}
+}
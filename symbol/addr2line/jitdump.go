@@ -0,0 +1,204 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"gitlab.com/Raven-IO/GoSymTable/profile"
+	pb "gitlab.com/Raven-IO/GoSymTable/protogen/go/metastore"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/jitdump"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/symbolsearcher"
+)
+
+// JITDumpLiner is a liner which symbolizes JIT-compiled code from a jitdump file,
+// jit-<pid>.dump, to the function names of the code loads and, when the JIT compiler
+// wrote debug info records, to source lines.
+//
+// Moved code is found at its new address. As for perf maps, the newest code wins
+// for the addresses reused by several loads or moves.
+type JITDumpLiner struct {
+	logger log.Logger
+
+	demangler *demangle.Demangler
+	filename  string
+	header    jitdump.Header
+
+	codes    []jitCode
+	searcher symbolsearcher.Searcher
+	// pieces maps the start address of the symbols of the searcher to their code.
+	pieces map[uint64]int
+}
+
+// jitCode is the compiled code of a function at [start, end).
+type jitCode struct {
+	name       string
+	start, end uint64
+	// lines are the debug entries of the code sorted by address, if any.
+	lines []jitdump.DebugEntry
+}
+
+// JITDump creates a new JITDumpLiner. A record at the end of the file cut short,
+// because the process is still writing it, is ignored.
+func JITDump(logger log.Logger, filename string, demangler *demangle.Demangler) (*JITDumpLiner, error) {
+	logger = log.With(logger, "liner", "jitdump")
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open jitdump: %w", err)
+	}
+	defer f.Close()
+
+	r, err := jitdump.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := r.ReadAll()
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		level.Debug(logger).Log("msg", "ignoring truncated jitdump record", "file", filename)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read jitdump: %w", err)
+	}
+
+	lnr := &JITDumpLiner{
+		logger:    logger,
+		demangler: demangler,
+		filename:  filename,
+		header:    r.Header,
+	}
+	lnr.load(recs)
+	return lnr, nil
+}
+
+// load builds the index of the code of the records.
+func (lnr *JITDumpLiner) load(recs []jitdump.Record) {
+	// The debug info records precede the load of their code.
+	debugInfo := make(map[uint64][]jitdump.DebugEntry)
+	byIndex := make(map[uint64]int)
+	for _, rec := range recs {
+		switch rec := rec.(type) {
+		case *jitdump.DebugInfo:
+			debugInfo[rec.CodeAddr] = rec.Entries
+		case *jitdump.CodeLoad:
+			lines := debugInfo[rec.CodeAddr]
+			delete(debugInfo, rec.CodeAddr)
+			sort.SliceStable(lines, func(i, j int) bool {
+				return lines[i].Addr < lines[j].Addr
+			})
+			byIndex[rec.CodeIndex] = len(lnr.codes)
+			lnr.codes = append(lnr.codes, jitCode{
+				name:  rec.Name,
+				start: rec.CodeAddr,
+				end:   rec.CodeAddr + max(rec.CodeSize, 1),
+				lines: lines,
+			})
+		case *jitdump.CodeMove:
+			i, ok := byIndex[rec.CodeIndex]
+			if !ok {
+				level.Debug(lnr.logger).Log("msg", "ignoring move of unknown code", "index", rec.CodeIndex)
+				continue
+			}
+			code := lnr.codes[i]
+			lines := make([]jitdump.DebugEntry, len(code.lines))
+			for j, l := range code.lines {
+				l.Addr = l.Addr - rec.OldCodeAddr + rec.NewCodeAddr
+				lines[j] = l
+			}
+			byIndex[rec.CodeIndex] = len(lnr.codes)
+			lnr.codes = append(lnr.codes, jitCode{
+				name:  code.name,
+				start: rec.NewCodeAddr,
+				end:   rec.NewCodeAddr + max(rec.CodeSize, 1),
+				lines: lines,
+			})
+		}
+	}
+
+	entries := make([]perfMapEntry, len(lnr.codes))
+	for i, c := range lnr.codes {
+		entries[i] = perfMapEntry{start: c.start, end: c.end, name: c.name, seq: i}
+	}
+	pieces := newestPerfMapEntries(entries)
+
+	lnr.pieces = make(map[uint64]int, len(pieces))
+	syms := make([]elf.Symbol, len(pieces))
+	for i, p := range pieces {
+		lnr.pieces[p.start] = p.seq
+		syms[i] = elf.Symbol{
+			Name:    p.name,
+			Info:    elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
+			Section: elf.SHN_ABS,
+			Value:   p.start,
+			Size:    p.end - p.start,
+		}
+	}
+	lnr.searcher = symbolsearcher.New(syms)
+	lnr.searcher.Strict = true
+}
+
+func (lnr *JITDumpLiner) Close() error {
+	return nil
+}
+
+func (lnr *JITDumpLiner) File() string {
+	return lnr.filename
+}
+
+// Header returns the header of the jitdump file.
+func (lnr *JITDumpLiner) Header() jitdump.Header {
+	return lnr.header
+}
+
+func (lnr *JITDumpLiner) PCRange() ([2]uint64, error) {
+	return lnr.searcher.PCRange()
+}
+
+// PCToLines looks up the line number information for a program counter (memory address).
+// The line is 0 and the file name "?" for code without debug info.
+func (lnr *JITDumpLiner) PCToLines(addr uint64) ([]profile.LocationLine, error) {
+	sym, err := lnr.searcher.SearchSymbol(addr)
+	if err != nil {
+		return nil, err
+	}
+	code := lnr.codes[lnr.pieces[sym.Value]]
+
+	var (
+		line int64
+		file = "?"
+	)
+	i := sort.Search(len(code.lines), func(i int) bool {
+		return code.lines[i].Addr > addr
+	})
+	if i > 0 {
+		line = int64(code.lines[i-1].Line)
+		file = code.lines[i-1].File
+	}
+
+	return []profile.LocationLine{{
+		Line: line,
+		Function: lnr.demangler.Demangle(&pb.Function{
+			SystemName: code.name,
+			Filename:   file,
+		}),
+	}}, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/Raven-IO/GoSymTable/profile"
+	metastorev1alpha1 "gitlab.com/Raven-IO/GoSymTable/protogen/go/metastore"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/jitdump"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/symbolsearcher"
+)
+
+// jitRecord encodes a little-endian jitdump record, the fields being uint32, uint64 or
+// null-terminated strings.
+func jitRecord(t jitdump.RecordType, fields ...any) []byte {
+	var body []byte
+	for _, f := range fields {
+		switch f := f.(type) {
+		case uint32:
+			body = binary.LittleEndian.AppendUint32(body, f)
+		case uint64:
+			body = binary.LittleEndian.AppendUint64(body, f)
+		case string:
+			body = append(append(body, f...), 0)
+		}
+	}
+	rec := binary.LittleEndian.AppendUint32(nil, uint32(t))
+	rec = binary.LittleEndian.AppendUint32(rec, uint32(16+len(body)))
+	rec = binary.LittleEndian.AppendUint64(rec, 0)
+	return append(rec, body...)
+}
+
+func TestJITDumpLiner(t *testing.T) {
+	// magic, version, header size, EM_X86_64, padding, pid, timestamp and flags.
+	data := []byte("DTiJ\x01\x00\x00\x00\x28\x00\x00\x00\x3e\x00\x00\x00\x00\x00\x00\x00\x2a\x00\x00\x00")
+	data = append(data, make([]byte, 16)...)
+	load := func(addr, size, index uint64, name string) []byte {
+		return jitRecord(jitdump.RecordCodeLoad, uint32(42), uint32(42), addr, addr, size, index, name)
+	}
+	data = append(data, jitRecord(jitdump.RecordDebugInfo, uint64(0x1000), uint64(2),
+		uint64(0x1000), uint32(10), uint32(0), "app.js",
+		uint64(0x1010), uint32(12), uint32(0), "\xff")...)
+	data = append(data, load(0x1000, 0x40, 1, "LazyCompile:*main app.js:10")...)
+	data = append(data, load(0x2000, 0x20, 2, "stub")...)
+	data = append(data, load(0x3000, 0x20, 3, "other")...)
+	data = append(data, jitRecord(jitdump.RecordCodeMove, uint32(42), uint32(42), uint64(0x1020), uint64(0x1000), uint64(0x1020), uint64(0x40), uint64(1))...)
+	data = append(data, load(0x2010, 0x8, 4, "reused")...)
+	// A record being written.
+	data = append(data, load(0x5000, 0x20, 5, "partial")[:30]...)
+
+	filename := filepath.Join(t.TempDir(), "jit-42.dump")
+	require.NoError(t, os.WriteFile(filename, data, 0o644))
+
+	lnr, err := JITDump(log.NewNopLogger(), filename, demangle.NewDemangler("simple", false))
+	require.NoError(t, err)
+	defer lnr.Close()
+	require.Equal(t, uint32(42), lnr.Header().Pid)
+
+	line := func(name, file string, line int64) []profile.LocationLine {
+		return []profile.LocationLine{{
+			Line: line,
+			Function: &metastorev1alpha1.Function{
+				Name:       name,
+				SystemName: name,
+				Filename:   file,
+			},
+		}}
+	}
+	tests := []struct {
+		addr uint64
+		want []profile.LocationLine
+	}{
+		// Before the move.
+		{addr: 0x1008, want: line("LazyCompile:*main app.js:10", "app.js", 10)},
+		// The moved code, with its lines shifted.
+		{addr: 0x1020, want: line("LazyCompile:*main app.js:10", "app.js", 10)},
+		{addr: 0x1035, want: line("LazyCompile:*main app.js:10", "app.js", 12)},
+		{addr: 0x105f, want: line("LazyCompile:*main app.js:10", "app.js", 12)},
+		{addr: 0x2000, want: line("stub", "?", 0)},
+		{addr: 0x2010, want: line("reused", "?", 0)},
+		{addr: 0x2018, want: line("stub", "?", 0)},
+		{addr: 0x3010, want: line("other", "?", 0)},
+	}
+	for _, tt := range tests {
+		got, err := lnr.PCToLines(tt.addr)
+		require.NoError(t, err, "%#x", tt.addr)
+		require.Equal(t, tt.want, got, "%#x", tt.addr)
+	}
+
+	_, err = lnr.PCToLines(0x5000)
+	var nfErr *symbolsearcher.NotFoundError
+	require.ErrorAs(t, err, &nfErr)
+
+	pcRange, err := lnr.PCRange()
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{0x1000, 0x3020}, pcRange)
+}
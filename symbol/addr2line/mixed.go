@@ -0,0 +1,231 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/elfutils"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/pathmap"
+
+	"gitlab.com/Raven-IO/GoSymTable/profile"
+)
+
+// liner is implemented by the liners a MixedLiner falls back to.
+type liner interface {
+	PCRange() ([2]uint64, error)
+	PCToLines(addr uint64) ([]profile.LocationLine, error)
+}
+
+// MixedLiner is a liner for Go binaries which contain C/C++ code, e.g. built with cgo.
+// Addresses of Go functions are symbolized with the pclntab, the others with DWARF
+// or, when there is no debug information, the symbol table.
+type MixedLiner struct {
+	logger log.Logger
+
+	// PathMapper rewrites the file names of the returned lines, of both Go and non-Go code.
+	PathMapper *pathmap.Mapper
+
+	goLiner *GoLiner
+	// goRanges are the sorted, disjoint address intervals [start, end) of the Go functions.
+	goRanges [][2]uint64
+	fallback liner
+
+	filename string
+	f        *elf.File
+}
+
+// Mixed creates a new MixedLiner.
+func Mixed(logger log.Logger, filename string, f *elf.File, demangler *demangle.Demangler) (*MixedLiner, error) {
+	logger = log.With(logger, "liner", "mixed")
+
+	gl, err := Go(logger, filename, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var fallback liner
+	if elfutils.HasDWARF(f) {
+		dl, err := DWARF(logger, filename, f, demangler)
+		if err == nil {
+			fallback = dl
+		} else {
+			level.Debug(logger).Log("msg", "failed to create DWARF liner", "err", err)
+		}
+	}
+	if fallback == nil {
+		sl, err := Symbols(logger, filename, f, demangler)
+		if err == nil {
+			fallback = sl
+		} else {
+			level.Debug(logger).Log("msg", "failed to create symtab liner", "err", err)
+		}
+	}
+
+	return &MixedLiner{
+		logger:   logger,
+		goLiner:  gl,
+		goRanges: goFuncRanges(gl),
+		fallback: fallback,
+		filename: filename,
+		f:        f,
+	}, nil
+}
+
+// goFuncRanges merges the address intervals of the functions in the pclntab.
+func goFuncRanges(gl *GoLiner) [][2]uint64 {
+	var ranges [][2]uint64
+	for _, fn := range gl.Symtab.Funcs {
+		if fn.End <= fn.Entry {
+			continue
+		}
+		ranges = append(ranges, [2]uint64{fn.Entry, fn.End})
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+
+	merged := ranges[:0]
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r[0] <= merged[n-1][1] {
+			if r[1] > merged[n-1][1] {
+				merged[n-1][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func (ml *MixedLiner) Close() error {
+	return ml.f.Close()
+}
+
+func (ml *MixedLiner) File() string {
+	return ml.filename
+}
+
+func (ml *MixedLiner) PCRange() ([2]uint64, error) {
+	res, err := ml.goLiner.PCRange()
+	if err != nil {
+		return [2]uint64{}, err
+	}
+	if ml.fallback == nil {
+		return res, nil
+	}
+
+	r, err := ml.fallback.PCRange()
+	if err != nil {
+		// The Go functions are still symbolized.
+		level.Debug(ml.logger).Log("msg", "failed to get the address range of the fallback liner", "err", err)
+		return res, nil
+	}
+	if r[0] < res[0] {
+		res[0] = r[0]
+	}
+	if r[1] > res[1] {
+		res[1] = r[1]
+	}
+	return res, nil
+}
+
+// GoPaths returns the rule normalizing the file names of the Go code, see GoLiner.GoPaths.
+func (ml *MixedLiner) GoPaths() pathmap.Rule {
+	return ml.goLiner.GoPaths()
+}
+
+// IsGo reports whether addr belongs to a Go function of the pclntab.
+func (ml *MixedLiner) IsGo(addr uint64) bool {
+	i := sort.Search(len(ml.goRanges), func(i int) bool {
+		return ml.goRanges[i][1] > addr
+	})
+	return i < len(ml.goRanges) && ml.goRanges[i][0] <= addr
+}
+
+// PCToLines looks up the line number information for a program counter (memory address),
+// with the pclntab for Go code and the fallback liner for anything else.
+func (ml *MixedLiner) PCToLines(addr uint64) ([]profile.LocationLine, error) {
+	if ml.IsGo(addr) {
+		// The file names are only rewritten by ml.PathMapper.
+		lines, err := ml.goLiner.pcToLines(addr)
+		if err != nil {
+			return nil, err
+		}
+		return remapLines(ml.PathMapper, lines), nil
+	}
+	if ml.fallback == nil {
+		return nil, errors.New("no liner for non-Go code")
+	}
+	lines, err := ml.fallback.PCToLines(addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to symbolize non-Go code: %w", err)
+	}
+	return remapLines(ml.PathMapper, lines), nil
+}
+
+// LookupFunctions returns the Go functions of the pclntab and the other functions of the
+// fallback liner whose name is matched by m, see GoLiner.LookupFunctions.
+func (ml *MixedLiner) LookupFunctions(m NameMatcher) ([]FuncMatch, error) {
+	res, err := ml.goLiner.LookupFunctions(m)
+	if err != nil {
+		return nil, err
+	}
+
+	var other []FuncMatch
+	switch fallback := ml.fallback.(type) {
+	case *DwarfLiner:
+		other, err = fallback.LookupFunctions(m)
+	case *SymtabLiner:
+		other, err = fallback.LookupFunctions(m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up non-Go functions: %w", err)
+	}
+	for _, fn := range other {
+		// The DWARF data and the symbol table also describe the Go functions.
+		if !ml.IsGo(fn.Entry) {
+			res = append(res, fn)
+		}
+	}
+	sortFuncMatches(res)
+	return res, nil
+}
+
+// LookupLine returns the address intervals of the code generated for line of file, from
+// the pclntab for Go code and the DWARF line tables for anything else, see GoLiner.LookupLine.
+func (ml *MixedLiner) LookupLine(file string, line int) ([]LineMatch, error) {
+	res, err := ml.goLiner.lookupLine(file, line, ml.PathMapper)
+	if err != nil {
+		return nil, err
+	}
+
+	if dl, ok := ml.fallback.(*DwarfLiner); ok {
+		other, err := dl.lookupLine(file, line, ml.PathMapper)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up non-Go lines: %w", err)
+		}
+		for _, r := range other {
+			if !ml.IsGo(r.Start) {
+				res = append(res, r)
+			}
+		}
+	}
+	sortLineMatches(res)
+	return res, nil
+}
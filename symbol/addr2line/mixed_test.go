@@ -0,0 +1,134 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"debug/elf"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/pathmap"
+)
+
+func TestMixedLiner_PCToLines(t *testing.T) {
+	filename := "testdata/cgo-go"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+
+	liner, err := Mixed(log.NewNopLogger(), filename, f, demangle.NewDemangler("simple", false))
+	require.NoError(t, err)
+	defer liner.Close()
+
+	tests := []struct {
+		name     string
+		addr     uint64
+		isGo     bool
+		function string
+		file     string
+		line     int64
+	}{
+		{name: "go function", addr: 0x4813c8, isGo: true, function: "main.goCaller", file: "cgo/main.go", line: 28},
+		{name: "cgo stub", addr: 0x481320, isGo: true, function: "main._Cfunc_c_entry", file: "_cgo_gotypes.go", line: 47},
+		{name: "static c function", addr: 0x4814a7, function: "c_leaf", file: "/_/cgo/main.go", line: 17},
+		{name: "c function", addr: 0x4814c0, function: "c_entry", file: "/_/cgo/main.go", line: 21},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.isGo, liner.IsGo(tt.addr))
+
+			lines, err := liner.PCToLines(tt.addr)
+			require.NoError(t, err)
+			require.Len(t, lines, 1)
+			require.Equal(t, tt.function, lines[0].Function.Name)
+			require.Equal(t, tt.file, lines[0].Function.Filename)
+			require.Equal(t, tt.line, lines[0].Line)
+		})
+	}
+
+	// The C code is outside of the Go text, the range covers both.
+	r, err := liner.PCRange()
+	require.NoError(t, err)
+	require.LessOrEqual(t, r[0], uint64(0x402400))
+	require.Greater(t, r[1], uint64(0x4814c0))
+}
+
+func TestMixedLiner_PathMapper(t *testing.T) {
+	filename := "testdata/cgo-go"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+
+	liner, err := Mixed(log.NewNopLogger(), filename, f, demangle.NewDemangler("simple", false))
+	require.NoError(t, err)
+	defer liner.Close()
+
+	liner.PathMapper = pathmap.New(pathmap.Prefix("/_/cgo", "cgo"), liner.GoPaths())
+
+	tests := []struct {
+		name string
+		addr uint64
+		file string
+	}{
+		{name: "go function", addr: 0x4813c8, file: "cgo@(devel)/main.go"},
+		{name: "c function", addr: 0x4814c0, file: "cgo/main.go"},
+		{name: "standard library", addr: 0x402400, file: "std@go1.27.1/internal/abi/bounds.go"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := liner.PCToLines(tt.addr)
+			require.NoError(t, err)
+			require.Equal(t, tt.file, lines[0].Function.Filename)
+		})
+	}
+
+	// The Go file names are rewritten once, even by a rule matching its own output.
+	liner.PathMapper = pathmap.New(pathmap.Prefix("cgo", "cgo/cgo"))
+	liner.goLiner.PathMapper = liner.PathMapper
+	lines, err := liner.PCToLines(0x4813c8)
+	require.NoError(t, err)
+	require.Equal(t, "cgo/cgo/main.go", lines[0].Function.Filename)
+}
+
+func TestMixedLiner_Lookup(t *testing.T) {
+	filename := "testdata/cgo-go"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+
+	liner, err := Mixed(log.NewNopLogger(), filename, f, demangle.NewDemangler("simple", false))
+	require.NoError(t, err)
+	defer liner.Close()
+
+	m, err := MatchRegexp(`^(c_|main\.goCaller$)`)
+	require.NoError(t, err)
+	funcs, err := liner.LookupFunctions(m)
+	require.NoError(t, err)
+	var names []string
+	for _, fn := range funcs {
+		names = append(names, fn.Name)
+	}
+	// The DWARF entry of main.goCaller isn't returned twice.
+	require.Equal(t, []string{"main.goCaller", "c_leaf", "c_entry"}, names)
+
+	liner.PathMapper = pathmap.New(pathmap.Prefix("/_/cgo", "cgo"))
+	lines, err := liner.LookupLine("cgo/main.go", 17)
+	require.NoError(t, err)
+	require.NotEmpty(t, lines)
+	for _, l := range lines {
+		require.Equal(t, "cgo/main.go", l.File)
+		require.Equal(t, "c_leaf", l.Function)
+		require.False(t, liner.IsGo(l.Start))
+	}
+}
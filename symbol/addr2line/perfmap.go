@@ -0,0 +1,281 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"bytes"
+	"container/heap"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"gitlab.com/Raven-IO/GoSymTable/profile"
+	pb "gitlab.com/Raven-IO/GoSymTable/protogen/go/metastore"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/symbolsearcher"
+)
+
+// PerfMapFile returns the path of the perf map file of the process pid.
+func PerfMapFile(pid int) string {
+	return fmt.Sprintf("/tmp/perf-%d.map", pid)
+}
+
+// PerfMapLiner is a liner which symbolizes JIT-compiled code from the perf map file
+// written by the runtime of a process, e.g. the JVM, Node or .NET, one line per
+// compiled function: "START SIZE name", with the start and the size in hex.
+//
+// The file is appended to while the process runs: Refresh parses the new lines, and
+// PCToLines refreshes on its own for addresses not found yet. The runtime can reuse the
+// addresses of freed code, so the newest entry wins for the addresses it covers.
+type PerfMapLiner struct {
+	logger log.Logger
+
+	demangler *demangle.Demangler
+	filename  string
+
+	mu sync.Mutex
+	// offset is the size of the file already parsed, up to the end of the last full line.
+	offset int64
+	// seq is the number of the entries parsed so far, newer entries have higher numbers.
+	seq      int
+	pieces   []perfMapEntry
+	searcher symbolsearcher.Searcher
+}
+
+// perfMapEntry is the address range [start, end) of the entry number seq of the file.
+type perfMapEntry struct {
+	start, end uint64
+	name       string
+	seq        int
+}
+
+// PerfMap creates a new PerfMapLiner, parsing the current content of the file.
+func PerfMap(logger log.Logger, filename string, demangler *demangle.Demangler) (*PerfMapLiner, error) {
+	lnr := &PerfMapLiner{
+		logger:    log.With(logger, "liner", "perfmap"),
+		demangler: demangler,
+		filename:  filename,
+	}
+	if err := lnr.Refresh(); err != nil {
+		return nil, err
+	}
+	return lnr, nil
+}
+
+func (lnr *PerfMapLiner) Close() error {
+	return nil
+}
+
+func (lnr *PerfMapLiner) File() string {
+	return lnr.filename
+}
+
+func (lnr *PerfMapLiner) PCRange() ([2]uint64, error) {
+	lnr.mu.Lock()
+	defer lnr.mu.Unlock()
+	return lnr.searcher.PCRange()
+}
+
+// Refresh parses the lines appended to the file since the last call. A trailing line
+// without a newline is left for the next call, since the process may be writing it.
+// A file shorter than what was parsed has been rewritten, e.g. for a new process with
+// the same pid, and is parsed again from the start.
+func (lnr *PerfMapLiner) Refresh() error {
+	lnr.mu.Lock()
+	defer lnr.mu.Unlock()
+
+	f, err := os.Open(lnr.filename)
+	if err != nil {
+		return fmt.Errorf("failed to open perf map: %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat perf map: %w", err)
+	}
+	if fi.Size() < lnr.offset {
+		level.Debug(lnr.logger).Log("msg", "perf map truncated, parsing it again", "file", lnr.filename)
+		lnr.offset = 0
+		lnr.pieces = nil
+		lnr.searcher = symbolsearcher.Searcher{}
+	}
+	if fi.Size() == lnr.offset {
+		return nil
+	}
+
+	data, err := io.ReadAll(io.NewSectionReader(f, lnr.offset, fi.Size()-lnr.offset))
+	if err != nil {
+		return fmt.Errorf("failed to read perf map: %w", err)
+	}
+	data = data[:bytes.LastIndexByte(data, '\n')+1]
+	if len(data) == 0 {
+		return nil
+	}
+
+	entries := lnr.pieces
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		e, err := parsePerfMapLine(line)
+		if err != nil {
+			level.Debug(lnr.logger).Log("msg", "skipping perf map line", "line", line, "err", err)
+			continue
+		}
+		e.seq = lnr.seq
+		lnr.seq++
+		entries = append(entries, e)
+	}
+	lnr.offset += int64(len(data))
+
+	lnr.pieces = newestPerfMapEntries(entries)
+	syms := make([]elf.Symbol, len(lnr.pieces))
+	for i, p := range lnr.pieces {
+		syms[i] = elf.Symbol{
+			Name:    p.name,
+			Info:    elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
+			Section: elf.SHN_ABS,
+			Value:   p.start,
+			Size:    p.end - p.start,
+		}
+	}
+	lnr.searcher = symbolsearcher.New(syms)
+	lnr.searcher.Strict = true
+	return nil
+}
+
+// parsePerfMapLine parses a "START SIZE name" line, the name may contain spaces.
+func parsePerfMapLine(line string) (perfMapEntry, error) {
+	start, rest, ok := strings.Cut(strings.TrimSpace(line), " ")
+	if !ok {
+		return perfMapEntry{}, errors.New("missing size")
+	}
+	size, name, ok := strings.Cut(strings.TrimLeft(rest, " "), " ")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return perfMapEntry{}, errors.New("missing name")
+	}
+
+	s, err := strconv.ParseUint(strings.TrimPrefix(start, "0x"), 16, 64)
+	if err != nil {
+		return perfMapEntry{}, fmt.Errorf("invalid start: %w", err)
+	}
+	n, err := strconv.ParseUint(strings.TrimPrefix(size, "0x"), 16, 64)
+	if err != nil {
+		return perfMapEntry{}, fmt.Errorf("invalid size: %w", err)
+	}
+	// An entry without a size covers its start address only.
+	return perfMapEntry{start: s, end: s + max(n, 1), name: name}, nil
+}
+
+// newestPerfMapEntries splits the overlapping entries into disjoint pieces, sorted by
+// address, each covered by the newest of the entries containing it.
+func newestPerfMapEntries(entries []perfMapEntry) []perfMapEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].start < entries[j].start
+	})
+	bounds := make([]uint64, 0, 2*len(entries))
+	for _, e := range entries {
+		bounds = append(bounds, e.start, e.end)
+	}
+	sort.Slice(bounds, func(i, j int) bool {
+		return bounds[i] < bounds[j]
+	})
+
+	var (
+		pieces []perfMapEntry
+		active perfMapHeap
+		next   int
+	)
+	for i := 0; i+1 < len(bounds); i++ {
+		lo, hi := bounds[i], bounds[i+1]
+		if lo == hi {
+			continue
+		}
+		for next < len(entries) && entries[next].start <= lo {
+			heap.Push(&active, entries[next])
+			next++
+		}
+		// The entries ending before the segment are dropped lazily.
+		for len(active) > 0 && active[0].end <= lo {
+			heap.Pop(&active)
+		}
+		if len(active) == 0 {
+			continue
+		}
+		top := active[0]
+		if n := len(pieces); n > 0 && pieces[n-1].seq == top.seq && pieces[n-1].end == lo {
+			pieces[n-1].end = hi
+			continue
+		}
+		pieces = append(pieces, perfMapEntry{start: lo, end: hi, name: top.name, seq: top.seq})
+	}
+	return pieces
+}
+
+// perfMapHeap is a max-heap of entries by sequence number.
+type perfMapHeap []perfMapEntry
+
+func (h perfMapHeap) Len() int           { return len(h) }
+func (h perfMapHeap) Less(i, j int) bool { return h[i].seq > h[j].seq }
+func (h perfMapHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *perfMapHeap) Push(x any) {
+	*h = append(*h, x.(perfMapEntry))
+}
+
+func (h *perfMapHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// PCToLines looks up the line number information for a program counter (memory address).
+// Addresses not found are looked up again after a Refresh, since the code may have been
+// compiled after the last one. Addresses whose code was replaced since need a Refresh first.
+func (lnr *PerfMapLiner) PCToLines(addr uint64) ([]profile.LocationLine, error) {
+	sym, err := lnr.search(addr)
+	var nfErr *symbolsearcher.NotFoundError
+	if errors.As(err, &nfErr) {
+		if err := lnr.Refresh(); err != nil {
+			return nil, err
+		}
+		sym, err = lnr.search(addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []profile.LocationLine{{
+		Line: 0,
+		Function: lnr.demangler.Demangle(&pb.Function{
+			SystemName: sym.Name,
+			Filename:   "?",
+		}),
+	}}, nil
+}
+
+func (lnr *PerfMapLiner) search(addr uint64) (elf.Symbol, error) {
+	lnr.mu.Lock()
+	defer lnr.mu.Unlock()
+	return lnr.searcher.SearchSymbol(addr)
+}
@@ -0,0 +1,108 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/symbolsearcher"
+)
+
+func TestPerfMapLiner(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "perf-42.map")
+	appendFile := func(s string) {
+		f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		require.NoError(t, err)
+		_, err = f.WriteString(s)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	appendFile("7f0000001000 40 LazyCompile:~main /app/index.js:1\n" +
+		"7f0000001100 80 Interpreter\n" +
+		"bad line\n" +
+		"0x7f0000001200 0x10 java.lang.String::hashCode\n")
+
+	lnr, err := PerfMap(log.NewNopLogger(), filename, demangle.NewDemangler("simple", false))
+	require.NoError(t, err)
+	defer lnr.Close()
+
+	name := func(addr uint64) string {
+		t.Helper()
+		lines, err := lnr.PCToLines(addr)
+		require.NoError(t, err)
+		require.Len(t, lines, 1)
+		return lines[0].Function.Name
+	}
+	require.Equal(t, "LazyCompile:~main /app/index.js:1", name(0x7f0000001000))
+	require.Equal(t, "LazyCompile:~main /app/index.js:1", name(0x7f000000103f))
+	require.Equal(t, "Interpreter", name(0x7f0000001150))
+	require.Equal(t, "java.lang.String::hashCode", name(0x7f0000001208))
+
+	_, err = lnr.PCToLines(0x7f0000001040)
+	var nfErr *symbolsearcher.NotFoundError
+	require.ErrorAs(t, err, &nfErr)
+
+	pcRange, err := lnr.PCRange()
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{0x7f0000001000, 0x7f0000001210}, pcRange)
+
+	// New code is picked up on a miss, a partially written line isn't.
+	appendFile("7f0000002000 20 foo\n7f0000003000 20 ba")
+	require.Equal(t, "foo", name(0x7f0000002010))
+	_, err = lnr.PCToLines(0x7f0000003000)
+	require.ErrorAs(t, err, &nfErr)
+	appendFile("r\n")
+	require.Equal(t, "bar", name(0x7f0000003000))
+
+	// Reused addresses: the newest entry wins where it overlaps the older ones.
+	appendFile("7f0000001120 20 recompiled\n")
+	require.NoError(t, lnr.Refresh())
+	require.Equal(t, "Interpreter", name(0x7f000000111f))
+	require.Equal(t, "recompiled", name(0x7f0000001120))
+	require.Equal(t, "recompiled", name(0x7f000000113f))
+	require.Equal(t, "Interpreter", name(0x7f0000001140))
+	appendFile("7f0000001100 80 Interpreter2\n")
+	require.NoError(t, lnr.Refresh())
+	require.Equal(t, "Interpreter2", name(0x7f0000001130))
+
+	// A rewritten file is parsed again from the start.
+	require.NoError(t, os.WriteFile(filename, []byte("1000 10 new\n"), 0o644))
+	require.NoError(t, lnr.Refresh())
+	require.Equal(t, "new", name(0x1000))
+	_, err = lnr.PCToLines(0x7f0000002010)
+	require.ErrorAs(t, err, &nfErr)
+}
+
+func TestNewestPerfMapEntries(t *testing.T) {
+	got := newestPerfMapEntries([]perfMapEntry{
+		{start: 0, end: 100, name: "a", seq: 0},
+		{start: 20, end: 40, name: "b", seq: 1},
+		{start: 30, end: 60, name: "c", seq: 2},
+		{start: 10, end: 50, name: "d", seq: 0},
+		{start: 200, end: 210, name: "e", seq: 3},
+	})
+	require.Equal(t, []perfMapEntry{
+		{start: 0, end: 20, name: "a", seq: 0},
+		{start: 20, end: 30, name: "b", seq: 1},
+		{start: 30, end: 60, name: "c", seq: 2},
+		{start: 60, end: 100, name: "a", seq: 0},
+		{start: 200, end: 210, name: "e", seq: 3},
+	}, got)
+}
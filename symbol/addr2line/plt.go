@@ -0,0 +1,252 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+)
+
+// pltLayout is the size of the header and of the entries of the .plt section,
+// see symbol-elf.c/dso__synthesize_plt_symbols.
+type pltLayout struct {
+	headerSize uint64
+	entrySize  uint64
+}
+
+// pltLayouts are the layouts of GNU ld first, then the ones of lld when they differ.
+var pltLayouts = map[elf.Machine][]pltLayout{
+	elf.EM_386:     {{headerSize: 16, entrySize: 16}},
+	elf.EM_X86_64:  {{headerSize: 16, entrySize: 16}},
+	elf.EM_AARCH64: {{headerSize: 32, entrySize: 16}},
+	elf.EM_RISCV:   {{headerSize: 32, entrySize: 16}},
+	elf.EM_ARM:     {{headerSize: 20, entrySize: 12}, {headerSize: 32, entrySize: 16}},
+}
+
+// dynReloc is a dynamic relocation against a symbol of .dynsym.
+type dynReloc struct {
+	offset uint64
+	sym    uint64
+}
+
+// pltSymbols synthesizes the "name@plt" symbols of the PLT stubs, which have no symbol
+// table entry. dynSyms are the symbols of .dynsym, as returned by elf.File.DynamicSymbols.
+//
+// On x86 the target GOT slot of every stub of .plt, .plt.sec (IBT) and .plt.got is decoded
+// and matched with the dynamic relocations. Elsewhere the stubs of .plt are assumed to be
+// in the order of the relocations of .rela.plt or .rel.plt, after the PLT header.
+// The header and stub sizes depend on the linker.
+func pltSymbols(f *elf.File, dynSyms []elf.Symbol) ([]elf.Symbol, error) {
+	dynsym := -1
+	for i, sec := range f.Sections {
+		if sec.Type == elf.SHT_DYNSYM {
+			dynsym = i
+			break
+		}
+	}
+	if dynsym < 0 {
+		return nil, nil
+	}
+
+	dynSym := func(i uint64) (elf.Symbol, bool) {
+		if i == 0 || i > uint64(len(dynSyms)) || dynSyms[i-1].Name == "" {
+			return elf.Symbol{}, false
+		}
+		return dynSyms[i-1], true
+	}
+
+	var pltRelocs []dynReloc
+	slots := make(map[uint64]elf.Symbol)
+	for _, sec := range f.Sections {
+		if (sec.Type != elf.SHT_RELA && sec.Type != elf.SHT_REL) || int(sec.Link) != dynsym {
+			continue
+		}
+		relocs, err := readDynRelocs(f, sec)
+		if err != nil {
+			return nil, err
+		}
+		if sec.Name == ".rela.plt" || sec.Name == ".rel.plt" {
+			pltRelocs = relocs
+		}
+		for _, r := range relocs {
+			if sym, ok := dynSym(r.sym); ok {
+				slots[r.offset] = sym
+			}
+		}
+	}
+
+	if f.Machine == elf.EM_386 || f.Machine == elf.EM_X86_64 {
+		syms, err := x86PLTSymbols(f, slots)
+		if err != nil || len(syms) > 0 {
+			return syms, err
+		}
+		// Unknown stubs, fall back to the relocation order.
+	}
+
+	plt := f.Section(".plt")
+	if plt == nil || len(pltRelocs) == 0 {
+		return nil, nil
+	}
+	layouts, ok := pltLayouts[f.Machine]
+	if !ok {
+		if plt.Entsize == 0 {
+			return nil, nil
+		}
+		layouts = []pltLayout{{headerSize: plt.Entsize, entrySize: plt.Entsize}}
+	}
+	// The layout of the linker is the one whose stubs fill the section.
+	layout := layouts[0]
+	for _, l := range layouts {
+		if l.headerSize+uint64(len(pltRelocs))*l.entrySize == plt.Size {
+			layout = l
+			break
+		}
+	}
+
+	syms := make([]elf.Symbol, 0, len(pltRelocs))
+	for i, r := range pltRelocs {
+		sym, ok := dynSym(r.sym)
+		if !ok {
+			continue
+		}
+		addr := plt.Addr + layout.headerSize + uint64(i)*layout.entrySize
+		if addr+layout.entrySize > plt.Addr+plt.Size {
+			break
+		}
+		syms = append(syms, pltSymbol(sym, addr, layout.entrySize))
+	}
+	return syms, nil
+}
+
+// x86PLTSymbols decodes the indirect jumps of the PLT stubs, "jmp *slot(%rip)" on x86-64
+// and "jmp *slot" or "jmp *slot(%ebx)" on i386, to find the GOT slots they go through.
+func x86PLTSymbols(f *elf.File, slots map[uint64]elf.Symbol) ([]elf.Symbol, error) {
+	// %ebx holds the address of the GOT in position independent i386 code.
+	var gotBase uint64
+	if sec := f.Section(".got.plt"); sec != nil {
+		gotBase = sec.Addr
+	}
+
+	var syms []elf.Symbol
+	for _, name := range []string{".plt", ".plt.sec", ".plt.got"} {
+		sec := f.Section(name)
+		if sec == nil || sec.Type != elf.SHT_PROGBITS {
+			continue
+		}
+		var start, entrySize uint64 = 0, 16
+		switch name {
+		case ".plt":
+			if f.Section(".plt.sec") != nil {
+				// With IBT the stubs of .plt only push the relocation index for lazy binding,
+				// the entry points are in .plt.sec.
+				continue
+			}
+			start = 16
+		case ".plt.got":
+			if sec.Entsize != 0 {
+				entrySize = sec.Entsize
+			} else {
+				entrySize = 8
+			}
+		}
+
+		data, err := sec.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s section: %w", name, err)
+		}
+		for off := start; off+entrySize <= uint64(len(data)); off += entrySize {
+			addr := sec.Addr + off
+			slot, ok := x86JumpSlot(f.Machine, data[off:off+entrySize], addr, gotBase)
+			if !ok {
+				continue
+			}
+			if sym, ok := slots[slot]; ok {
+				syms = append(syms, pltSymbol(sym, addr, entrySize))
+			}
+		}
+	}
+	return syms, nil
+}
+
+// x86JumpSlot returns the address of the GOT slot the indirect jump of a PLT stub reads.
+// The jump is either first or follows an endbr64/endbr32 and a bnd prefix.
+func x86JumpSlot(machine elf.Machine, stub []byte, addr, gotBase uint64) (uint64, bool) {
+	for _, p := range []int{0, 1, 4, 5} {
+		if p+6 > len(stub) || stub[p] != 0xff {
+			continue
+		}
+		disp := int64(int32(binary.LittleEndian.Uint32(stub[p+2:])))
+		switch {
+		case stub[p+1] == 0x25 && machine == elf.EM_X86_64:
+			return uint64(int64(addr) + int64(p) + 6 + disp), true
+		case stub[p+1] == 0x25:
+			return uint64(uint32(disp)), true
+		case stub[p+1] == 0xa3 && machine == elf.EM_386:
+			return uint64(uint32(int64(gotBase) + disp)), true
+		}
+	}
+	return 0, false
+}
+
+// readDynRelocs reads the entries of a SHT_RELA or SHT_REL section of either class.
+func readDynRelocs(f *elf.File, sec *elf.Section) ([]dynReloc, error) {
+	data, err := sec.Data()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s section: %w", sec.Name, err)
+	}
+
+	is64 := f.Class == elf.ELFCLASS64
+	var size int
+	switch {
+	case is64 && sec.Type == elf.SHT_RELA:
+		size = 24
+	case is64:
+		size = 16
+	case sec.Type == elf.SHT_RELA:
+		size = 12
+	default:
+		size = 8
+	}
+
+	relocs := make([]dynReloc, 0, len(data)/size)
+	for b := data; len(b) >= size; b = b[size:] {
+		if is64 {
+			relocs = append(relocs, dynReloc{
+				offset: f.ByteOrder.Uint64(b),
+				sym:    uint64(elf.R_SYM64(f.ByteOrder.Uint64(b[8:]))),
+			})
+			continue
+		}
+		relocs = append(relocs, dynReloc{
+			offset: uint64(f.ByteOrder.Uint32(b)),
+			sym:    uint64(elf.R_SYM32(f.ByteOrder.Uint32(b[4:]))),
+		})
+	}
+	return relocs, nil
+}
+
+// pltSymbol returns the symbol of the PLT stub of the dynamic symbol sym.
+// The version and the library the symbol is expected from are kept.
+func pltSymbol(sym elf.Symbol, addr, size uint64) elf.Symbol {
+	return elf.Symbol{
+		Name:    sym.Name + pltSuffix,
+		Info:    elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
+		Section: elf.SectionIndex(1), // just to pass elfSymIsFunction's section check
+		Value:   addr,
+		Size:    size,
+		Version: sym.Version,
+		Library: sym.Library,
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"gitlab.com/Raven-IO/GoSymTable/profile"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/pathmap"
+)
+
+// remapLines rewrites the file names of lines in place with m.
+// Unknown file names ("?") are left alone.
+func remapLines(m *pathmap.Mapper, lines []profile.LocationLine) []profile.LocationLine {
+	if m == nil {
+		return lines
+	}
+	for _, l := range lines {
+		if l.Function == nil || l.Function.Filename == "" || l.Function.Filename == "?" {
+			continue
+		}
+		l.Function.Filename = m.Remap(l.Function.Filename)
+	}
+	return lines
+}
@@ -0,0 +1,98 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package addr2line
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// NameMatcher selects functions by name for the reverse lookups.
+type NameMatcher func(name string) bool
+
+// MatchExact returns a matcher of the functions named name.
+func MatchExact(name string) NameMatcher {
+	return func(s string) bool { return s == name }
+}
+
+// MatchGlob returns a matcher of the function names matching pattern as a whole,
+// in which "*" matches any sequence of characters and "?" a single character.
+// Unlike path.Match, "*" also matches "/", which appears in Go package paths.
+func MatchGlob(pattern string) (NameMatcher, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+	}
+	return re.MatchString, nil
+}
+
+// MatchRegexp returns a matcher of the function names containing a match of expr.
+func MatchRegexp(expr string) (NameMatcher, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile function regexp: %w", err)
+	}
+	return re.MatchString, nil
+}
+
+// FuncMatch is a function found by name.
+type FuncMatch struct {
+	// Name is the demangled name of the function, SystemName the name in the binary.
+	Name       string
+	SystemName string
+	// Entry is the address of the first instruction executed when the function is called.
+	Entry uint64
+	// Ranges are the address intervals [start, end) of the code of the function.
+	Ranges [][2]uint64
+}
+
+// LineMatch is an address interval [Start, End) of the code generated for a source line.
+type LineMatch struct {
+	File     string
+	Line     int
+	Function string
+	Start    uint64
+	End      uint64
+}
+
+// matchFile returns a matcher of the file names equal to file or ending with
+// "/" followed by file, e.g. "main.go" or "cmd/app/main.go" for "/src/cmd/app/main.go".
+func matchFile(file string) func(string) bool {
+	return func(name string) bool {
+		return name == file || strings.HasSuffix(name, "/"+file)
+	}
+}
+
+func sortFuncMatches(res []FuncMatch) {
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Entry < res[j].Entry })
+}
+
+func sortLineMatches(res []LineMatch) {
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Start < res[j].Start })
+}
@@ -15,12 +15,11 @@
 package addr2line
 
 import (
-	"bytes"
 	"debug/elf"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 
 	"github.com/go-kit/log"
 	"gitlab.com/Raven-IO/GoSymTable/symbol/demangle"
@@ -41,6 +40,24 @@ type SymtabLiner struct {
 	demangler *demangle.Demangler
 	searcher  symbolsearcher.Searcher
 
+	// StrictBounds makes PCToLines fail with a *symbolsearcher.NotFoundError for addresses
+	// past the end of the closest symbol, instead of attributing them to it.
+	StrictBounds bool
+	// SymbolVersions appends the version of the dynamic symbols to their name,
+	// e.g. "memcpy@GLIBC_2.14" or "memcpy@GLIBC_2.14@plt".
+	SymbolVersions bool
+	// PLTLibraries sets the file name of the @plt stubs to the library expected to define
+	// the called symbol, e.g. "libc.so.6", when the symbol is versioned.
+	PLTLibraries bool
+	// Innermost makes PCToLines return the innermost symbol containing the address, e.g. a
+	// label of hand-written assembly instead of the function around it, see
+	// symbolsearcher.IntervalSearcher. StrictBounds is implied.
+	Innermost bool
+
+	symbols       []elf.Symbol
+	intervalsOnce sync.Once
+	intervals     *symbolsearcher.IntervalSearcher
+
 	filename string
 	f        *elf.File
 }
@@ -56,6 +73,7 @@ func Symbols(logger log.Logger, filename string, f *elf.File, demangler *demangl
 	return &SymtabLiner{
 		logger:    log.With(logger, "liner", "symtab"),
 		searcher:  searcher,
+		symbols:   symbols,
 		demangler: demangler,
 		filename:  filename,
 		f:         f,
@@ -74,17 +92,54 @@ func (lnr *SymtabLiner) PCRange() ([2]uint64, error) {
 	return lnr.searcher.PCRange()
 }
 
+// WriteIndex writes the symbols of the liner as a symbol index, see symbolsearcher.WriteIndex.
+func (lnr *SymtabLiner) WriteIndex(w io.Writer, buildID string) error {
+	return symbolsearcher.WriteIndex(w, buildID, lnr.searcher)
+}
+
 // PCToLines looks up the line number information for a program counter (memory address).
 func (lnr *SymtabLiner) PCToLines(addr uint64) (lines []profile.LocationLine, err error) {
-	name, err := lnr.searcher.Search(addr)
+	var sym elf.Symbol
+	if lnr.Innermost {
+		sym, err = lnr.intervalSearcher().SearchSymbol(addr)
+	} else {
+		searcher := lnr.searcher
+		searcher.Strict = lnr.StrictBounds
+		sym, err = searcher.SearchSymbol(addr)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	var (
-		file = "?"
-		line int64 // 0
-	)
+	lines = append(lines, profile.LocationLine{
+		Line:     0,
+		Function: lnr.function(sym),
+	})
+	return lines, nil
+}
+
+// CoveringFunctions returns the functions of all the symbols containing addr,
+// from the outermost to the innermost, see symbolsearcher.IntervalSearcher.SearchAll.
+func (lnr *SymtabLiner) CoveringFunctions(addr uint64) []*pb.Function {
+	syms := lnr.intervalSearcher().SearchAll(addr)
+	funcs := make([]*pb.Function, 0, len(syms))
+	for _, sym := range syms {
+		funcs = append(funcs, lnr.function(sym))
+	}
+	return funcs
+}
+
+// intervalSearcher returns the interval index of the symbols, built on first use.
+func (lnr *SymtabLiner) intervalSearcher() *symbolsearcher.IntervalSearcher {
+	lnr.intervalsOnce.Do(func() {
+		lnr.intervals = symbolsearcher.NewInterval(lnr.symbols)
+	})
+	return lnr.intervals
+}
+
+// function returns the demangled function of sym.
+func (lnr *SymtabLiner) function(sym elf.Symbol) *pb.Function {
+	name := sym.Name
 
 	// plt symbol suffix with pltSuffix
 	// to demangle name, we should remove the pltSuffix first
@@ -92,16 +147,18 @@ func (lnr *SymtabLiner) PCToLines(addr uint64) (lines []profile.LocationLine, er
 	isplt := strings.HasSuffix(name, pltSuffix)
 	result := lnr.demangler.Demangle(&pb.Function{
 		SystemName: strings.TrimSuffix(name, pltSuffix),
-		Filename:   file,
+		Filename:   "?",
 	})
+	if lnr.SymbolVersions && sym.Version != "" {
+		result.Name = result.Name + "@" + sym.Version
+	}
 	if isplt {
 		result.Name = result.Name + pltSuffix
+		if lnr.PLTLibraries && sym.Library != "" {
+			result.Filename = sym.Library
+		}
 	}
-	lines = append(lines, profile.LocationLine{
-		Line:     line,
-		Function: result,
-	})
-	return lines, nil
+	return result
 }
 
 // symtab returns symbols from the symbol table extracted from the ELF file f.
@@ -111,45 +168,12 @@ func symtab(objFile *elf.File) ([]elf.Symbol, error) {
 	syms, sErr := objFile.Symbols()
 	dynSyms, dErr := objFile.DynamicSymbols()
 
-	var pltSymbols []elf.Symbol
-	// see symbol-elf.c/dso__synthesize_plt_symbols
-	if pltRelSection, pltSection := objFile.Section(".rela.plt"), objFile.Section(".plt"); dErr == nil &&
-		pltRelSection != nil && pltSection != nil &&
-		objFile.Sections[pltRelSection.Link].Type == elf.SHT_DYNSYM {
-		data, err := io.ReadAll(pltRelSection.Open())
+	var pltSyms []elf.Symbol
+	if dErr == nil {
+		var err error
+		pltSyms, err = pltSymbols(objFile, dynSyms)
 		if err != nil {
-			return nil, fmt.Errorf("failed to data of .rela.plt section:%s", err)
-		}
-		var rela elf.Rela64
-		b := bytes.NewReader(data)
-
-		// in perf script, it use pltSection.Offset
-		// but the computeBase return symbol address, which require pltSection.Addr
-		// - ET_EXEC, pltSection.Addr and  pltSection.Offset not same
-		// - ET_DYNSYM, pltSection.Addr and  pltSection.Offset is same
-		off := pltSection.Addr
-		for b.Len() > 0 {
-			off += pltSection.Entsize
-			err := binary.Read(b, objFile.ByteOrder, &rela)
-			if err != nil {
-				return nil, fmt.Errorf("read plt section error, err:%s, section:%v", err, *pltRelSection)
-			}
-			// see applyRelocationsAMD64 go1.19.3/src/debug/elf/file.go:664
-			i := rela.Info >> 32
-			if i > uint64(len(dynSyms)) || i == 0 {
-				continue
-			}
-			s := dynSyms[i-1]
-
-			pltSymbols = append(pltSymbols, elf.Symbol{
-				Name:    s.Name + pltSuffix,
-				Info:    elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
-				Section: elf.SectionIndex(1), // just to pass elfSymIsFunction's section check
-				Value:   off,
-				Size:    pltRelSection.Entsize,
-				Version: "",
-				Library: "",
-			})
+			return nil, fmt.Errorf("failed to synthesize plt symbols: %w", err)
 		}
 	}
 
@@ -157,6 +181,46 @@ func symtab(objFile *elf.File) ([]elf.Symbol, error) {
 		return nil, fmt.Errorf("failed to read symbol sections: %w", sErr)
 	}
 
-	syms = append(syms, append(dynSyms, pltSymbols...)...)
+	syms = append(syms, append(dynSyms, pltSyms...)...)
 	return syms, nil
 }
+
+// LookupFunctions returns the function symbols whose name, raw or demangled, is matched by m.
+// The @plt stubs are matched with their suffix, e.g. "puts@plt". The size of the symbols
+// with none extends the range up to the next symbol.
+func (lnr *SymtabLiner) LookupFunctions(m NameMatcher) ([]FuncMatch, error) {
+	syms := lnr.searcher.Symbols()
+
+	var res []FuncMatch
+	for i, sym := range syms {
+		name := strings.TrimSuffix(sym.Name, pltSuffix)
+		demangled := lnr.demangler.Demangle(&pb.Function{SystemName: name}).Name
+		if demangled == "" {
+			demangled = name
+		}
+		if len(name) < len(sym.Name) {
+			demangled += pltSuffix
+		}
+		if !m(sym.Name) && !m(demangled) {
+			continue
+		}
+
+		end := sym.Value + sym.Size
+		if sym.Size == 0 {
+			end = sym.Value
+			for _, next := range syms[i+1:] {
+				if next.Value > sym.Value {
+					end = next.Value
+					break
+				}
+			}
+		}
+		res = append(res, FuncMatch{
+			Name:       demangled,
+			SystemName: sym.Name,
+			Entry:      sym.Value,
+			Ranges:     [][2]uint64{{sym.Value, end}},
+		})
+	}
+	return res, nil
+}
@@ -15,7 +15,12 @@
 package addr2line
 
 import (
+	"bytes"
 	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/go-kit/log"
@@ -38,6 +43,7 @@ func TestSymtabLiner_PCToLines(t *testing.T) {
 		name      string
 		fields    fields
 		args      args
+		strict    bool
 		wantLines []profile.LocationLine
 		wantErr   bool
 	}{
@@ -186,6 +192,59 @@ func TestSymtabLiner_PCToLines(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "strict past the end of a symbol",
+			fields: fields{
+				symbols: []elf.Symbol{
+					{
+						Name:  "foo",
+						Value: 1,
+						Size:  3,
+					},
+					{
+						Name:  "bar",
+						Value: 10,
+						Size:  3,
+					},
+				},
+			},
+			args: args{
+				addr: 4,
+			},
+			strict:  true,
+			wantErr: true,
+		},
+		{
+			name: "strict inside a symbol",
+			fields: fields{
+				symbols: []elf.Symbol{
+					{
+						Name:  "foo",
+						Value: 1,
+						Size:  3,
+					},
+					{
+						Name:  "bar",
+						Value: 10,
+						Size:  3,
+					},
+				},
+			},
+			args: args{
+				addr: 3,
+			},
+			strict: true,
+			wantLines: []profile.LocationLine{
+				{
+					Function: &metastorev1alpha1.Function{
+						Name:       "foo",
+						SystemName: "foo",
+						Filename:   "?",
+					},
+					Line: 0,
+				},
+			},
+		},
 		{
 			name: "C++ symbols are demangled",
 			fields: fields{
@@ -221,9 +280,10 @@ func TestSymtabLiner_PCToLines(t *testing.T) {
 			}
 			searcher := symbolsearcher.New(tt.fields.symbols)
 			lnr := &SymtabLiner{
-				logger:    log.NewNopLogger(),
-				searcher:  searcher,
-				demangler: demangle.NewDemangler("simple", false),
+				logger:       log.NewNopLogger(),
+				searcher:     searcher,
+				demangler:    demangle.NewDemangler("simple", false),
+				StrictBounds: tt.strict,
 			}
 			gotLines, err := lnr.PCToLines(tt.args.addr)
 			if (err != nil) != tt.wantErr {
@@ -234,3 +294,252 @@ func TestSymtabLiner_PCToLines(t *testing.T) {
 		})
 	}
 }
+
+func TestSymtab_PLT(t *testing.T) {
+	type plt struct {
+		addr uint64
+		size uint64
+	}
+	tests := []struct {
+		file string
+		want map[string]plt
+	}{
+		{
+			file: "testdata/plt-x86_64",
+			want: map[string]plt{"lib_add@plt": {0x1010, 16}, "lib_mul@plt": {0x1020, 8}},
+		},
+		{
+			file: "testdata/plt-x86_64-ibt",
+			want: map[string]plt{"lib_mul@plt": {0x1020, 16}, "lib_add@plt": {0x1030, 16}},
+		},
+		{
+			file: "testdata/plt-i386",
+			want: map[string]plt{"lib_add@plt": {0x1010, 16}, "lib_mul@plt": {0x1020, 8}},
+		},
+		{
+			file: "testdata/plt-i386-nopie",
+			want: map[string]plt{"lib_mul@plt": {0x8049010, 16}, "lib_add@plt": {0x8049020, 16}},
+		},
+		{
+			// Linked by lld, see testdata/Makefile. The GNU ld layouts of aarch64 and riscv64 are the same.
+			file: "testdata/plt-aarch64",
+			want: map[string]plt{"lib_mul@plt": {0x10310, 16}, "lib_add@plt": {0x10320, 16}},
+		},
+		{
+			file: "testdata/plt-riscv64",
+			want: map[string]plt{"lib_mul@plt": {0x1340, 16}, "lib_add@plt": {0x1350, 16}},
+		},
+		{
+			// The lld layout: a 32 bytes header and 16 bytes stubs instead of 20 and 12 with GNU ld.
+			file: "testdata/plt-arm",
+			want: map[string]plt{"lib_mul@plt": {0x10230, 16}, "lib_add@plt": {0x10240, 16}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := elf.Open(tt.file)
+			require.NoError(t, err)
+			defer f.Close()
+
+			syms, err := symtab(f)
+			require.NoError(t, err)
+
+			got := map[string]plt{}
+			for _, s := range syms {
+				if strings.HasSuffix(s.Name, pltSuffix) {
+					got[s.Name] = plt{s.Value, s.Size}
+				}
+			}
+			require.Equal(t, tt.want, got)
+
+			// The middle of a stub is attributed to it.
+			lnr := &SymtabLiner{
+				logger:       log.NewNopLogger(),
+				searcher:     symbolsearcher.New(syms),
+				demangler:    demangle.NewDemangler("simple", false),
+				StrictBounds: true,
+			}
+			for name, p := range tt.want {
+				lines, err := lnr.PCToLines(p.addr + p.size/2)
+				require.NoError(t, err)
+				require.Equal(t, name, lines[0].Function.Name)
+			}
+		})
+	}
+}
+
+// No GNU cross linker builds the ARM fixture, its .plt is resized to the GNU ld layout:
+// a 20 bytes header and 12 bytes stubs.
+func TestSymtab_PLTGNULayoutARM(t *testing.T) {
+	data, err := os.ReadFile("testdata/plt-arm")
+	require.NoError(t, err)
+
+	f, err := elf.NewFile(bytes.NewReader(data))
+	require.NoError(t, err)
+	plt := f.Section(".plt")
+	require.NotNil(t, plt)
+	require.Equal(t, uint64(32+2*16), plt.Size)
+
+	// sh_size is at offset 20 of the 40 bytes ELF32 section headers.
+	shoff := binary.LittleEndian.Uint32(data[0x20:])
+	for i, sec := range f.Sections {
+		if sec == plt {
+			binary.LittleEndian.PutUint32(data[shoff+uint32(i)*40+20:], 20+2*12)
+		}
+	}
+
+	f, err = elf.NewFile(bytes.NewReader(data))
+	require.NoError(t, err)
+	syms, err := symtab(f)
+	require.NoError(t, err)
+
+	got := map[string][2]uint64{}
+	for _, s := range syms {
+		if strings.HasSuffix(s.Name, pltSuffix) {
+			got[s.Name] = [2]uint64{s.Value, s.Size}
+		}
+	}
+	require.Equal(t, map[string][2]uint64{
+		"lib_mul@plt": {plt.Addr + 20, 12},
+		"lib_add@plt": {plt.Addr + 32, 12},
+	}, got)
+}
+
+func TestSymtabLiner_WriteIndex(t *testing.T) {
+	filename := "testdata/data-c-with-debuginfo"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+
+	lnr, err := Symbols(log.NewNopLogger(), filename, f, nil)
+	require.NoError(t, err)
+	defer lnr.Close()
+
+	var buf bytes.Buffer
+	require.NoError(t, lnr.WriteIndex(&buf, "49b1b3a96f1d06c67b2c5db057926c7e1a3d7d40"))
+	idx, err := symbolsearcher.NewIndex(buf.Bytes())
+	require.NoError(t, err)
+
+	r, err := lnr.PCRange()
+	require.NoError(t, err)
+	for addr := r[0]; addr < r[1]; addr++ {
+		want, wantErr := lnr.searcher.Search(addr)
+		got, err := idx.Search(addr)
+		require.Equal(t, wantErr, err)
+		require.Equal(t, want, got)
+	}
+}
+
+func TestSymtabLiner_SymbolVersions(t *testing.T) {
+	filename := "testdata/plt-versions"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+
+	lnr, err := Symbols(log.NewNopLogger(), filename, f, demangle.NewDemangler("simple", false))
+	require.NoError(t, err)
+	defer lnr.Close()
+
+	const (
+		puts   = 0x401030
+		memcpy = 0x401040
+	)
+
+	tests := []struct {
+		name      string
+		versions  bool
+		libraries bool
+		addr      uint64
+		want      string
+		wantFile  string
+	}{
+		{name: "default", addr: memcpy, want: "memcpy@plt", wantFile: "?"},
+		{name: "versions", versions: true, addr: memcpy, want: "memcpy@GLIBC_2.14@plt", wantFile: "?"},
+		{name: "libraries", libraries: true, addr: puts, want: "puts@plt", wantFile: "libc.so.6"},
+		{name: "both", versions: true, libraries: true, addr: puts + 4, want: "puts@GLIBC_2.2.5@plt", wantFile: "libc.so.6"},
+		{name: "unversioned", versions: true, libraries: true, addr: 0x401136, want: "main", wantFile: "?"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lnr.SymbolVersions = tt.versions
+			lnr.PLTLibraries = tt.libraries
+
+			lines, err := lnr.PCToLines(tt.addr)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, lines[0].Function.Name)
+			require.Equal(t, tt.wantFile, lines[0].Function.Filename)
+		})
+	}
+}
+
+func TestSymtabLiner_LookupFunctions(t *testing.T) {
+	filename := "testdata/plt-versions"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+
+	lnr, err := Symbols(log.NewNopLogger(), filename, f, demangle.NewDemangler("simple", false))
+	require.NoError(t, err)
+	defer lnr.Close()
+
+	m, err := MatchGlob("*@plt")
+	require.NoError(t, err)
+	funcs, err := lnr.LookupFunctions(m)
+	require.NoError(t, err)
+	require.Equal(t, []FuncMatch{
+		{Name: "puts@plt", SystemName: "puts@plt", Entry: 0x401030, Ranges: [][2]uint64{{0x401030, 0x401040}}},
+		{Name: "memcpy@plt", SystemName: "memcpy@plt", Entry: 0x401040, Ranges: [][2]uint64{{0x401040, 0x401050}}},
+	}, funcs)
+
+	funcs, err = lnr.LookupFunctions(MatchExact("main"))
+	require.NoError(t, err)
+	require.Len(t, funcs, 1)
+	require.Equal(t, uint64(0x401136), funcs[0].Entry)
+
+	_, err = MatchGlob("[")
+	require.NoError(t, err, "brackets are literal")
+	_, err = MatchRegexp("(")
+	require.Error(t, err)
+}
+
+func TestSymtabLiner_Innermost(t *testing.T) {
+	filename := "testdata/nested-c"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+
+	lnr, err := Symbols(log.NewNopLogger(), filename, f, demangle.NewDemangler("simple", false))
+	require.NoError(t, err)
+	defer lnr.Close()
+
+	const outer = 0x401106
+
+	tests := []struct {
+		addr      uint64
+		want      string
+		innermost string
+		covering  []string
+	}{
+		// The flat searcher picks the alias with the longest name, whatever its size.
+		{addr: outer, want: "outer_head", innermost: "outer_head", covering: []string{"outer", "outer_head"}},
+		{addr: outer + 1, want: "outer_head", innermost: "outer_head", covering: []string{"outer", "outer_head"}},
+		{addr: outer + 3, want: "inner", innermost: "inner", covering: []string{"outer", "inner"}},
+		// Past the end of inner, the flat searcher still attributes the address to it.
+		{addr: outer + 6, want: "inner", innermost: "outer", covering: []string{"outer"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%#x", tt.addr), func(t *testing.T) {
+			lnr.Innermost = false
+			lines, err := lnr.PCToLines(tt.addr)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, lines[0].Function.Name)
+
+			lnr.Innermost = true
+			lines, err = lnr.PCToLines(tt.addr)
+			require.NoError(t, err)
+			require.Equal(t, tt.innermost, lines[0].Function.Name)
+
+			var names []string
+			for _, fn := range lnr.CoveringFunctions(tt.addr) {
+				names = append(names, fn.SystemName)
+			}
+			require.Equal(t, tt.covering, names)
+		})
+	}
+}
@@ -0,0 +1,7 @@
+// Listed by .debug_aranges.
+
+int other(int x);
+
+int main(void) {
+	return other(1);
+}
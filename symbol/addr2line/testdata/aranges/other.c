@@ -0,0 +1,5 @@
+// Missing from .debug_aranges, like with clang.
+
+int other(int x) {
+	return x + 1;
+}
@@ -0,0 +1,34 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+/*
+__attribute__((noinline)) static int c_leaf(int x) {
+	return x * 2;
+}
+
+int c_entry(int x) {
+	return c_leaf(x) + 1;
+}
+*/
+import "C"
+
+//go:noinline
+func goCaller() int {
+	return int(C.c_entry(20))
+}
+
+func main() {
+	println(goCaller())
+}
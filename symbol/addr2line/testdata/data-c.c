@@ -0,0 +1,18 @@
+struct header {
+	int magic;
+	short flags;
+	short kind;
+};
+
+struct table {
+	struct header hdr;
+	long items[8];
+};
+
+struct table global_table = {{1, 2, 3}};
+static int static_counter = 42;
+int uninitialized_buffer[16];
+
+int main(void) {
+	return global_table.hdr.magic + static_counter + uninitialized_buffer[0];
+}
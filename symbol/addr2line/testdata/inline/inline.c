@@ -0,0 +1,17 @@
+// Nested inlined calls.
+
+volatile int sink;
+
+static inline __attribute__((always_inline)) void leaf(int x) {
+	sink = x;
+}
+
+static inline __attribute__((always_inline)) void middle(int x) {
+	leaf(x + 1);
+	sink = x;
+}
+
+int main(void) {
+	middle(1);
+	return 0;
+}
@@ -0,0 +1,10 @@
+// Kept out of line in main.cpp.
+
+#include "lib.h"
+
+extern volatile int sink;
+
+__attribute__((noinline)) int Scaler::scale(int x) {
+	sink = x;
+	return factor * x;
+}
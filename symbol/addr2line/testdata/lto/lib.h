@@ -0,0 +1,4 @@
+struct Scaler {
+	int factor;
+	int scale(int x);
+};
@@ -0,0 +1,11 @@
+// Calls of a member function defined in lib.cpp, optimized at link time.
+
+#include "lib.h"
+
+volatile int sink;
+
+int main() {
+	Scaler s{3};
+	sink = s.scale(sink);
+	return 0;
+}
@@ -0,0 +1,34 @@
+// Nested and overlapping function symbols, like labels of hand-written assembly.
+//
+//	outer       [outer, outer+8)
+//	outer_head  [outer, outer+2), an alias of outer with a smaller size
+//	inner       [outer+2, outer+5), nested in outer
+__asm__(
+	".text\n"
+	".globl outer\n"
+	".type outer, @function\n"
+	"outer:\n"
+	".globl outer_head\n"
+	".type outer_head, @function\n"
+	"outer_head:\n"
+	"	nop\n"
+	"	nop\n"
+	".size outer_head, .-outer_head\n"
+	".globl inner\n"
+	".type inner, @function\n"
+	"inner:\n"
+	"	nop\n"
+	"	nop\n"
+	"	ret\n"
+	".size inner, .-inner\n"
+	"	nop\n"
+	"	nop\n"
+	"	ret\n"
+	".size outer, .-outer\n");
+
+void outer(void);
+
+int main(void) {
+	outer();
+	return 0;
+}
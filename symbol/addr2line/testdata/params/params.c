@@ -0,0 +1,22 @@
+// Parameters passed in registers and on the stack by the System V ABI.
+
+struct pair {
+	long a, b;
+};
+
+volatile long sink;
+
+__attribute__((noinline)) long add(long x, int y) {
+	sink = x;
+	return x + y;
+}
+
+__attribute__((noinline)) long spill(long a, long b, long c, long d, long e, long f, long g, struct pair p) {
+	sink = a + b + c + d + e + f;
+	return add(g, 1) + p.a + p.b;
+}
+
+int main(void) {
+	struct pair p = {1, 2};
+	return (int)spill(1, 2, 3, 4, 5, 6, 7, p);
+}
@@ -0,0 +1,3 @@
+int lib_add(int a, int b) { return a + b; }
+
+int lib_mul(int a, int b) { return a * b; }
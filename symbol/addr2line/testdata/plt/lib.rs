@@ -0,0 +1,16 @@
+// The shared library called by main.rs.
+#![feature(no_core, lang_items)]
+#![no_core]
+
+#[lang = "pointee_sized"]
+pub trait PointeeSized {}
+#[lang = "meta_sized"]
+pub trait MetaSized: PointeeSized {}
+#[lang = "sized"]
+pub trait Sized: MetaSized {}
+
+#[no_mangle]
+pub extern "C" fn lib_add() {}
+
+#[no_mangle]
+pub extern "C" fn lib_mul() {}
@@ -0,0 +1,14 @@
+int lib_add(int, int);
+int lib_mul(int, int);
+
+static int call(int (*f)(int, int), int a, int b) { return f(a, b); }
+
+// lib_add is only called, through .plt or .plt.sec. The address of lib_mul is taken,
+// which makes the linker call it through its GOT entry in .plt.got in position independent code.
+int main(void) { return lib_add(1, 2) + lib_mul(3, 4) + call(lib_mul, 5, 6); }
+
+void _start(void) {
+	main();
+	for (;;) {
+	}
+}
@@ -0,0 +1,25 @@
+// Calls of lib.rs through .plt, built without the core library for the targets without
+// a cross C toolchain.
+#![feature(no_core, lang_items)]
+#![no_core]
+
+#[lang = "pointee_sized"]
+pub trait PointeeSized {}
+#[lang = "meta_sized"]
+pub trait MetaSized: PointeeSized {}
+#[lang = "sized"]
+pub trait Sized: MetaSized {}
+
+extern "C" {
+    fn lib_mul();
+    fn lib_add();
+}
+
+#[no_mangle]
+pub extern "C" fn _start() -> ! {
+    unsafe {
+        lib_mul();
+        lib_add();
+    }
+    loop {}
+}
@@ -0,0 +1,11 @@
+#include <stdio.h>
+#include <string.h>
+
+int main(int argc, char **argv) {
+	char buf[64];
+	// -fno-builtin keeps the calls to the versioned libc functions.
+	memcpy(buf, argv[0], sizeof(buf) - 1);
+	buf[sizeof(buf) - 1] = 0;
+	puts(buf);
+	return argc;
+}
@@ -0,0 +1,7 @@
+namespace ns {
+__attribute__((noinline)) int leaf(int x) { return x * 3; }
+} // namespace ns
+
+__attribute__((noinline)) int middle(int x) { return ns::leaf(x) + 1; }
+
+int main() { return middle(2); }
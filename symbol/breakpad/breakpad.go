@@ -0,0 +1,370 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package breakpad reads and writes Breakpad symbol files (.sym), see
+// https://chromium.googlesource.com/breakpad/breakpad/+/master/docs/symbol_files.md.
+// The STACK records aren't supported and are skipped when reading.
+package breakpad
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SymbolFile is the content of a Breakpad symbol file. The addresses are relative
+// to the load address of the module.
+type SymbolFile struct {
+	Module Module
+	// CodeID is the identifier of the module binary, from the INFO CODE_ID record.
+	CodeID string
+	// Files are the source file names by number.
+	Files map[int]string
+	// InlineOrigins are the names of the inlined functions by number.
+	InlineOrigins map[int]string
+	// Funcs are sorted by address.
+	Funcs []*Func
+	// Publics are sorted by address.
+	Publics []Public
+}
+
+// Module identifies the module of a symbol file.
+type Module struct {
+	// OS is e.g. "Linux", "mac" or "windows".
+	OS string
+	// Arch is e.g. "x86_64" or "arm64".
+	Arch string
+	// ID is the debug identifier of the module, see DebugID.
+	ID   string
+	Name string
+}
+
+// Func is a function with its source lines and the functions inlined in it.
+type Func struct {
+	// Multiple is set when several functions were folded into this code.
+	Multiple  bool
+	Address   uint64
+	Size      uint64
+	ParamSize uint64
+	Name      string
+	// Lines are sorted by address.
+	Lines []Line
+	// Inlines are sorted by depth, then by address.
+	Inlines []Inline
+}
+
+// Line is the source line of the code at [Address, Address+Size).
+type Line struct {
+	Address uint64
+	Size    uint64
+	Line    int64
+	File    int
+}
+
+// Inline is the code of a function inlined at CallFile:CallLine. The inline of depth 0
+// is called by the Func, the one of depth n+1 by an inline of depth n.
+type Inline struct {
+	Depth    int
+	CallLine int64
+	CallFile int
+	Origin   int
+	Ranges   []Range
+}
+
+// Range is the address interval [Address, Address+Size).
+type Range struct {
+	Address uint64
+	Size    uint64
+}
+
+// Contains reports whether addr is in r.
+func (r Range) Contains(addr uint64) bool {
+	return r.Address <= addr && addr-r.Address < r.Size
+}
+
+// Public is a symbol without size or line information, e.g. from the symbol table.
+type Public struct {
+	Multiple  bool
+	Address   uint64
+	ParamSize uint64
+	Name      string
+}
+
+// DebugID returns the debug identifier of a module from the identifier of its binary,
+// e.g. its GNU build ID: the first 16 bytes as a GUID, whose first three fields are
+// little-endian, followed by a zero age.
+func DebugID(id []byte) string {
+	guid := make([]byte, 16)
+	copy(guid, id)
+	guid[0], guid[1], guid[2], guid[3] = guid[3], guid[2], guid[1], guid[0]
+	guid[4], guid[5] = guid[5], guid[4]
+	guid[6], guid[7] = guid[7], guid[6]
+	return strings.ToUpper(hex.EncodeToString(guid)) + "0"
+}
+
+// Parse reads a symbol file.
+func Parse(r io.Reader) (*SymbolFile, error) {
+	sf := &SymbolFile{
+		Files:         make(map[int]string),
+		InlineOrigins: make(map[int]string),
+	}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	var fn *Func
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		keyword, rest, _ := strings.Cut(line, " ")
+
+		var err error
+		switch keyword {
+		case "MODULE":
+			f := strings.SplitN(rest, " ", 4)
+			if len(f) != 4 {
+				err = errors.New("invalid MODULE record")
+				break
+			}
+			sf.Module = Module{OS: f[0], Arch: f[1], ID: f[2], Name: f[3]}
+		case "INFO":
+			if kind, id, _ := strings.Cut(rest, " "); kind == "CODE_ID" {
+				sf.CodeID, _, _ = strings.Cut(id, " ")
+			}
+		case "FILE":
+			err = parseNumbered(rest, sf.Files)
+		case "INLINE_ORIGIN":
+			err = parseNumbered(rest, sf.InlineOrigins)
+		case "FUNC":
+			fn, err = parseFunc(rest)
+			if err == nil {
+				sf.Funcs = append(sf.Funcs, fn)
+			}
+		case "INLINE":
+			if fn == nil {
+				err = errors.New("INLINE record outside of a function")
+				break
+			}
+			var in Inline
+			in, err = parseInline(rest)
+			fn.Inlines = append(fn.Inlines, in)
+		case "PUBLIC":
+			fn = nil
+			var p Public
+			p, err = parsePublic(rest)
+			sf.Publics = append(sf.Publics, p)
+		case "STACK":
+			fn = nil
+		default:
+			if fn == nil {
+				err = fmt.Errorf("unknown record %s", keyword)
+				break
+			}
+			var l Line
+			l, err = parseLine(line)
+			fn.Lines = append(fn.Lines, l)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read symbol file: %w", err)
+	}
+
+	sf.Sort()
+	return sf, nil
+}
+
+// parseNumbered parses the "number name" of the FILE and INLINE_ORIGIN records.
+func parseNumbered(s string, m map[int]string) error {
+	num, name, ok := strings.Cut(s, " ")
+	if !ok {
+		return errors.New("missing name")
+	}
+	i, err := strconv.Atoi(num)
+	if err != nil {
+		return fmt.Errorf("invalid number: %w", err)
+	}
+	m[i] = name
+	return nil
+}
+
+// cutMultiple removes the "m" flag of the FUNC and PUBLIC records.
+func cutMultiple(s string) (string, bool) {
+	if rest, ok := strings.CutPrefix(s, "m "); ok {
+		return rest, true
+	}
+	return s, false
+}
+
+func parseFunc(s string) (*Func, error) {
+	s, multiple := cutMultiple(s)
+	f := strings.SplitN(s, " ", 4)
+	if len(f) != 4 {
+		return nil, errors.New("invalid FUNC record")
+	}
+	nums, err := parseHex(f[:3])
+	if err != nil {
+		return nil, err
+	}
+	return &Func{Multiple: multiple, Address: nums[0], Size: nums[1], ParamSize: nums[2], Name: f[3]}, nil
+}
+
+func parsePublic(s string) (Public, error) {
+	s, multiple := cutMultiple(s)
+	f := strings.SplitN(s, " ", 3)
+	if len(f) != 3 {
+		return Public{}, errors.New("invalid PUBLIC record")
+	}
+	nums, err := parseHex(f[:2])
+	if err != nil {
+		return Public{}, err
+	}
+	return Public{Multiple: multiple, Address: nums[0], ParamSize: nums[1], Name: f[2]}, nil
+}
+
+func parseInline(s string) (Inline, error) {
+	f := strings.Fields(s)
+	if len(f) < 6 || len(f)%2 != 0 {
+		return Inline{}, errors.New("invalid INLINE record")
+	}
+	var in Inline
+	var nums [4]int64
+	for i := range nums {
+		n, err := strconv.ParseInt(f[i], 10, 64)
+		if err != nil {
+			return Inline{}, fmt.Errorf("invalid INLINE record: %w", err)
+		}
+		nums[i] = n
+	}
+	in.Depth, in.CallLine, in.CallFile, in.Origin = int(nums[0]), nums[1], int(nums[2]), int(nums[3])
+
+	ranges, err := parseHex(f[4:])
+	if err != nil {
+		return Inline{}, err
+	}
+	for i := 0; i < len(ranges); i += 2 {
+		in.Ranges = append(in.Ranges, Range{Address: ranges[i], Size: ranges[i+1]})
+	}
+	return in, nil
+}
+
+func parseLine(s string) (Line, error) {
+	f := strings.Fields(s)
+	if len(f) != 4 {
+		return Line{}, errors.New("invalid line record")
+	}
+	nums, err := parseHex(f[:2])
+	if err != nil {
+		return Line{}, err
+	}
+	line, err := strconv.ParseInt(f[2], 10, 64)
+	if err != nil {
+		return Line{}, fmt.Errorf("invalid line number: %w", err)
+	}
+	file, err := strconv.Atoi(f[3])
+	if err != nil {
+		return Line{}, fmt.Errorf("invalid file number: %w", err)
+	}
+	return Line{Address: nums[0], Size: nums[1], Line: line, File: file}, nil
+}
+
+func parseHex(fields []string) ([]uint64, error) {
+	nums := make([]uint64, len(fields))
+	for i, f := range fields {
+		n, err := strconv.ParseUint(f, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex number: %w", err)
+		}
+		nums[i] = n
+	}
+	return nums, nil
+}
+
+// Sort sorts the functions, their lines and inlines, and the public symbols.
+func (sf *SymbolFile) Sort() {
+	sort.SliceStable(sf.Funcs, func(i, j int) bool {
+		return sf.Funcs[i].Address < sf.Funcs[j].Address
+	})
+	for _, fn := range sf.Funcs {
+		sort.SliceStable(fn.Lines, func(i, j int) bool {
+			return fn.Lines[i].Address < fn.Lines[j].Address
+		})
+		sort.SliceStable(fn.Inlines, func(i, j int) bool {
+			a, b := fn.Inlines[i], fn.Inlines[j]
+			if a.Depth != b.Depth {
+				return a.Depth < b.Depth
+			}
+			return len(a.Ranges) > 0 && len(b.Ranges) > 0 && a.Ranges[0].Address < b.Ranges[0].Address
+		})
+	}
+	sort.SliceStable(sf.Publics, func(i, j int) bool {
+		return sf.Publics[i].Address < sf.Publics[j].Address
+	})
+}
+
+// Encode writes the symbol file in the text format. Files and inline origins are written
+// by number, then the functions and the public symbols in their order.
+func (sf *SymbolFile) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "MODULE %s %s %s %s\n", sf.Module.OS, sf.Module.Arch, sf.Module.ID, sf.Module.Name)
+	if sf.CodeID != "" {
+		fmt.Fprintf(bw, "INFO CODE_ID %s\n", sf.CodeID)
+	}
+	for _, i := range sortedKeys(sf.Files) {
+		fmt.Fprintf(bw, "FILE %d %s\n", i, sf.Files[i])
+	}
+	for _, i := range sortedKeys(sf.InlineOrigins) {
+		fmt.Fprintf(bw, "INLINE_ORIGIN %d %s\n", i, sf.InlineOrigins[i])
+	}
+	for _, fn := range sf.Funcs {
+		fmt.Fprintf(bw, "FUNC %s%x %x %x %s\n", multiple(fn.Multiple), fn.Address, fn.Size, fn.ParamSize, fn.Name)
+		for _, in := range fn.Inlines {
+			fmt.Fprintf(bw, "INLINE %d %d %d %d", in.Depth, in.CallLine, in.CallFile, in.Origin)
+			for _, r := range in.Ranges {
+				fmt.Fprintf(bw, " %x %x", r.Address, r.Size)
+			}
+			bw.WriteByte('\n')
+		}
+		for _, l := range fn.Lines {
+			fmt.Fprintf(bw, "%x %x %d %d\n", l.Address, l.Size, l.Line, l.File)
+		}
+	}
+	for _, p := range sf.Publics {
+		fmt.Fprintf(bw, "PUBLIC %s%x %x %s\n", multiple(p.Multiple), p.Address, p.ParamSize, p.Name)
+	}
+	return bw.Flush()
+}
+
+func multiple(m bool) string {
+	if m {
+		return "m "
+	}
+	return ""
+}
+
+func sortedKeys(m map[int]string) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
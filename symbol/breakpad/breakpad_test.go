@@ -0,0 +1,105 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package breakpad
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const symbolFile = `MODULE Linux x86_64 B3B0C8D9A1B2C3D4E5F60718293A4B5C0 app with spaces
+INFO CODE_ID D9C8B0B3B2A1D4C3E5F60718293A4B5C6D7E8F90
+FILE 0 /src/main.c
+FILE 1 /src/util.h
+INLINE_ORIGIN 0 leaf
+INLINE_ORIGIN 1 middle(int)
+FUNC 1000 40 0 main
+INLINE 0 12 0 1 1004 10 1020 8
+INLINE 1 5 1 0 1008 4
+1000 4 10 0
+1004 10 12 0
+1014 2c 13 0
+FUNC m 1040 8 0 operator new(unsigned long)
+1040 8 2 1
+PUBLIC 1100 0 _start
+PUBLIC m 1200 4 stub
+`
+
+func TestParseEncode(t *testing.T) {
+	sf, err := Parse(strings.NewReader(symbolFile))
+	require.NoError(t, err)
+
+	require.Equal(t, Module{OS: "Linux", Arch: "x86_64", ID: "B3B0C8D9A1B2C3D4E5F60718293A4B5C0", Name: "app with spaces"}, sf.Module)
+	require.Equal(t, "D9C8B0B3B2A1D4C3E5F60718293A4B5C6D7E8F90", sf.CodeID)
+	require.Equal(t, map[int]string{0: "/src/main.c", 1: "/src/util.h"}, sf.Files)
+	require.Equal(t, map[int]string{0: "leaf", 1: "middle(int)"}, sf.InlineOrigins)
+	require.Equal(t, []*Func{
+		{
+			Address: 0x1000,
+			Size:    0x40,
+			Name:    "main",
+			Lines: []Line{
+				{Address: 0x1000, Size: 4, Line: 10},
+				{Address: 0x1004, Size: 0x10, Line: 12},
+				{Address: 0x1014, Size: 0x2c, Line: 13},
+			},
+			Inlines: []Inline{
+				{Depth: 0, CallLine: 12, CallFile: 0, Origin: 1, Ranges: []Range{{0x1004, 0x10}, {0x1020, 8}}},
+				{Depth: 1, CallLine: 5, CallFile: 1, Origin: 0, Ranges: []Range{{0x1008, 4}}},
+			},
+		},
+		{
+			Multiple: true,
+			Address:  0x1040,
+			Size:     8,
+			Name:     "operator new(unsigned long)",
+			Lines:    []Line{{Address: 0x1040, Size: 8, Line: 2, File: 1}},
+		},
+	}, sf.Funcs)
+	require.Equal(t, []Public{
+		{Address: 0x1100, Name: "_start"},
+		{Multiple: true, Address: 0x1200, ParamSize: 4, Name: "stub"},
+	}, sf.Publics)
+
+	var buf bytes.Buffer
+	require.NoError(t, sf.Encode(&buf))
+	require.Equal(t, symbolFile, buf.String())
+}
+
+func TestParse_Errors(t *testing.T) {
+	for _, tt := range []struct {
+		name, data, err string
+	}{
+		{name: "bad module", data: "MODULE Linux x86_64\n", err: "line 1: invalid MODULE record"},
+		{name: "line outside of function", data: "MODULE Linux x86_64 0 a\n1000 4 10 0\n", err: "line 2: unknown record 1000"},
+		{name: "inline outside of function", data: "INLINE 0 1 0 0 1000 4\n", err: "line 1: INLINE record outside of a function"},
+		{name: "bad func", data: "FUNC zz 4 0 main\n", err: "line 1: invalid hex number"},
+		{name: "bad inline ranges", data: "FUNC 1000 4 0 main\nINLINE 0 1 0 0 1000\n", err: "line 2: invalid INLINE record"},
+		{name: "bad line", data: "FUNC 1000 4 0 main\n1000 4 x 0\n", err: "line 2: invalid line number"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.data))
+			require.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestDebugID(t *testing.T) {
+	id := []byte{0xd9, 0xc8, 0xb0, 0xb3, 0xb2, 0xa1, 0xd4, 0xc3, 0xe5, 0xf6, 0x07, 0x18, 0x29, 0x3a, 0x4b, 0x5c, 0x6d, 0x7e}
+	require.Equal(t, "B3B0C8D9A1B2C3D4E5F60718293A4B5C0", DebugID(id))
+	require.Equal(t, "040302010000000000000000000000000", DebugID([]byte{1, 2, 3, 4}))
+}
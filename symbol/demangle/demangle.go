@@ -14,14 +14,16 @@
 package demangle
 
 import (
+	"net/url"
 	"strings"
 
 	"github.com/ianlancetaylor/demangle"
 
 	pb "gitlab.com/Raven-IO/GoSymTable/protogen/go/metastore"
+	"gitlab.com/Raven-IO/GoSymTable/symbol/goname"
 )
 
-// Demangler demangles GCC/LLVM C++ and Rust symbol names.
+// Demangler demangles GCC/LLVM C++ and Rust symbol names, and simplifies Go symbol names.
 //
 // Demangling is the inverse process of mangling (encoding of each unique
 // function and parameter list combination into a unique name for the linker).
@@ -39,6 +41,11 @@ type Demangler struct {
 // "simple" (no demangling of return types, no function or template parameters),
 // and "none" (no demangling).
 //
+// Go symbol names are kept in full, with their shape instantiations, e.g. "pkg.F[go.shape.int]",
+// in "full" mode, without the "go.shape." prefixes in "templates" mode, e.g. "pkg.F[int]",
+// and with "..." for the type arguments in "simple" mode, e.g. "pkg.F[...]", like in the
+// Go tracebacks. The receivers, e.g. "pkg.(*T).M", are kept in all modes.
+//
 // If force is set, overwrite any names that appear already demangled.
 func NewDemangler(mode string, force bool) *Demangler {
 	var options []demangle.Option
@@ -87,6 +94,10 @@ func (d *Demangler) Demangle(fn *pb.Function) *pb.Function {
 	// Could not demangle. Apply heuristics in case the name is
 	// already demangled.
 	name := fn.SystemName
+	if looksLikeGo(name) {
+		fn.Name = d.simplifyGo(name)
+		return fn
+	}
 	if looksLikeDemangledCPlusPlus(name) {
 		if d.mode == "" || d.mode == "templates" {
 			name = removeMatching(name, '(', ')')
@@ -109,6 +120,34 @@ func looksLikeDemangledCPlusPlus(demangled string) bool {
 	return strings.ContainsAny(demangled, "<>[]") || strings.Contains(demangled, "::")
 }
 
+// looksLikeGo is a heuristic to decide if a name is a Go symbol, e.g. "pkg.(*T).M" or
+// "example.com/m/pkg.F[go.shape.int]": a name qualified by a package path which, apart
+// from the type arguments, has no C++ scope operator, template arguments or spaces.
+func looksLikeGo(name string) bool {
+	outer := goname.Normalize(name)
+	if strings.Contains(outer, "::") || strings.ContainsAny(outer, "<> ") {
+		return false
+	}
+	return goname.Parse(name).ImportPath != ""
+}
+
+// simplifyGo unescapes the package path of a Go symbol, e.g. "gopkg.in/yaml%2ev3.Marshal",
+// and simplifies its type arguments according to the mode.
+func (d *Demangler) simplifyGo(name string) string {
+	if strings.Contains(name, "%") {
+		if unescaped, err := url.PathUnescape(name); err == nil {
+			name = unescaped
+		}
+	}
+	switch d.mode {
+	case "", "simple":
+		return goname.Normalize(name)
+	case "templates":
+		return strings.ReplaceAll(name, "go.shape.", "")
+	}
+	return name
+}
+
 // removeMatching removes nested instances of start..end from name.
 func removeMatching(name string, start, end byte) string {
 	s := string(start) + string(end)
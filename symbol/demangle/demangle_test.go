@@ -97,3 +97,60 @@ func TestDemanglerSimpleRustDemangling(t *testing.T) {
 	demangled := demangler.Demangle(&function)
 	require.Equal(t, &expected_function, demangled)
 }
+
+func TestDemanglerGo(t *testing.T) {
+	tests := []struct {
+		systemName string
+		full       string
+		templates  string
+		simple     string
+	}{
+		{
+			systemName: "main.main",
+			full:       "main.main",
+			templates:  "main.main",
+			simple:     "main.main",
+		},
+		{
+			systemName: "net/http.(*conn).serve.func1",
+			full:       "net/http.(*conn).serve.func1",
+			templates:  "net/http.(*conn).serve.func1",
+			simple:     "net/http.(*conn).serve.func1",
+		},
+		{
+			systemName: "gopkg.in/yaml%2ev3.(*decoder).unmarshal",
+			full:       "gopkg.in/yaml.v3.(*decoder).unmarshal",
+			templates:  "gopkg.in/yaml.v3.(*decoder).unmarshal",
+			simple:     "gopkg.in/yaml.v3.(*decoder).unmarshal",
+		},
+		{
+			systemName: "slices.SortFunc[go.shape.[]uint8,go.shape.struct { Name string }]",
+			full:       "slices.SortFunc[go.shape.[]uint8,go.shape.struct { Name string }]",
+			templates:  "slices.SortFunc[[]uint8,struct { Name string }]",
+			simple:     "slices.SortFunc[...]",
+		},
+		{
+			systemName: "example.com/m/list.(*List[go.shape.int]).Push.func1",
+			full:       "example.com/m/list.(*List[go.shape.int]).Push.func1",
+			templates:  "example.com/m/list.(*List[int]).Push.func1",
+			simple:     "example.com/m/list.(*List[...]).Push.func1",
+		},
+	}
+	for _, tt := range tests {
+		for mode, want := range map[string]string{"full": tt.full, "templates": tt.templates, "simple": tt.simple} {
+			demangled := NewDemangler(mode, false).Demangle(&pb.Function{SystemName: tt.systemName})
+			require.Equal(t, want, demangled.Name, "%s in %s mode", tt.systemName, mode)
+			require.Equal(t, tt.systemName, demangled.SystemName)
+		}
+	}
+}
+
+func TestDemanglerNotGo(t *testing.T) {
+	// Demangled C++ names keep the C++ simplifications.
+	demangled := NewDemangler("templates", false).Demangle(&pb.Function{SystemName: "std::vector<int>::push_back(int const&)"})
+	require.Equal(t, "std::vector<int>::push_back", demangled.Name)
+
+	// Java names aren't Go ones.
+	demangled = NewDemangler("simple", false).Demangle(&pb.Function{SystemName: "java.lang.Object.<init>"})
+	require.Equal(t, "java.lang.Object.<init>", demangled.Name)
+}
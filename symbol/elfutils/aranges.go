@@ -0,0 +1,173 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfutils
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// CompileUnitRanges returns the sorted, merged list of address intervals [start, end)
+// covered by the compile units of the file.
+//
+// The intervals are read from the .debug_aranges section when present. The compile units
+// it doesn't list, e.g. compiled by clang which doesn't emit it by default, fall back to
+// the ranges of their compile unit entry, without visiting any of their children.
+func CompileUnitRanges(f *elf.File, debugData *dwarf.Data) ([][2]uint64, error) {
+	var (
+		ranges [][2]uint64
+		// listed are the sorted offsets in .debug_info of the units listed by .debug_aranges.
+		listed []uint64
+	)
+	if sec := f.Section(".debug_aranges"); sec != nil && sec.Type == elf.SHT_PROGBITS {
+		data, err := sec.Data()
+		if err == nil {
+			sets, err := parseAranges(data, f.ByteOrder)
+			if err == nil {
+				for _, s := range sets {
+					ranges = append(ranges, s.ranges...)
+					listed = append(listed, s.offset)
+				}
+				sort.Slice(listed, func(i, j int) bool { return listed[i] < listed[j] })
+			}
+		}
+	}
+
+	r := debugData.Reader()
+	var prev dwarf.Offset
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, fmt.Errorf("read DWARF entry: %w", err)
+		}
+		if e == nil {
+			break
+		}
+		// The header of the unit is between the previous unit entry and this one.
+		first := prev
+		prev = e.Offset
+		r.SkipChildren()
+		if e.Tag != dwarf.TagCompileUnit && e.Tag != dwarf.TagSkeletonUnit && e.Tag != dwarf.TagPartialUnit {
+			continue
+		}
+		i := sort.Search(len(listed), func(i int) bool { return listed[i] >= uint64(first) })
+		if i < len(listed) && listed[i] <= uint64(e.Offset) {
+			continue
+		}
+
+		cuRanges, err := debugData.Ranges(e)
+		if err != nil {
+			// Skip malformed compile units rather than failing for the whole file.
+			continue
+		}
+		ranges = append(ranges, cuRanges...)
+	}
+	return mergeRanges(ranges), nil
+}
+
+// arangeSet is a set of address ranges of .debug_aranges.
+type arangeSet struct {
+	// offset is the offset in .debug_info of the unit the ranges belong to.
+	offset uint64
+	ranges [][2]uint64
+}
+
+// parseAranges decodes the sets of address range tuples of a .debug_aranges section.
+func parseAranges(data []byte, byteOrder binary.ByteOrder) ([]arangeSet, error) {
+	var sets []arangeSet
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errors.New("truncated .debug_aranges header")
+		}
+		unitLength := uint64(byteOrder.Uint32(data))
+		hdrLen, offSize := uint64(4), uint64(4)
+		if unitLength == 0xffffffff {
+			if len(data) < 12 {
+				return nil, errors.New("truncated .debug_aranges header")
+			}
+			unitLength = byteOrder.Uint64(data[4:])
+			hdrLen, offSize = 12, 8
+		}
+		if unitLength > uint64(len(data))-hdrLen {
+			return nil, errors.New("truncated .debug_aranges set")
+		}
+		set := data[:hdrLen+unitLength]
+		data = data[hdrLen+unitLength:]
+
+		// version (2), debug_info_offset (offSize), address_size (1), segment_size (1).
+		pos := hdrLen + 2 + offSize
+		if uint64(len(set)) < pos+2 {
+			return nil, errors.New("truncated .debug_aranges header")
+		}
+		as := arangeSet{offset: readAddr(set[hdrLen+2:], offSize, byteOrder)}
+		addrSize, segSize := uint64(set[pos]), uint64(set[pos+1])
+		pos += 2
+		if addrSize != 4 && addrSize != 8 {
+			return nil, fmt.Errorf("unsupported .debug_aranges address size %d", addrSize)
+		}
+
+		// The tuples are aligned to twice the size of an address.
+		tupleSize := segSize + 2*addrSize
+		if rem := pos % (2 * addrSize); rem != 0 {
+			pos += 2*addrSize - rem
+		}
+		for ; pos+tupleSize <= uint64(len(set)); pos += tupleSize {
+			start := readAddr(set[pos+segSize:], addrSize, byteOrder)
+			length := readAddr(set[pos+segSize+addrSize:], addrSize, byteOrder)
+			if start == 0 && length == 0 {
+				break
+			}
+			if length == 0 {
+				continue
+			}
+			as.ranges = append(as.ranges, [2]uint64{start, start + length})
+		}
+		sets = append(sets, as)
+	}
+	return sets, nil
+}
+
+func readAddr(b []byte, size uint64, byteOrder binary.ByteOrder) uint64 {
+	if size == 4 {
+		return uint64(byteOrder.Uint32(b))
+	}
+	return byteOrder.Uint64(b)
+}
+
+// mergeRanges sorts ranges and merges the overlapping and adjacent ones.
+func mergeRanges(ranges [][2]uint64) [][2]uint64 {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i][0] < ranges[j][0]
+	})
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			if r[1] > last[1] {
+				last[1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
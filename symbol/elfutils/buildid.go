@@ -0,0 +1,102 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfutils
+
+import (
+	"debug/elf"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	noteGNUBuildID = 3
+	noteGoBuildID  = 4
+)
+
+// BuildID returns the build ID of f, hex encoded. The GNU build ID is preferred,
+// binaries built by the Go linker without one have a Go build ID.
+func BuildID(f *elf.File) (string, error) {
+	var gnu, goID []byte
+	forEachNote(f, func(name string, typ uint32, desc []byte) bool {
+		switch {
+		case name == "GNU" && typ == noteGNUBuildID:
+			gnu = desc
+		case name == "Go" && typ == noteGoBuildID:
+			goID = desc
+		}
+		return gnu == nil
+	})
+	switch {
+	case gnu != nil:
+		return hex.EncodeToString(gnu), nil
+	case goID != nil:
+		return hex.EncodeToString(goID), nil
+	}
+	return "", errors.New("no build ID note found")
+}
+
+// GNUBuildID returns the GNU build ID of f, false if it has none.
+func GNUBuildID(f *elf.File) ([]byte, bool) {
+	var id []byte
+	forEachNote(f, func(name string, typ uint32, desc []byte) bool {
+		if name == "GNU" && typ == noteGNUBuildID {
+			id = desc
+		}
+		return id == nil
+	})
+	return id, id != nil
+}
+
+// forEachNote calls fn for the notes of the PT_NOTE segments, or of the SHT_NOTE sections
+// if there are no program headers, until it returns false.
+func forEachNote(f *elf.File, fn func(name string, typ uint32, desc []byte) bool) {
+	var notes []io.ReaderAt
+	var sizes []uint64
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_NOTE {
+			notes, sizes = append(notes, prog.ReaderAt), append(sizes, prog.Filesz)
+		}
+	}
+	if len(notes) == 0 {
+		for _, sec := range f.Sections {
+			if sec.Type == elf.SHT_NOTE {
+				notes, sizes = append(notes, sec.ReaderAt), append(sizes, sec.Size)
+			}
+		}
+	}
+
+	for i, r := range notes {
+		data, err := io.ReadAll(io.NewSectionReader(r, 0, int64(sizes[i])))
+		if err != nil {
+			continue
+		}
+		// Note header: namesz, descsz, type, followed by the name and the descriptor, each 4-byte aligned.
+		for len(data) >= 12 {
+			namesz, descsz, typ := f.ByteOrder.Uint32(data), f.ByteOrder.Uint32(data[4:]), f.ByteOrder.Uint32(data[8:])
+			descOff := 12 + uint64(align4(namesz))
+			next := descOff + uint64(align4(descsz))
+			if next > uint64(len(data)) {
+				break
+			}
+			// The Go linker pads the name with NULs: "Go\x00\x00".
+			name := strings.TrimRight(string(data[12:12+namesz]), "\x00")
+			if !fn(name, typ, data[descOff:descOff+uint64(descsz)]) {
+				return
+			}
+			data = data[next:]
+		}
+	}
+}
@@ -38,21 +38,40 @@ type DebugInfoFile interface {
 type debugInfoFile struct {
 	demangler *demangle.Demangler
 
-	debugData           *dwarf.Data
-	lineEntries         map[dwarf.Offset][]dwarf.LineEntry
-	subprograms         map[dwarf.Offset][]*godwarf.Tree
-	abstractSubprograms map[dwarf.Offset]*dwarf.Entry
+	debugData *dwarf.Data
+	split     *SplitDWARF
+
+	// built holds the result of building the lookup tables of each compile unit.
+	built       map[dwarf.Offset]error
+	lineEntries map[dwarf.Offset][]dwarf.LineEntry
+	subprograms map[dwarf.Offset][]*godwarf.Tree
+	// unitData is the DWARF data holding the subprograms of each compile unit,
+	// the split unit of a skeleton.
+	unitData map[dwarf.Offset]*dwarf.Data
+	// abstractSubprograms holds the abstract instances and declarations of subprograms
+	// per compile unit, since split units of different compile units share offsets.
+	// The ones of other compile units are added when they are referenced.
+	abstractSubprograms map[dwarf.Offset]map[dwarf.Offset]*dwarf.Entry
 }
 
 // NewDebugInfoFile creates a new DebugInfoFile symbolizer.
 func NewDebugInfoFile(debugData *dwarf.Data, demangler *demangle.Demangler) (DebugInfoFile, error) {
+	return NewSplitDebugInfoFile(debugData, nil, demangler)
+}
+
+// NewSplitDebugInfoFile creates a new DebugInfoFile symbolizer that resolves
+// the skeleton compile units of debugData to their split units using split.
+func NewSplitDebugInfoFile(debugData *dwarf.Data, split *SplitDWARF, demangler *demangle.Demangler) (DebugInfoFile, error) {
 	return &debugInfoFile{
 		demangler: demangler,
 
 		debugData:           debugData,
+		split:               split,
+		built:               make(map[dwarf.Offset]error),
 		lineEntries:         make(map[dwarf.Offset][]dwarf.LineEntry),
 		subprograms:         make(map[dwarf.Offset][]*godwarf.Tree),
-		abstractSubprograms: make(map[dwarf.Offset]*dwarf.Entry),
+		unitData:            make(map[dwarf.Offset]*dwarf.Data),
+		abstractSubprograms: make(map[dwarf.Offset]map[dwarf.Offset]*dwarf.Entry),
 	}, nil
 }
 
@@ -91,10 +110,7 @@ func (f *debugInfoFile) SourceLines(addr uint64) ([]profile.LocationLine, error)
 		return lines, nil
 	}
 
-	name, ok := tr.Entry.Val(dwarf.AttrName).(string)
-	if !ok {
-		name = ""
-	}
+	name := f.subprogramName(cu.Offset, tr.Entry)
 	file, line := findLineInfo(f.lineEntries[cu.Offset], tr.Ranges)
 	lines = append(lines, profile.LocationLine{
 		Line: line,
@@ -108,10 +124,9 @@ func (f *debugInfoFile) SourceLines(addr uint64) ([]profile.LocationLine, error)
 	for _, ch := range reader.InlineStack(tr, addr) {
 		var name string
 		if ch.Tag == dwarf.TagSubprogram {
-			name = tr.Entry.Val(dwarf.AttrName).(string)
+			name = f.subprogramName(cu.Offset, tr.Entry)
 		} else {
-			abstractOrigin := f.abstractSubprograms[ch.Entry.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)]
-			name = getFunctionName(abstractOrigin)
+			name = f.subprogramName(cu.Offset, ch.Entry)
 		}
 
 		file, line := findLineInfo(f.lineEntries[cu.Offset], ch.Ranges)
@@ -128,11 +143,16 @@ func (f *debugInfoFile) SourceLines(addr uint64) ([]profile.LocationLine, error)
 }
 
 func (f *debugInfoFile) ensureLookUpTablesBuilt(cu *dwarf.Entry) error {
-	if _, ok := f.lineEntries[cu.Offset]; ok {
-		// Already created.
-		return nil
+	if err, ok := f.built[cu.Offset]; ok {
+		// Already created, or failed to be.
+		return err
 	}
+	err := f.buildLookUpTables(cu)
+	f.built[cu.Offset] = err
+	return err
+}
 
+func (f *debugInfoFile) buildLookUpTables(cu *dwarf.Entry) error {
 	// The reader is positioned at byte offset 0 in the DWARF “line” section.
 	lr, err := f.debugData.LineReader(cu)
 	if err != nil {
@@ -153,9 +173,20 @@ func (f *debugInfoFile) ensureLookUpTablesBuilt(cu *dwarf.Entry) error {
 		}
 	}
 
-	er := f.debugData.Reader()
+	// The subprograms of a skeleton compile unit live in its split unit.
+	data, unitOffset := f.debugData, cu.Offset
+	if f.split != nil && IsSkeleton(cu) {
+		splitData, err := f.split.Unit(cu)
+		if err != nil {
+			return fmt.Errorf("failed to load split unit: %w", err)
+		}
+		data, unitOffset = splitData, 0
+	}
+	f.unitData[cu.Offset] = data
+
+	er := data.Reader()
 	// The reader is positioned at byte offset of compile unit in the DWARF “info” section.
-	er.Seek(cu.Offset)
+	er.Seek(unitOffset)
 	entry, err := er.Next()
 	if err != nil || entry == nil {
 		return errors.New("failed to read entry for compile unit")
@@ -165,6 +196,9 @@ func (f *debugInfoFile) ensureLookUpTablesBuilt(cu *dwarf.Entry) error {
 		return errors.New("failed to find entry for compile unit")
 	}
 
+	abstractSubprograms := make(map[dwarf.Offset]*dwarf.Entry)
+	f.abstractSubprograms[cu.Offset] = abstractSubprograms
+
 outer:
 	for {
 		entry, err := er.Next()
@@ -184,14 +218,14 @@ outer:
 
 		if entry.Tag == dwarf.TagSubprogram {
 			for _, field := range entry.Field {
-				if field.Attr == dwarf.AttrInline {
-					f.abstractSubprograms[entry.Offset] = entry
+				if field.Attr == dwarf.AttrInline || field.Attr == dwarf.AttrDeclaration {
+					abstractSubprograms[entry.Offset] = entry
 					continue outer
 				}
 			}
 
 			// Extract the tree of debug_info entries rooted at given offset.
-			tr, err := godwarf.LoadTree(entry.Offset, f.debugData, 0)
+			tr, err := godwarf.LoadTree(entry.Offset, data, 0)
 			if err != nil {
 				return fmt.Errorf("failed to extract dwarf tree: %w", err)
 			}
@@ -228,6 +262,21 @@ func findLineInfo(entries []dwarf.LineEntry, rg [][2]uint64) (string, int64) {
 	return file, line
 }
 
+// subprogramName returns the name of a subprogram or inlined subroutine entry,
+// following DW_AT_abstract_origin and DW_AT_specification for entries
+// that don't carry a name, e.g. out-of-line definitions of C++ member functions.
+func (f *debugInfoFile) subprogramName(cuOffset dwarf.Offset, entry godwarf.Entry) string {
+	if name, ok := entry.Val(dwarf.AttrName).(string); ok {
+		return name
+	}
+	for _, attr := range []dwarf.Attr{dwarf.AttrAbstractOrigin, dwarf.AttrSpecification} {
+		if off, ok := entry.Val(attr).(dwarf.Offset); ok {
+			return getFunctionName(f.abstractSubprogram(cuOffset, off))
+		}
+	}
+	return "?"
+}
+
 func getFunctionName(entry *dwarf.Entry) string {
 	if entry != nil {
 		for _, field := range entry.Field {
@@ -246,3 +295,22 @@ func getFunctionName(entry *dwarf.Entry) string {
 
 	return "?"
 }
+
+// abstractSubprogram returns the entry at offset off referenced from the compile unit at
+// cuOffset. An entry of another compile unit, e.g. after link time optimization, is read
+// and kept with the abstract subprograms of the unit.
+func (f *debugInfoFile) abstractSubprogram(cuOffset, off dwarf.Offset) *dwarf.Entry {
+	abstract := f.abstractSubprograms[cuOffset]
+	if e, ok := abstract[off]; ok || abstract == nil {
+		return e
+	}
+
+	r := f.unitData[cuOffset].Reader()
+	r.Seek(off)
+	e, err := r.Next()
+	if err != nil || e == nil || e.Offset != off {
+		e = nil
+	}
+	abstract[off] = e
+	return e
+}
@@ -0,0 +1,146 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfutils
+
+import (
+	"debug/dwarf"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// opAddr is the DW_OP_addr location expression operation.
+const opAddr = 0x03
+
+// Variable is a global or static variable with a fixed address
+// described by a DW_TAG_variable entry.
+type Variable struct {
+	Name        string
+	LinkageName string
+	Address     uint64
+	Size        uint64
+	Type        dwarf.Type
+}
+
+// GlobalVariables returns every variable of debugData that lives at a static
+// address (i.e. its DW_AT_location is a single DW_OP_addr operation).
+// The variables are sorted by their addresses in ascending order.
+func GlobalVariables(debugData *dwarf.Data, byteOrder binary.ByteOrder) ([]Variable, error) {
+	var vars []Variable
+
+	r := debugData.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, fmt.Errorf("read DWARF entry: %w", err)
+		}
+		if e == nil {
+			break
+		}
+		if e.Tag != dwarf.TagVariable {
+			continue
+		}
+
+		loc, ok := e.Val(dwarf.AttrLocation).([]byte)
+		if !ok {
+			continue
+		}
+		addr, ok := staticAddress(loc, byteOrder)
+		if !ok {
+			continue
+		}
+
+		v := Variable{Address: addr}
+		v.Name, _ = e.Val(dwarf.AttrName).(string)
+		v.LinkageName, _ = e.Val(dwarf.AttrLinkageName).(string)
+		if typOff, ok := e.Val(dwarf.AttrType).(dwarf.Offset); ok {
+			if typ, err := debugData.Type(typOff); err == nil {
+				v.Type = typ
+				if sz := typ.Size(); sz > 0 {
+					v.Size = uint64(sz)
+				}
+			}
+		}
+		if v.Name == "" && v.LinkageName == "" {
+			continue
+		}
+		vars = append(vars, v)
+	}
+
+	sort.SliceStable(vars, func(i, j int) bool {
+		return vars[i].Address < vars[j].Address
+	})
+	return vars, nil
+}
+
+// staticAddress decodes a location expression consisting of a single DW_OP_addr.
+func staticAddress(loc []byte, byteOrder binary.ByteOrder) (uint64, bool) {
+	if len(loc) == 0 || loc[0] != opAddr {
+		return 0, false
+	}
+	switch len(loc) - 1 {
+	case 4:
+		return uint64(byteOrder.Uint32(loc[1:])), true
+	case 8:
+		return byteOrder.Uint64(loc[1:]), true
+	default:
+		return 0, false
+	}
+}
+
+// FieldPath returns the path of the innermost member of typ
+// that contains the byte at offset off, e.g. "hdr.flags" or "items[3].id".
+// It returns an empty string if typ is not an aggregate or off is out of bounds.
+func FieldPath(typ dwarf.Type, off int64) string {
+	var path strings.Builder
+	for typ != nil && off >= 0 {
+		switch t := typ.(type) {
+		case *dwarf.TypedefType:
+			typ = t.Type
+			continue
+		case *dwarf.QualType:
+			typ = t.Type
+			continue
+		case *dwarf.StructType:
+			var field *dwarf.StructField
+			for _, f := range t.Field {
+				sz := f.Type.Size()
+				if off >= f.ByteOffset && (sz <= 0 || off < f.ByteOffset+sz) {
+					field = f
+				}
+			}
+			if field == nil {
+				return path.String()
+			}
+			if path.Len() > 0 {
+				path.WriteByte('.')
+			}
+			path.WriteString(field.Name)
+			off -= field.ByteOffset
+			typ = field.Type
+		case *dwarf.ArrayType:
+			elemSize := t.Type.Size()
+			if elemSize <= 0 {
+				return path.String()
+			}
+			fmt.Fprintf(&path, "[%d]", off/elemSize)
+			off %= elemSize
+			typ = t.Type
+		default:
+			return path.String()
+		}
+	}
+	return path.String()
+}
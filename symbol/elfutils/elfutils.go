@@ -75,7 +75,21 @@ func HasGoPclntab(f *elf.File) bool {
 		return true
 	}
 
-	return false
+	// Stripped or externally linked binaries may keep the table elsewhere, but it takes
+	// a scan of the loadable segments to find it: only look for it in Go binaries.
+	if !hasGoMarker(f) {
+		return false
+	}
+	_, err := FindGoPclntab(f)
+	return err == nil
+}
+
+// hasGoMarker reports whether f has a section, a note or a symbol written by the Go linker.
+func hasGoMarker(f *elf.File) bool {
+	if f.Section(".go.buildinfo") != nil || hasGoBuildIDNote(f) {
+		return true
+	}
+	return len(lookupSymbols(f, nil, "runtime.pclntab", "runtime.firstmoduledata")) > 0
 }
 
 // HasSymtab reports whether the specified executable or library file contains symbols via .symtab.
@@ -98,6 +112,83 @@ func HasDynsym(f *elf.File) bool {
 	return false
 }
 
+// lookupSymbols returns the values of the symbols of .symtab named by names. With mapped,
+// the contents of the whole file, the table is read in place instead of being decoded to
+// the heap by f.Symbols.
+func lookupSymbols(f *elf.File, mapped []byte, names ...string) map[string]uint64 {
+	res := make(map[string]uint64, len(names))
+	want := func(name []byte) (string, bool) {
+		for _, n := range names {
+			if string(name) == n {
+				_, found := res[n]
+				return n, !found
+			}
+		}
+		return "", false
+	}
+
+	symtab := f.SectionByType(elf.SHT_SYMTAB)
+	if mapped == nil || symtab == nil || int(symtab.Link) >= len(f.Sections) {
+		syms, _ := f.Symbols()
+		for _, s := range syms {
+			if n, ok := want([]byte(s.Name)); ok {
+				res[n] = s.Value
+			}
+		}
+		return res
+	}
+
+	strtab := f.Sections[symtab.Link]
+	syms, err := sectionData(symtab, mapped)
+	if err != nil {
+		return res
+	}
+	strs, err := sectionData(strtab, mapped)
+	if err != nil {
+		return res
+	}
+	entSize := 24
+	if f.Class == elf.ELFCLASS32 {
+		entSize = 16
+	}
+	for off := entSize; off+entSize <= len(syms) && len(res) < len(names); off += entSize {
+		// st_name is first, st_value follows it in ELF32 and st_info, st_other, st_shndx in ELF64.
+		sym := syms[off : off+entSize]
+		i := int(f.ByteOrder.Uint32(sym))
+		if i <= 0 || i >= len(strs) {
+			continue
+		}
+		name := strs[i:]
+		if end := bytes.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+		n, ok := want(name)
+		if !ok {
+			continue
+		}
+		if f.Class == elf.ELFCLASS32 {
+			res[n] = uint64(f.ByteOrder.Uint32(sym[4:]))
+		} else {
+			res[n] = f.ByteOrder.Uint64(sym[8:])
+		}
+	}
+	return res
+}
+
+// FileOffset returns the offset in the file of the code at the virtual address addr,
+// e.g. to attach uprobes, using the loadable segment that contains addr.
+func FileOffset(f *elf.File, addr uint64) (uint64, error) {
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_LOAD || p.Flags&elf.PF_X == 0 {
+			continue
+		}
+		if p.Vaddr <= addr && addr < p.Vaddr+p.Filesz {
+			return addr - p.Vaddr + p.Off, nil
+		}
+	}
+	return 0, fmt.Errorf("address %#x isn't in an executable segment", addr)
+}
+
 // ValidateFile returns an error if the given object file is not valid.
 func ValidateFile(path string) error {
 	elfFile, err := elf.Open(path)
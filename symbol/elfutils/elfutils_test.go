@@ -14,7 +14,12 @@
 package elfutils
 
 import (
+	"bytes"
 	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -26,3 +31,334 @@ func TestIsSymbolizableGoObjFile(t *testing.T) {
 
 	require.True(t, HasGoPclntab(f))
 }
+
+func TestFindGoPclntab(t *testing.T) {
+	orig, err := os.ReadFile("testdata/main")
+	require.NoError(t, err)
+
+	f, err := elf.Open("testdata/main")
+	require.NoError(t, err)
+	defer f.Close()
+
+	want, err := FindGoPclntab(f)
+	require.NoError(t, err)
+	require.Equal(t, ".gopclntab section", want.Source)
+	require.Equal(t, f.Section(".gopclntab").Addr, want.Addr)
+	require.Equal(t, f.Section(".text").Addr, want.TextStart)
+
+	// Hide the section by renaming it in the section header string table.
+	renamed := bytes.Clone(orig)
+	i := bytes.LastIndex(renamed, []byte(".gopclntab\x00"))
+	require.Positive(t, i)
+	copy(renamed[i:], ".xopclntab")
+
+	// Remove the section headers altogether.
+	noSections := bytes.Clone(orig)
+	binary.LittleEndian.PutUint64(noSections[0x28:], 0) // e_shoff
+	binary.LittleEndian.PutUint16(noSections[0x3c:], 0) // e_shnum
+	binary.LittleEndian.PutUint16(noSections[0x3e:], 0) // e_shstrndx
+
+	for name, data := range map[string][]byte{"renamed section": renamed, "no section headers": noSections} {
+		t.Run(name, func(t *testing.T) {
+			f, err := elf.NewFile(bytes.NewReader(data))
+			require.NoError(t, err)
+			require.Nil(t, f.Section(".gopclntab"))
+			require.True(t, HasGoPclntab(f))
+
+			got, err := FindGoPclntab(f)
+			require.NoError(t, err)
+			require.Equal(t, "segment scan", got.Source)
+			require.Equal(t, want.Addr, got.Addr)
+			require.Equal(t, want.Magic, got.Magic)
+			require.Equal(t, want.TextStart, got.TextStart)
+		})
+	}
+}
+
+func TestDetectGoVersion(t *testing.T) {
+	f, err := elf.Open("testdata/main")
+	require.NoError(t, err)
+	defer f.Close()
+
+	v, err := DetectGoVersion(f)
+	require.NoError(t, err)
+	require.Equal(t, GoVersion{Major: 1, Minor: 19, Patch: 1, Raw: "go1.19.1", Source: "buildinfo", Confidence: ConfidenceHigh}, v)
+	require.True(t, v.AtLeast(1, 18))
+	require.False(t, v.AtLeast(1, 20))
+
+	// The binary is stripped, scanning the read-only data gives the same answer.
+	s, ok := scanGoVersionString(f, nil)
+	require.True(t, ok)
+	require.Equal(t, "go1.19.1", s)
+}
+
+func TestMapGoVersion(t *testing.T) {
+	for _, filename := range []string{"testdata/main", "../addr2line/testdata/cgo-go", "../addr2line/testdata/cgo-go-stripped"} {
+		t.Run(filename, func(t *testing.T) {
+			f, err := elf.Open(filename)
+			require.NoError(t, err)
+			defer f.Close()
+			mapping, err := MmapFile(filename)
+			require.NoError(t, err)
+			defer mapping.Close()
+
+			want, err := DetectGoVersion(f)
+			require.NoError(t, err)
+			got, err := MapGoVersion(f, mapping.Data)
+			require.NoError(t, err)
+			require.Equal(t, want, got)
+
+			wantTab, err := FindGoPclntab(f)
+			require.NoError(t, err)
+			gotTab, err := MapGoPclntab(f, mapping.Data)
+			require.NoError(t, err)
+			require.Equal(t, wantTab.Addr, gotTab.Addr)
+			require.Equal(t, wantTab.TextStart, gotTab.TextStart)
+		})
+	}
+}
+
+func TestLookupSymbols(t *testing.T) {
+	filename := "../addr2line/testdata/cgo-go"
+	f, err := elf.Open(filename)
+	require.NoError(t, err)
+	defer f.Close()
+	mapping, err := MmapFile(filename)
+	require.NoError(t, err)
+	defer mapping.Close()
+
+	want := map[string]uint64{"runtime.text": 0x402400, "main.main": 0x481400}
+	require.Equal(t, want, lookupSymbols(f, nil, "runtime.text", "main.main", "main.missing"))
+	require.Equal(t, want, lookupSymbols(f, mapping.Data, "runtime.text", "main.main", "main.missing"))
+}
+
+func TestParseGoVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    GoVersion
+		wantErr bool
+	}{
+		{in: "go1.21.3", want: GoVersion{Major: 1, Minor: 21, Patch: 3, Raw: "go1.21.3"}},
+		{in: "go1.22rc1", want: GoVersion{Major: 1, Minor: 22, Raw: "go1.22rc1"}},
+		{in: "devel +abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseGoVersion(tt.in)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewGoFuncTable(t *testing.T) {
+	f, err := elf.Open("testdata/main")
+	require.NoError(t, err)
+	defer f.Close()
+
+	version, err := DetectGoVersion(f)
+	require.NoError(t, err)
+	tab, err := FindGoPclntab(f)
+	require.NoError(t, err)
+
+	funcs, err := NewGoFuncTable(tab, version, f.ByteOrder)
+	require.NoError(t, err)
+	require.Len(t, funcs.Funcs, 1415)
+
+	tests := []struct {
+		pc   uint64
+		want GoFunc
+	}{
+		{pc: 0x480ef0, want: GoFunc{Name: "main.main", Entry: 0x480ee0, End: 0x480f47, FuncID: GoFuncIDNormal}},
+		{pc: 0x433dc0, want: GoFunc{Name: "runtime.main", Entry: 0x433dc0, End: 0x434100, DeferReturn: 800, FuncID: "runtime_main", RawFuncID: 17}},
+		{pc: 0x45ad10, want: GoFunc{Name: "runtime.systemstack", Entry: 0x45ad00, End: 0x45ada0, Args: 8, FuncID: GoFuncIDSystemstack, RawFuncID: 19, Flag: GoFuncFlagSPWrite | GoFuncFlagASM}},
+		{pc: 0x45cdc0, want: GoFunc{Name: "runtime.goexit", Entry: 0x45cdc0, End: 0x45cde0, FuncID: GoFuncIDGoexit, RawFuncID: 7, Flag: GoFuncFlagTopFrame | GoFuncFlagASM}},
+		{pc: 0x45efc0, want: GoFunc{Name: "runtime.(*errorString).Error", Entry: 0x45efc0, End: 0x45f040, Args: 8, FuncID: GoFuncIDWrapper, RawFuncID: 21}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want.Name, func(t *testing.T) {
+			got, ok := funcs.Lookup(tt.pc)
+			require.True(t, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+
+	_, ok := funcs.Lookup(0x1000)
+	require.False(t, ok)
+	require.Equal(t, "TOPFRAME|ASM", (GoFuncFlagTopFrame | GoFuncFlagASM).String())
+}
+
+func TestCalibrateGoFuncIDs(t *testing.T) {
+	fn := func(name string, raw uint8) GoFunc {
+		return GoFunc{Name: name, RawFuncID: raw}
+	}
+	shifted := make([]GoFuncID, len(goFuncIDs118)+1)
+	shifted[0], shifted[8], shifted[20], shifted[22] = GoFuncIDNormal, GoFuncIDGoexit, GoFuncIDSystemstack, GoFuncIDWrapper
+
+	tests := []struct {
+		name  string
+		funcs []GoFunc
+		want  []GoFuncID
+	}{
+		{
+			name:  "no anchor",
+			funcs: []GoFunc{fn("main.f", 3)},
+			want:  goFuncIDs118,
+		},
+		{
+			// The highest funcID isn't the wrapper one.
+			name:  "no wrappers",
+			funcs: []GoFunc{fn("runtime.goexit", 7), fn("runtime.systemstack", 19)},
+			want:  goFuncIDs118,
+		},
+		{
+			name:  "abi wrapper",
+			funcs: []GoFunc{fn("runtime.systemstack", 19), fn("runtime.systemstack", 21), fn("runtime.(*errorString).Error", 21)},
+			want:  goFuncIDs118,
+		},
+		{
+			// Built by Go 1.23 while detected as Go 1.18, only the anchors and the wrapper
+			// following them are known.
+			name:  "shifted",
+			funcs: []GoFunc{fn("runtime.goexit", 8), fn("runtime.systemstack", 20), fn("runtime.(*errorString).Error", 22)},
+			want:  shifted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, calibrateGoFuncIDs(goFuncIDs118, tt.funcs))
+		})
+	}
+	require.True(t, GoFunc{FuncID: goFuncID(shifted, 22)}.IsWrapper())
+	require.Equal(t, GoFuncID("unknown(21)"), goFuncID(shifted, 21))
+}
+
+func TestGoRuntimeTypes(t *testing.T) {
+	f, err := elf.Open("testdata/main")
+	require.NoError(t, err)
+	defer f.Close()
+
+	version, err := DetectGoVersion(f)
+	require.NoError(t, err)
+
+	// The binary is stripped, the moduledata is found through the pclntab.
+	types, err := GoRuntimeTypes(f, version)
+	require.NoError(t, err)
+
+	typ, ok := types.Lookup("os.file")
+	require.True(t, ok)
+	require.Equal(t, GoType{
+		Addr:    0x491840,
+		Name:    "os.file",
+		PkgPath: "os",
+		Kind:    reflect.Struct,
+		Size:    88,
+		Align:   8,
+		Named:   true,
+		Fields: []GoStructField{
+			{Name: "pfd", Type: "poll.FD", Offset: 0},
+			{Name: "name", Type: "string", Offset: 56},
+			{Name: "dirinfo", Type: "*os.dirInfo", Offset: 72},
+			{Name: "nonblock", Type: "bool", Offset: 80},
+			{Name: "stdoutOrErr", Type: "bool", Offset: 81},
+			{Name: "appendMode", Type: "bool", Offset: 82},
+		},
+	}, typ)
+
+	typ, ok = types.Lookup("reflect.Value")
+	require.True(t, ok)
+	require.Equal(t, GoStructField{Name: "flag", Type: "reflect.flag", Offset: 16, Embedded: true}, typ.Fields[2])
+
+	typ, ok = types.Lookup("*os.File")
+	require.True(t, ok)
+	require.Equal(t, reflect.Pointer, typ.Kind)
+	require.Equal(t, "os.File", typ.Elem)
+
+	typ, ok = types.Lookup("io.Writer")
+	require.True(t, ok)
+	require.Equal(t, []string{"Write"}, typ.Methods)
+
+	require.Equal(t, []string{"*os.File"}, types.Implementations("io.Writer"))
+}
+
+func TestBuildID(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{file: "../addr2line/testdata/data-c-with-debuginfo", want: "49b1b3a96f1d06c67b2c5db057926c7e1a3d7d40"},
+		// Go linker, no GNU build ID.
+		{file: "testdata/main", want: hex.EncodeToString([]byte("WvB-4ymHBLioFw_hxff8/ijNks_U0dm8Ccd3frRpj/m53izyvj8IgeHsnLkp2d/Rk4tel9fac0FrpKarIXm"))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			f, err := elf.Open(tt.file)
+			require.NoError(t, err)
+			defer f.Close()
+
+			id, err := BuildID(f)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, id)
+		})
+	}
+}
+
+func TestFileOffset(t *testing.T) {
+	f, err := elf.Open("testdata/main")
+	require.NoError(t, err)
+	defer f.Close()
+
+	// main.main, the text segment is mapped at 0x400000 from the start of the file.
+	off, err := FileOffset(f, 0x480ee0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0x80ee0), off)
+
+	_, err = FileOffset(f, 0x10)
+	require.Error(t, err)
+}
+
+func TestReturnOffsets(t *testing.T) {
+	// mov $0xc3, %eax; nop; ret; int3 padding
+	x86 := []byte{0xb8, 0xc3, 0x00, 0x00, 0x00, 0x90, 0xc3, 0xcc, 0xcc}
+	require.Equal(t, []uint64{6}, x86ReturnOffsets(x86))
+
+	// nop; ret; ret x1; udf #0
+	arm64 := []byte{
+		0x1f, 0x20, 0x03, 0xd5,
+		0xc0, 0x03, 0x5f, 0xd6,
+		0x20, 0x00, 0x5f, 0xd6,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	require.Equal(t, []uint64{4, 8}, arm64ReturnOffsets(arm64))
+}
+
+func TestDecodeLocation(t *testing.T) {
+	tests := []struct {
+		name string
+		expr []byte
+		want []LocationPiece
+	}{
+		{name: "reg", expr: []byte{0x50}, want: []LocationPiece{{Kind: LocationRegister, Register: 0}}},
+		{name: "regx", expr: []byte{0x90, 0x11}, want: []LocationPiece{{Kind: LocationRegister, Register: 17}}},
+		{name: "breg", expr: []byte{0x77, 0x78}, want: []LocationPiece{{Kind: LocationRegisterOffset, Register: 7, Offset: -8}}},
+		{name: "fbreg", expr: []byte{0x91, 0x6c}, want: []LocationPiece{{Kind: LocationFrameBase, Offset: -20}}},
+		{name: "cfa plus", expr: []byte{0x9c, 0x11, 0x10, 0x22}, want: []LocationPiece{{Kind: LocationCFA, Offset: 16}}},
+		{name: "cfa plus_uconst", expr: []byte{0x9c, 0x23, 0x08}, want: []LocationPiece{{Kind: LocationCFA, Offset: 8}}},
+		{name: "addr", expr: []byte{0x03, 0x10, 0x20, 0x40, 0, 0, 0, 0, 0}, want: []LocationPiece{{Kind: LocationAddress, Offset: 0x402010}}},
+		{
+			name: "pieces",
+			expr: []byte{0x53, 0x93, 0x08, 0x93, 0x08},
+			want: []LocationPiece{{Kind: LocationRegister, Register: 3, Size: 8}, {Kind: LocationOptimizedOut, Size: 8}},
+		},
+		{name: "stack value", expr: []byte{0x30, 0x9f}, want: []LocationPiece{{Kind: LocationUnknown}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, decodeLocation(tt.expr, binary.LittleEndian, 8))
+		})
+	}
+}
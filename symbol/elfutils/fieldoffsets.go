@@ -0,0 +1,199 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfutils
+
+import (
+	"debug/dwarf"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FieldOffset is the location of a field in a type, e.g. of "goid" in "runtime.g".
+type FieldOffset struct {
+	// Offset is the offset in bytes of the field from the start of the type.
+	Offset int64 `json:"offset"`
+	// Size is the size in bytes of the field, or -1 if unknown.
+	Size int64 `json:"size"`
+	// Type is the name of the type of the field.
+	Type string `json:"type"`
+}
+
+// TypeIndex looks up the named types of DWARF data.
+type TypeIndex struct {
+	debugData *dwarf.Data
+	types     map[string]dwarf.Offset
+}
+
+// NewTypeIndex indexes the named types of debugData, the first definition of a name wins.
+func NewTypeIndex(debugData *dwarf.Data) (*TypeIndex, error) {
+	idx := &TypeIndex{debugData: debugData, types: make(map[string]dwarf.Offset)}
+
+	r := debugData.Reader()
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, fmt.Errorf("read DWARF entry: %w", err)
+		}
+		if e == nil {
+			break
+		}
+		switch e.Tag {
+		case dwarf.TagStructType, dwarf.TagClassType, dwarf.TagUnionType, dwarf.TagTypedef, dwarf.TagBaseType:
+		default:
+			continue
+		}
+		name, _ := e.Val(dwarf.AttrName).(string)
+		if decl, _ := e.Val(dwarf.AttrDeclaration).(bool); name == "" || decl {
+			continue
+		}
+		if _, ok := idx.types[name]; !ok {
+			idx.types[name] = e.Offset
+		}
+	}
+	return idx, nil
+}
+
+// Type returns the type named name.
+func (idx *TypeIndex) Type(name string) (dwarf.Type, error) {
+	off, ok := idx.types[name]
+	if !ok {
+		return nil, fmt.Errorf("type %s not found", name)
+	}
+	return idx.debugData.Type(off)
+}
+
+// FieldOffset resolves query, a type name followed by a path of fields separated by dots,
+// e.g. "runtime.g.goid", "runtime.g.sched.sp" or "task_struct.pid". Array elements are
+// selected with an index, e.g. "runtime.p.runq[1]". Since the names of Go types contain
+// dots, the longest prefix of query that names a type is used.
+// The path can't go through pointers, whose target isn't at a fixed offset.
+func (idx *TypeIndex) FieldOffset(query string) (FieldOffset, error) {
+	for i := len(query); i > 0; i = strings.LastIndexByte(query[:i], '.') {
+		if _, ok := idx.types[query[:i]]; !ok {
+			continue
+		}
+		typ, err := idx.Type(query[:i])
+		if err != nil {
+			return FieldOffset{}, err
+		}
+		var path []string
+		if i < len(query) {
+			path = strings.Split(query[i+1:], ".")
+		}
+		return fieldOffset(typ, path)
+	}
+	return FieldOffset{}, fmt.Errorf("no type found for %s", query)
+}
+
+// FieldOffsets resolves every query, see FieldOffset. The queries that can't be resolved
+// are missing from the returned table and reported together in the error.
+func (idx *TypeIndex) FieldOffsets(queries []string) (map[string]FieldOffset, error) {
+	table := make(map[string]FieldOffset, len(queries))
+	var errs []error
+	for _, q := range queries {
+		off, err := idx.FieldOffset(q)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", q, err))
+			continue
+		}
+		table[q] = off
+	}
+	return table, errors.Join(errs...)
+}
+
+func fieldOffset(typ dwarf.Type, path []string) (FieldOffset, error) {
+	var off int64
+	for _, elem := range path {
+		name, indexes, err := splitIndexes(elem)
+		if err != nil {
+			return FieldOffset{}, err
+		}
+		st, ok := underlying(typ).(*dwarf.StructType)
+		if !ok {
+			return FieldOffset{}, fmt.Errorf("%s isn't a struct, can't select %s", typeName(typ), name)
+		}
+		var field *dwarf.StructField
+		for _, f := range st.Field {
+			if f.Name == name {
+				field = f
+				break
+			}
+		}
+		if field == nil {
+			return FieldOffset{}, fmt.Errorf("%s has no field %s", typeName(typ), name)
+		}
+		off += field.ByteOffset
+		typ = field.Type
+
+		for _, i := range indexes {
+			at, ok := underlying(typ).(*dwarf.ArrayType)
+			if !ok {
+				return FieldOffset{}, fmt.Errorf("%s isn't an array, can't index %s", typeName(typ), elem)
+			}
+			if at.Count >= 0 && i >= at.Count {
+				return FieldOffset{}, fmt.Errorf("index %d out of range of %s", i, typeName(typ))
+			}
+			off += i * at.Type.Size()
+			typ = at.Type
+		}
+	}
+	return FieldOffset{Offset: off, Size: typ.Size(), Type: typeName(typ)}, nil
+}
+
+// splitIndexes splits a path element like "runq[1]" into the field name and the indexes.
+func splitIndexes(elem string) (string, []int64, error) {
+	name, rest, ok := strings.Cut(elem, "[")
+	if !ok {
+		return elem, nil, nil
+	}
+	var indexes []int64
+	for _, s := range strings.Split(rest, "[") {
+		s, ok := strings.CutSuffix(s, "]")
+		if !ok {
+			return "", nil, fmt.Errorf("invalid index in %s", elem)
+		}
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || i < 0 {
+			return "", nil, fmt.Errorf("invalid index in %s", elem)
+		}
+		indexes = append(indexes, i)
+	}
+	return name, indexes, nil
+}
+
+// underlying strips the typedefs and the qualifiers of typ.
+func underlying(typ dwarf.Type) dwarf.Type {
+	for {
+		switch t := typ.(type) {
+		case *dwarf.TypedefType:
+			typ = t.Type
+		case *dwarf.QualType:
+			typ = t.Type
+		default:
+			return typ
+		}
+	}
+}
+
+func typeName(typ dwarf.Type) string {
+	if st, ok := typ.(*dwarf.StructType); ok && st.StructName != "" {
+		return st.StructName
+	}
+	if name := typ.Common().Name; name != "" {
+		return name
+	}
+	return typ.String()
+}
@@ -0,0 +1,482 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfutils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// GoFuncID identifies the special runtime functions, see runtime.funcID (internal/abi.FuncID since Go 1.21).
+// The numeric values change between Go versions, hence the names.
+type GoFuncID string
+
+const (
+	GoFuncIDNormal      GoFuncID = "normal"
+	GoFuncIDWrapper     GoFuncID = "wrapper"
+	GoFuncIDSystemstack GoFuncID = "systemstack"
+	GoFuncIDGoexit      GoFuncID = "goexit"
+)
+
+// goFuncIDs are the runtime funcID enumerations, indexed by value.
+var (
+	goFuncIDs114 = []GoFuncID{
+		"normal", "runtime_main", "goexit", "jmpdefer", "mcall", "morestack", "mstart", "rt0_go",
+		"asmcgocall", "sigpanic", "runfinq", "gcBgMarkWorker", "systemstack_switch", "systemstack",
+		"cgocallback", "gogo", "externalthreadhandler", "debugCallV1", "gopanic", "panicwrap",
+		"handleAsyncEvent", "asyncPreempt", "wrapper",
+	}
+	goFuncIDs117 = []GoFuncID{
+		"normal", "abort", "asmcgocall", "asyncPreempt", "cgocallback", "debugCallV2", "gcBgMarkWorker",
+		"goexit", "gogo", "gopanic", "handleAsyncEvent", "jmpdefer", "mcall", "morestack", "mstart",
+		"panicwrap", "rt0_go", "runfinq", "runtime_main", "sigpanic", "systemstack", "systemstack_switch",
+		"wrapper",
+	}
+	goFuncIDs118 = []GoFuncID{
+		"normal", "abort", "asmcgocall", "asyncPreempt", "cgocallback", "debugCallV2", "gcBgMarkWorker",
+		"goexit", "gogo", "gopanic", "handleAsyncEvent", "mcall", "morestack", "mstart",
+		"panicwrap", "rt0_go", "runfinq", "runtime_main", "sigpanic", "systemstack", "systemstack_switch",
+		"wrapper",
+	}
+	goFuncIDs123 = []GoFuncID{
+		"normal", "abort", "asmcgocall", "asyncPreempt", "cgocallback", "corostart", "debugCallV2",
+		"gcBgMarkWorker", "goexit", "gogo", "gopanic", "handleAsyncEvent", "mcall", "morestack", "mstart",
+		"panicwrap", "rt0_go", "runfinq", "runtime_main", "sigpanic", "systemstack", "systemstack_switch",
+		"wrapper",
+	}
+)
+
+func goFuncIDsFor(v GoVersion) []GoFuncID {
+	switch {
+	case v.AtLeast(1, 23):
+		return goFuncIDs123
+	case v.AtLeast(1, 18):
+		return goFuncIDs118
+	case v.AtLeast(1, 17):
+		return goFuncIDs117
+	default:
+		return goFuncIDs114
+	}
+}
+
+// GoFuncFlag holds the bits of runtime.funcFlag.
+type GoFuncFlag uint8
+
+const (
+	// GoFuncFlagTopFrame marks a function that appears at the top of its stack, e.g. runtime.goexit.
+	GoFuncFlagTopFrame GoFuncFlag = 1 << iota
+	// GoFuncFlagSPWrite marks a function that writes an arbitrary value to SP.
+	GoFuncFlagSPWrite
+	// GoFuncFlagASM marks a function implemented in assembly.
+	GoFuncFlagASM
+)
+
+func (f GoFuncFlag) String() string {
+	var s []string
+	for _, b := range []struct {
+		flag GoFuncFlag
+		name string
+	}{{GoFuncFlagTopFrame, "TOPFRAME"}, {GoFuncFlagSPWrite, "SPWRITE"}, {GoFuncFlagASM, "ASM"}} {
+		if f&b.flag != 0 {
+			s = append(s, b.name)
+		}
+	}
+	return strings.Join(s, "|")
+}
+
+// GoFunc is the metadata of a function recorded in the _func records of the pclntab.
+type GoFunc struct {
+	Name       string
+	Entry, End uint64
+	// Args is the size of the arguments in bytes, or a negative value if unknown.
+	Args int32
+	// DeferReturn is the offset from Entry of the deferreturn call, 0 if the function doesn't defer.
+	DeferReturn uint32
+	FuncID      GoFuncID
+	// RawFuncID is the value of FuncID in the binary.
+	RawFuncID uint8
+	Flag      GoFuncFlag
+}
+
+// IsWrapper reports whether the function is autogenerated code, e.g. a method wrapper,
+// which the Go runtime elides from tracebacks.
+func (f GoFunc) IsWrapper() bool {
+	return f.FuncID == GoFuncIDWrapper
+}
+
+// GoFuncTable is the decoded function table of a pclntab, sorted by entry address.
+type GoFuncTable struct {
+	Funcs []GoFunc
+}
+
+// NewGoFuncTable decodes the _func records of tab.
+// The version selects the layout of the records and the meaning of funcID values;
+// records of binaries built by Go versions older than 1.14 aren't supported.
+func NewGoFuncTable(tab *GoPclntab, version GoVersion, order binary.ByteOrder) (*GoFuncTable, error) {
+	d, err := newFuncTableDecoder(tab, version, order)
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := make([]GoFunc, 0, d.nfunc)
+	for i := uint64(0); i < d.nfunc; i++ {
+		rec, err := d.decodeFunc(d.funcOff(i))
+		if err != nil {
+			return nil, fmt.Errorf("function %d: %w", i, err)
+		}
+		fn := rec.GoFunc
+		fn.Entry, fn.End = d.entry(i), d.entry(i+1)
+		funcs = append(funcs, fn)
+	}
+
+	ids := calibrateGoFuncIDs(goFuncIDsFor(d.version), funcs)
+	for i := range funcs {
+		funcs[i].FuncID = goFuncID(ids, funcs[i].RawFuncID)
+	}
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].Entry < funcs[j].Entry })
+	return &GoFuncTable{Funcs: funcs}, nil
+}
+
+// funcTableDecoder decodes the function table of a pclntab in place.
+type funcTableDecoder struct {
+	data    []byte
+	order   binary.ByteOrder
+	version GoVersion
+	ptrSize int
+	quantum uint64
+
+	nfunc     uint64
+	textStart uint64
+
+	// Offsets of the sub-tables in data.
+	nameBase, functab, funcBase, pctab, cutab, filetab uint64
+
+	ptrEntries   bool
+	hasCUOffset  bool
+	hasStartLine bool
+}
+
+// newFuncTableDecoder reads the pclntab header:
+//
+//	magic uint32 | pad uint16 | quantum, ptrSize uint8 | nfunc, nfiles uintptr | textStart uintptr (Go 1.18+) |
+//	funcnameOffset, cuOffset, filetabOffset, pctabOffset, pclnOffset uintptr (Go 1.16+)
+//
+// Before Go 1.16 the functab follows nfunc, and everything is relative to the start of the pclntab.
+func newFuncTableDecoder(tab *GoPclntab, version GoVersion, order binary.ByteOrder) (*funcTableDecoder, error) {
+	d := &funcTableDecoder{data: tab.Data, order: order, version: version, textStart: tab.TextStart}
+	if len(d.data) < 16 {
+		return nil, errors.New("truncated pclntab header")
+	}
+	d.quantum = uint64(d.data[6])
+	d.ptrSize = int(d.data[7])
+	if len(d.data) < 8+8*d.ptrSize {
+		return nil, errors.New("truncated pclntab header")
+	}
+	d.nfunc = d.word(8)
+
+	switch tab.Magic {
+	case GoPclntabMagic12:
+		if !version.AtLeast(1, 14) {
+			return nil, fmt.Errorf("unsupported _func layout for Go version %s", version.Raw)
+		}
+		d.functab = 8 + uint64(d.ptrSize)
+		d.ptrEntries = true
+	case GoPclntabMagic116:
+		d.nameBase = d.word(8 + 2*d.ptrSize)
+		d.cutab = d.word(8 + 3*d.ptrSize)
+		d.filetab = d.word(8 + 4*d.ptrSize)
+		d.pctab = d.word(8 + 5*d.ptrSize)
+		d.functab = d.word(8 + 6*d.ptrSize)
+		d.funcBase = d.functab
+		d.hasCUOffset = true
+		d.ptrEntries = true
+	case GoPclntabMagic118, GoPclntabMagic120:
+		d.nameBase = d.word(8 + 3*d.ptrSize)
+		d.cutab = d.word(8 + 4*d.ptrSize)
+		d.filetab = d.word(8 + 5*d.ptrSize)
+		d.pctab = d.word(8 + 6*d.ptrSize)
+		d.functab = d.word(8 + 7*d.ptrSize)
+		d.funcBase = d.functab
+		d.hasCUOffset = true
+		d.hasStartLine = tab.Magic == GoPclntabMagic120
+	default:
+		return nil, fmt.Errorf("unknown pclntab magic %#x", tab.Magic)
+	}
+
+	if d.functab+(2*d.nfunc+1)*d.fieldSize() > uint64(len(d.data)) {
+		return nil, errors.New("truncated functab")
+	}
+	if tab.Magic == GoPclntabMagic12 {
+		// The offset of the file table follows the functab.
+		off := d.functab + (2*d.nfunc+1)*d.fieldSize()
+		if off+4 > uint64(len(d.data)) {
+			return nil, errors.New("truncated functab")
+		}
+		d.filetab = uint64(d.order.Uint32(d.data[off:]))
+	}
+	return d, nil
+}
+
+func (d *funcTableDecoder) word(off int) uint64 {
+	return readUint(d.data[off:], d.ptrSize, d.order)
+}
+
+// The functab is an array of (entry, funcoff) pairs followed by the end address of the last
+// function. Before Go 1.18 the entries are pointers and the offsets are relative to the start
+// of the table for Go 1.16+ and to the pclntab for older versions; since Go 1.18 both are
+// 32-bit offsets, from runtime.text and from the functab.
+func (d *funcTableDecoder) fieldSize() uint64 {
+	if d.ptrEntries {
+		return uint64(d.ptrSize)
+	}
+	return 4
+}
+
+func (d *funcTableDecoder) field(i uint64) uint64 {
+	size := d.fieldSize()
+	return readUint(d.data[d.functab+i*size:], int(size), d.order)
+}
+
+// entry returns the entry address of the i-th function, or the end of the last one for nfunc.
+func (d *funcTableDecoder) entry(i uint64) uint64 {
+	if d.ptrEntries {
+		return d.field(2 * i)
+	}
+	return d.textStart + d.field(2*i)
+}
+
+// funcOff returns the offset of the _func record of the i-th function.
+func (d *funcTableDecoder) funcOff(i uint64) uint64 {
+	return d.funcBase + d.field(2*i+1)
+}
+
+// find returns the index of the function containing pc with a binary search of the functab.
+func (d *funcTableDecoder) find(pc uint64) (uint64, bool) {
+	if d.nfunc == 0 || pc < d.entry(0) || pc >= d.entry(d.nfunc) {
+		return 0, false
+	}
+	i := sort.Search(int(d.nfunc), func(i int) bool {
+		return d.entry(uint64(i)) > pc
+	})
+	return uint64(i - 1), true
+}
+
+func goFuncID(ids []GoFuncID, raw uint8) GoFuncID {
+	if int(raw) < len(ids) && ids[raw] != "" {
+		return ids[raw]
+	}
+	return GoFuncID(fmt.Sprintf("unknown(%d)", raw))
+}
+
+// specialGoFuncIDs are the funcIDs of the known enumerations named after a runtime function.
+var specialGoFuncIDs = func() map[GoFuncID]bool {
+	res := make(map[GoFuncID]bool)
+	for _, ids := range [][]GoFuncID{goFuncIDs114, goFuncIDs117, goFuncIDs118, goFuncIDs123} {
+		for _, id := range ids {
+			if id != GoFuncIDNormal && id != GoFuncIDWrapper {
+				res[id] = true
+			}
+		}
+	}
+	return res
+}()
+
+// calibrateGoFuncIDs adjusts the funcID enumeration of the detected version to the binary,
+// so that versions newer than the known ones and misdetected versions still decode correctly.
+// The anchors are the special runtime functions, named after their funcID. When they agree
+// with the enumeration it is used as is, otherwise only the anchored values are known, plus
+// the wrapper one: it ends the enumeration, so it moves with the highest anchor.
+func calibrateGoFuncIDs(known []GoFuncID, funcs []GoFunc) []GoFuncID {
+	raws := make(map[GoFuncID][]uint8)
+	for _, fn := range funcs {
+		name, ok := strings.CutPrefix(fn.Name, "runtime.")
+		if !ok || fn.RawFuncID == 0 {
+			continue
+		}
+		if name == "main" {
+			name = "runtime_main"
+		}
+		id := GoFuncID(name)
+		if !specialGoFuncIDs[id] || containsRawFuncID(raws[id], fn.RawFuncID) {
+			continue
+		}
+		raws[id] = append(raws[id], fn.RawFuncID)
+	}
+
+	names := make([]GoFuncID, 0, len(raws))
+	for id := range raws {
+		names = append(names, id)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+
+	anchors := make(map[uint8]GoFuncID)
+	agree := true
+	for _, id := range names {
+		raw, ok := raws[id][0], len(raws[id]) == 1
+		if !ok {
+			// ABI wrappers share the name of the function they wrap, the value of the
+			// enumeration is the function itself if it is one of them.
+			for _, r := range raws[id] {
+				if int(r) < len(known) && known[r] == id {
+					raw, ok = r, true
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		if _, dup := anchors[raw]; dup {
+			agree = false
+			continue
+		}
+		anchors[raw] = id
+		if int(raw) >= len(known) || known[raw] != id {
+			agree = false
+		}
+	}
+	if agree {
+		return known
+	}
+
+	n, top := len(known), -1
+	for raw := range anchors {
+		if int(raw) >= n {
+			n = int(raw) + 1
+		}
+		if int(raw) > top {
+			top = int(raw)
+		}
+	}
+	wrapper := -1
+	if top >= 0 {
+		for i, id := range known {
+			if id == anchors[uint8(top)] {
+				wrapper = top + len(known) - 1 - i
+			}
+		}
+	}
+	if wrapper > math.MaxUint8 {
+		wrapper = -1
+	}
+	if wrapper >= n {
+		n = wrapper + 1
+	}
+	ids := make([]GoFuncID, n)
+	ids[0] = GoFuncIDNormal
+	for raw, id := range anchors {
+		ids[raw] = id
+	}
+	if wrapper >= 0 {
+		ids[wrapper] = GoFuncIDWrapper
+	}
+	return ids
+}
+
+func containsRawFuncID(raws []uint8, raw uint8) bool {
+	for _, r := range raws {
+		if r == raw {
+			return true
+		}
+	}
+	return false
+}
+
+// funcRecord is a decoded _func record.
+type funcRecord struct {
+	GoFunc
+	pcfile, pcln, cuOffset uint32
+}
+
+// funcSize returns the size of a _func record up to funcID.
+func (d *funcTableDecoder) funcSize() uint64 {
+	size := d.fieldSize() + 7*4
+	if d.hasCUOffset {
+		size += 4
+	}
+	if d.hasStartLine {
+		size += 4
+	}
+	return size
+}
+
+// rawFuncID returns the funcID of the _func record at off without decoding the rest.
+func (d *funcTableDecoder) rawFuncID(off uint64) uint8 {
+	if i := off + d.funcSize(); i < uint64(len(d.data)) {
+		return d.data[i]
+	}
+	return 0
+}
+
+// decodeFunc decodes the _func record at off:
+//
+//	entry (uintptr before Go 1.18, uint32 offset since) | nameOff int32 | args int32 |
+//	deferreturn uint32 | pcsp, pcfile, pcln, npcdata uint32 | cuOffset uint32 (Go 1.16+) |
+//	startLine int32 (Go 1.20+) | funcID uint8 | flag uint8 (Go 1.17+) | _ uint8 | nfuncdata uint8
+func (d *funcTableDecoder) decodeFunc(off uint64) (funcRecord, error) {
+	size := d.funcSize()
+	if off+size+4 > uint64(len(d.data)) {
+		return funcRecord{}, fmt.Errorf("_func record at %#x is out of bounds", off)
+	}
+
+	b := d.data[off+d.fieldSize():]
+	nameOff := d.order.Uint32(b[0:])
+	rec := funcRecord{
+		GoFunc: GoFunc{
+			Args:        int32(d.order.Uint32(b[4:])),
+			DeferReturn: d.order.Uint32(b[8:]),
+			RawFuncID:   d.data[off+size],
+			Flag:        GoFuncFlag(d.data[off+size+1]),
+		},
+		pcfile: d.order.Uint32(b[16:]),
+		pcln:   d.order.Uint32(b[20:]),
+	}
+	if d.hasCUOffset {
+		rec.cuOffset = d.order.Uint32(b[28:])
+	}
+
+	name, ok := d.cstring(d.nameBase + uint64(nameOff))
+	if !ok {
+		return funcRecord{}, fmt.Errorf("function name offset %#x is out of bounds", nameOff)
+	}
+	rec.Name = name
+	return rec, nil
+}
+
+// cstring returns the NUL terminated string at off.
+func (d *funcTableDecoder) cstring(off uint64) (string, bool) {
+	if off >= uint64(len(d.data)) {
+		return "", false
+	}
+	b := d.data[off:]
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b), true
+}
+
+// Lookup returns the function containing pc.
+func (t *GoFuncTable) Lookup(pc uint64) (GoFunc, bool) {
+	i := sort.Search(len(t.Funcs), func(i int) bool {
+		return t.Funcs[i].Entry > pc
+	})
+	if i == 0 || pc >= t.Funcs[i-1].End {
+		return GoFunc{}, false
+	}
+	return t.Funcs[i-1], true
+}
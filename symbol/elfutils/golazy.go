@@ -0,0 +1,336 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfutils
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// GoLazyTable looks up functions and lines in a pclntab without building any index.
+// Each lookup binary-searches the functab and only decodes the function containing the address,
+// which keeps the memory usage independent of the size of the binary when the pclntab data
+// is memory mapped, see MapGoPclntab.
+type GoLazyTable struct {
+	d *funcTableDecoder
+
+	idsOnce sync.Once
+	ids     []GoFuncID
+}
+
+// NewGoLazyTable creates a GoLazyTable over the data of tab, which isn't copied.
+func NewGoLazyTable(tab *GoPclntab, version GoVersion, order binary.ByteOrder) (*GoLazyTable, error) {
+	d, err := newFuncTableDecoder(tab, version, order)
+	if err != nil {
+		return nil, err
+	}
+	return &GoLazyTable{d: d}, nil
+}
+
+// Len returns the number of functions.
+func (t *GoLazyTable) Len() int {
+	return int(t.d.nfunc)
+}
+
+// PCRange returns the address interval [start, end) covered by the functions.
+func (t *GoLazyTable) PCRange() [2]uint64 {
+	if t.d.nfunc == 0 {
+		return [2]uint64{}
+	}
+	return [2]uint64{t.d.entry(0), t.d.entry(t.d.nfunc)}
+}
+
+// FuncAt returns the metadata of the function containing pc.
+func (t *GoLazyTable) FuncAt(pc uint64) (GoFunc, bool) {
+	rec, ok := t.funcAt(pc)
+	if !ok {
+		return GoFunc{}, false
+	}
+	return rec.GoFunc, true
+}
+
+// Func returns the metadata of the i-th function, in address order.
+func (t *GoLazyTable) Func(i int) (GoFunc, bool) {
+	if i < 0 || uint64(i) >= t.d.nfunc {
+		return GoFunc{}, false
+	}
+	rec, ok := t.funcByIndex(uint64(i))
+	if !ok {
+		return GoFunc{}, false
+	}
+	return rec.GoFunc, true
+}
+
+func (t *GoLazyTable) funcAt(pc uint64) (funcRecord, bool) {
+	i, ok := t.d.find(pc)
+	if !ok {
+		return funcRecord{}, false
+	}
+	return t.funcByIndex(i)
+}
+
+func (t *GoLazyTable) funcByIndex(i uint64) (funcRecord, bool) {
+	rec, err := t.d.decodeFunc(t.d.funcOff(i))
+	if err != nil {
+		return funcRecord{}, false
+	}
+	rec.Entry, rec.End = t.d.entry(i), t.d.entry(i+1)
+	rec.FuncID = goFuncID(t.funcIDs(), rec.RawFuncID)
+	return rec, true
+}
+
+// funcIDs calibrates the funcID enumeration once. Only the functions with a special funcID,
+// a few dozens, are decoded.
+func (t *GoLazyTable) funcIDs() []GoFuncID {
+	t.idsOnce.Do(func() {
+		var special []GoFunc
+		for i := uint64(0); i < t.d.nfunc; i++ {
+			off := t.d.funcOff(i)
+			if t.d.rawFuncID(off) == 0 {
+				continue
+			}
+			if rec, err := t.d.decodeFunc(off); err == nil {
+				special = append(special, rec.GoFunc)
+			}
+		}
+		t.ids = calibrateGoFuncIDs(goFuncIDsFor(t.d.version), special)
+	})
+	return t.ids
+}
+
+// PCToLine returns the file name, line number and function name for pc.
+// It returns false if pc isn't covered by the table.
+func (t *GoLazyTable) PCToLine(pc uint64) (file string, line int, fn string, ok bool) {
+	rec, ok := t.funcAt(pc)
+	if !ok {
+		return "", 0, "", false
+	}
+
+	// Like debug/gosym, the line is -1 for functions without line information, e.g. some assembly stubs.
+	line = -1
+	if l, ok := t.pcvalue(rec.pcln, rec.Entry, pc); ok {
+		line = int(l)
+	}
+	if fileno, ok := t.pcvalue(rec.pcfile, rec.Entry, pc); ok {
+		file = t.fileName(rec.cuOffset, fileno)
+	}
+	return file, line, rec.Name, true
+}
+
+// pcvalue decodes the pc-value table at off for a function starting at entry, see runtime.pcvalue.
+// The table is a sequence of (value delta, pc delta) pairs of varints, the value delta being
+// zig-zag encoded and the pc delta scaled by the instruction size quantum.
+func (t *GoLazyTable) pcvalue(off uint32, entry, target uint64) (int32, bool) {
+	if off == 0 || t.d.pctab+uint64(off) >= uint64(len(t.d.data)) {
+		return 0, false
+	}
+	p := t.d.data[t.d.pctab+uint64(off):]
+
+	val, pc := int32(-1), entry
+	for {
+		uvdelta, n := binary.Uvarint(p)
+		if n <= 0 || uvdelta == 0 && pc != entry {
+			return 0, false
+		}
+		p = p[n:]
+		delta := uint32(uvdelta)
+		if delta&1 != 0 {
+			delta = ^(delta >> 1)
+		} else {
+			delta >>= 1
+		}
+		val += int32(delta)
+
+		pcdelta, n := binary.Uvarint(p)
+		if n <= 0 {
+			return 0, false
+		}
+		p = p[n:]
+		pc += pcdelta * t.d.quantum
+		if target < pc {
+			return val, true
+		}
+	}
+}
+
+// GoLineRange is an address interval of the code generated for a source line.
+type GoLineRange struct {
+	Func  string
+	File  string
+	Line  int
+	Start uint64
+	End   uint64
+}
+
+// LineRanges returns the address intervals [Start, End) of the code generated for line
+// of the files accepted by matchFile, in address order. Every function is decoded.
+func (t *GoLazyTable) LineRanges(matchFile func(file string) bool, line int) []GoLineRange {
+	type fileKey struct {
+		cuOffset uint32
+		fileno   int32
+	}
+	files := make(map[fileKey]string)
+
+	var res []GoLineRange
+	for i := uint64(0); i < t.d.nfunc; i++ {
+		rec, err := t.d.decodeFunc(t.d.funcOff(i))
+		if err != nil {
+			continue
+		}
+		entry := t.d.entry(i)
+
+		var fileRuns []pcvalueRun
+		for _, r := range t.pcvalueRuns(rec.pcfile, entry) {
+			key := fileKey{rec.cuOffset, r.val}
+			name, ok := files[key]
+			if !ok {
+				name = t.fileName(rec.cuOffset, r.val)
+				files[key] = name
+			}
+			if matchFile(name) {
+				fileRuns = append(fileRuns, r)
+			}
+		}
+		if len(fileRuns) == 0 {
+			continue
+		}
+
+		// Intersect the runs of the file and of the line, both are sorted.
+		lineRuns := t.pcvalueRuns(rec.pcln, entry)
+		for f, l := 0, 0; f < len(fileRuns) && l < len(lineRuns); {
+			fr, lr := fileRuns[f], lineRuns[l]
+			if lr.val == int32(line) {
+				start, end := max(fr.start, lr.start), min(fr.end, lr.end)
+				if start < end {
+					file := files[fileKey{rec.cuOffset, fr.val}]
+					if n := len(res); n > 0 && res[n-1].End == start && res[n-1].File == file && res[n-1].Func == rec.Name {
+						res[n-1].End = end
+					} else {
+						res = append(res, GoLineRange{Func: rec.Name, File: file, Line: line, Start: start, End: end})
+					}
+				}
+			}
+			if fr.end < lr.end {
+				f++
+			} else {
+				l++
+			}
+		}
+	}
+	return res
+}
+
+// FuncLines returns the address intervals [Start, End) of the code of the i-th function,
+// in address order, with the file and the line they were generated for.
+func (t *GoLazyTable) FuncLines(i int) []GoLineRange {
+	rec, ok := t.funcByIndex(uint64(i))
+	if !ok {
+		return nil
+	}
+	fileRuns := t.pcvalueRuns(rec.pcfile, rec.Entry)
+	lineRuns := t.pcvalueRuns(rec.pcln, rec.Entry)
+
+	var res []GoLineRange
+	for f, l := 0, 0; f < len(fileRuns) && l < len(lineRuns); {
+		fr, lr := fileRuns[f], lineRuns[l]
+		start, end := max(fr.start, lr.start), min(fr.end, lr.end)
+		if start < end {
+			file := t.fileName(rec.cuOffset, fr.val)
+			if n := len(res); n > 0 && res[n-1].End == start && res[n-1].File == file && res[n-1].Line == int(lr.val) {
+				res[n-1].End = end
+			} else {
+				res = append(res, GoLineRange{Func: rec.Name, File: file, Line: int(lr.val), Start: start, End: end})
+			}
+		}
+		if fr.end < lr.end {
+			f++
+		} else {
+			l++
+		}
+	}
+	return res
+}
+
+// pcvalueRun is an address interval [start, end) with the same value in a pc-value table.
+type pcvalueRun struct {
+	start, end uint64
+	val        int32
+}
+
+// pcvalueRuns decodes the whole pc-value table at off of a function starting at entry, see pcvalue.
+func (t *GoLazyTable) pcvalueRuns(off uint32, entry uint64) []pcvalueRun {
+	if off == 0 || t.d.pctab+uint64(off) >= uint64(len(t.d.data)) {
+		return nil
+	}
+	p := t.d.data[t.d.pctab+uint64(off):]
+
+	var runs []pcvalueRun
+	val, pc := int32(-1), entry
+	for {
+		uvdelta, n := binary.Uvarint(p)
+		if n <= 0 || uvdelta == 0 && pc != entry {
+			return runs
+		}
+		p = p[n:]
+		delta := uint32(uvdelta)
+		if delta&1 != 0 {
+			delta = ^(delta >> 1)
+		} else {
+			delta >>= 1
+		}
+		val += int32(delta)
+
+		pcdelta, n := binary.Uvarint(p)
+		if n <= 0 {
+			return runs
+		}
+		p = p[n:]
+		next := pc + pcdelta*t.d.quantum
+		runs = append(runs, pcvalueRun{start: pc, end: next, val: val})
+		pc = next
+	}
+}
+
+// fileName returns the name of a file number of a function. Since Go 1.16 the file numbers
+// are indexes in the compilation unit table, before they indexed the file table.
+func (t *GoLazyTable) fileName(cuOffset uint32, fileno int32) string {
+	if fileno < 0 {
+		return "?"
+	}
+	d := t.d
+
+	var idx uint64
+	if d.hasCUOffset {
+		idx = d.cutab + 4*(uint64(cuOffset)+uint64(fileno))
+	} else {
+		idx = d.filetab + 4*uint64(fileno)
+	}
+	if idx+4 > uint64(len(d.data)) {
+		return "?"
+	}
+	off := d.order.Uint32(d.data[idx:])
+	if off == ^uint32(0) {
+		return "?"
+	}
+
+	base := uint64(0)
+	if d.hasCUOffset {
+		base = d.filetab
+	}
+	name, ok := d.cstring(base + uint64(off))
+	if !ok {
+		return "?"
+	}
+	return name
+}
@@ -0,0 +1,332 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfutils
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Magic numbers of the pclntab header for each format revision.
+const (
+	GoPclntabMagic12  uint32 = 0xfffffffb
+	GoPclntabMagic116 uint32 = 0xfffffffa
+	GoPclntabMagic118 uint32 = 0xfffffff0
+	GoPclntabMagic120 uint32 = 0xfffffff1
+)
+
+// GoPclntab is the Go program counter line table (pclntab) located in an ELF file.
+type GoPclntab struct {
+	// Data starts with the pclntab header. It may extend past the end of the table.
+	Data []byte
+	// Addr is the virtual address of the table.
+	Addr uint64
+	// Magic is the magic number of the table header.
+	Magic uint32
+	// TextStart is the address of runtime.text, 0 if it can't be determined.
+	TextStart uint64
+	// Source tells how the table was found, e.g. ".gopclntab section".
+	Source string
+}
+
+// FindGoPclntab locates the pclntab of a Go binary.
+//
+// It uses the .gopclntab section when present. Otherwise, e.g. for externally linked
+// PIE binaries that keep the table in .data.rel.ro or binaries without section headers,
+// it uses the runtime.pclntab and runtime.firstmoduledata symbols and, as a last resort,
+// scans the loadable segments for a valid pclntab header.
+func FindGoPclntab(f *elf.File) (*GoPclntab, error) {
+	return findGoPclntab(f, nil)
+}
+
+// MapGoPclntab locates the pclntab like FindGoPclntab, but the returned data is a slice of
+// mapped, the contents of the whole file, e.g. memory mapped with MmapFile, instead of a copy.
+func MapGoPclntab(f *elf.File, mapped []byte) (*GoPclntab, error) {
+	if mapped == nil {
+		return nil, errors.New("no mapped data")
+	}
+	return findGoPclntab(f, mapped)
+}
+
+func findGoPclntab(f *elf.File, mapped []byte) (*GoPclntab, error) {
+	tab, err := lookupGoPclntab(f, mapped)
+	if err != nil {
+		return nil, err
+	}
+	tab.Magic = f.ByteOrder.Uint32(tab.Data)
+	tab.TextStart = goTextStart(f, tab, mapped)
+	return tab, nil
+}
+
+func lookupGoPclntab(f *elf.File, mapped []byte) (*GoPclntab, error) {
+	if sec := f.Section(".gopclntab"); sec != nil {
+		if sec.Type == elf.SHT_NOBITS {
+			return nil, errors.New(".gopclntab section has no bits")
+		}
+		data, err := sectionData(sec, mapped)
+		if err != nil {
+			return nil, fmt.Errorf("could not read .gopclntab section: %w", err)
+		}
+		if !validGoPclntabHeader(data, f.ByteOrder) {
+			return nil, errors.New(".gopclntab section has an invalid header")
+		}
+		return &GoPclntab{Data: data, Addr: sec.Addr, Source: ".gopclntab section"}, nil
+	}
+
+	var mem *virtualMemory
+	if mapped != nil {
+		mem = newMappedMemory(f, mapped)
+	} else {
+		mem = newVirtualMemory(f)
+	}
+
+	syms := lookupSymbols(f, mapped, "runtime.pclntab", "runtime.epclntab", "runtime.firstmoduledata")
+
+	if start, ok := syms["runtime.pclntab"]; ok {
+		if end, ok := syms["runtime.epclntab"]; ok && end > start {
+			if data, err := mem.read(start, end-start); err == nil && validGoPclntabHeader(data, f.ByteOrder) {
+				return &GoPclntab{Data: data, Addr: start, Source: "runtime.pclntab symbol"}, nil
+			}
+		}
+	}
+
+	if md, ok := syms["runtime.firstmoduledata"]; ok {
+		// The first field of runtime.moduledata is a pointer to the pclntab header.
+		if ptr, err := mem.read(md, uint64(ptrSize(f))); err == nil {
+			addr := readPtr(ptr, f)
+			if data, err := mem.tail(addr); err == nil && validGoPclntabHeader(data, f.ByteOrder) {
+				return &GoPclntab{Data: data, Addr: addr, Source: "runtime.firstmoduledata symbol"}, nil
+			}
+		}
+	}
+
+	for _, seg := range mem.segments {
+		if off, ok := scanGoPclntab(seg.data, f.ByteOrder); ok {
+			return &GoPclntab{Data: seg.data[off:], Addr: seg.addr + uint64(off), Source: "segment scan"}, nil
+		}
+	}
+
+	return nil, errors.New("failed to find Go pclntab")
+}
+
+// scanGoPclntab returns the offset of the first valid pclntab header in data.
+func scanGoPclntab(data []byte, order binary.ByteOrder) (int, bool) {
+	var magics [][]byte
+	for _, magic := range []uint32{GoPclntabMagic120, GoPclntabMagic118, GoPclntabMagic116, GoPclntabMagic12} {
+		b := make([]byte, 6)
+		order.PutUint32(b, magic)
+		magics = append(magics, b)
+	}
+
+	for _, magic := range magics {
+		for off := 0; off < len(data); {
+			i := bytes.Index(data[off:], magic)
+			if i < 0 {
+				break
+			}
+			off += i
+			// The header is aligned to the pointer size.
+			if off%4 == 0 && validGoPclntabHeader(data[off:], order) {
+				return off, true
+			}
+			off++
+		}
+	}
+	return 0, false
+}
+
+// validGoPclntabHeader performs sanity checks on a candidate pclntab header.
+func validGoPclntabHeader(data []byte, order binary.ByteOrder) bool {
+	if len(data) < 16 {
+		return false
+	}
+	magic := order.Uint32(data)
+	switch magic {
+	case GoPclntabMagic12, GoPclntabMagic116, GoPclntabMagic118, GoPclntabMagic120:
+	default:
+		return false
+	}
+	if data[4] != 0 || data[5] != 0 {
+		return false
+	}
+	if quantum := data[6]; quantum != 1 && quantum != 2 && quantum != 4 {
+		return false
+	}
+	size := int(data[7])
+	if size != 4 && size != 8 {
+		return false
+	}
+
+	word := func(i int) (uint64, bool) {
+		off := 8 + i*size
+		if off+size > len(data) {
+			return 0, false
+		}
+		if size == 4 {
+			return uint64(order.Uint32(data[off:])), true
+		}
+		return order.Uint64(data[off:]), true
+	}
+
+	nfunc, ok := word(0)
+	if !ok || nfunc == 0 || nfunc > uint64(len(data)) {
+		return false
+	}
+
+	// The offsets of the sub-tables must be increasing and within the data.
+	var first, count int
+	switch magic {
+	case GoPclntabMagic12:
+		return true
+	case GoPclntabMagic116:
+		first, count = 2, 5 // funcnameOffset, cuOffset, filetabOffset, pctabOffset, pclnOffset
+	default:
+		first, count = 3, 5 // after textStart
+	}
+	prev := uint64(0)
+	for i := first; i < first+count; i++ {
+		off, ok := word(i)
+		if !ok || off < prev || off > uint64(len(data)) {
+			return false
+		}
+		prev = off
+	}
+	return true
+}
+
+// goTextStart returns the address of runtime.text for a pclntab.
+//
+// Go 1.18+ records it in the header, unless the binary is externally linked. Then it is
+// the text field of runtime.firstmoduledata or the runtime.text symbol. The start of .text
+// is the last resort, it is wrong when C code comes before runtime.text.
+func goTextStart(f *elf.File, tab *GoPclntab, mapped []byte) uint64 {
+	if tab.Magic == GoPclntabMagic118 || tab.Magic == GoPclntabMagic120 {
+		size := int(tab.Data[7])
+		if len(tab.Data) >= 8+3*size {
+			if start := readUint(tab.Data[8+2*size:], size, f.ByteOrder); start != 0 {
+				return start
+			}
+		}
+		if start, ok := goModuledataText(f, tab, mapped); ok {
+			return start
+		}
+	}
+	if start, ok := lookupSymbols(f, mapped, "runtime.text")["runtime.text"]; ok {
+		return start
+	}
+	if sec := f.Section(".text"); sec != nil {
+		return sec.Addr
+	}
+	return 0
+}
+
+// goModuledataText returns the text field of the Go 1.18+ runtime.moduledata of tab,
+// found by its first field, the address of the pclntab header, in the writable data.
+func goModuledataText(f *elf.File, tab *GoPclntab, mapped []byte) (uint64, bool) {
+	size := ptrSize(f)
+	// pcHeader, 6 slices, findfunctab, minpc, maxpc and text.
+	const minpcField, maxpcField, textField = 1 + 6*3 + 1, 1 + 6*3 + 2, 1 + 6*3 + 3
+	find := func(data []byte) (uint64, bool) {
+		for off := 0; off+(textField+1)*size <= len(data); off += size {
+			if readUint(data[off:], size, f.ByteOrder) != tab.Addr {
+				continue
+			}
+			minpc := readUint(data[off+minpcField*size:], size, f.ByteOrder)
+			maxpc := readUint(data[off+maxpcField*size:], size, f.ByteOrder)
+			text := readUint(data[off+textField*size:], size, f.ByteOrder)
+			if text != 0 && text <= minpc && minpc < maxpc && isExecutable(f, text) {
+				return text, true
+			}
+		}
+		return 0, false
+	}
+
+	// The linker puts runtime.firstmoduledata in .noptrdata.
+	if sec := f.Section(".noptrdata"); sec != nil && sec.Type == elf.SHT_PROGBITS {
+		if data, err := sectionData(sec, mapped); err == nil {
+			if text, ok := find(data); ok {
+				return text, true
+			}
+		}
+	}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Flags&elf.PF_W == 0 || prog.Filesz == 0 {
+			continue
+		}
+		data, err := segmentData(prog, mapped)
+		if err != nil {
+			continue
+		}
+		if text, ok := find(data); ok {
+			return text, true
+		}
+	}
+	return 0, false
+}
+
+// isExecutable reports whether addr is in an executable loadable segment of f.
+func isExecutable(f *elf.File, addr uint64) bool {
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Flags&elf.PF_X != 0 && addr >= prog.Vaddr && addr < prog.Vaddr+prog.Memsz {
+			return true
+		}
+	}
+	return false
+}
+
+// sectionData returns the data of sec, a slice of mapped if not nil and sec isn't compressed.
+func sectionData(sec *elf.Section, mapped []byte) ([]byte, error) {
+	if mapped != nil && sec.Flags&elf.SHF_COMPRESSED == 0 && sec.Offset+sec.Size <= uint64(len(mapped)) {
+		return mapped[sec.Offset : sec.Offset+sec.Size], nil
+	}
+	return sec.Data()
+}
+
+// segmentData returns the file data of prog, a slice of mapped if not nil.
+func segmentData(prog *elf.Prog, mapped []byte) ([]byte, error) {
+	if mapped != nil && prog.Off+prog.Filesz <= uint64(len(mapped)) {
+		return mapped[prog.Off : prog.Off+prog.Filesz], nil
+	}
+	return io.ReadAll(prog.Open())
+}
+
+func ptrSize(f *elf.File) int {
+	if f.Class == elf.ELFCLASS32 {
+		return 4
+	}
+	return 8
+}
+
+func readPtr(b []byte, f *elf.File) uint64 {
+	return readUint(b, ptrSize(f), f.ByteOrder)
+}
+
+// readVirtual reads size bytes at the virtual address addr from the loadable segments of f.
+func readVirtual(f *elf.File, addr, size uint64) ([]byte, error) {
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || addr < prog.Vaddr || addr+size > prog.Vaddr+prog.Filesz {
+			continue
+		}
+		b := make([]byte, size)
+		if _, err := prog.ReadAt(b, int64(addr-prog.Vaddr)); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("address %#x is not mapped from the file", addr)
+}
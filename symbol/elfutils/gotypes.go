@@ -0,0 +1,549 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfutils
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// GoType is a type descriptor of the Go runtime (runtime._type, internal/abi.Type since Go 1.21).
+type GoType struct {
+	// Addr is the address of the type descriptor.
+	Addr uint64
+	// Name is the type as printed by reflect, e.g. "main.T", "*main.T" or "[]int".
+	Name string
+	// PkgPath is the import path of the package defining a named type.
+	PkgPath string
+	Kind    reflect.Kind
+	Size    uint64
+	Align   uint8
+	// Named tells whether the type has a name, as opposed to a type literal.
+	Named bool
+	// Elem is the name of the element type of arrays, channels, maps, pointers and slices.
+	Elem string
+	// Fields are the fields of a struct type.
+	Fields []GoStructField
+	// Methods are the method names of an interface type.
+	Methods []string
+}
+
+// GoStructField is a field of a struct type.
+type GoStructField struct {
+	Name     string
+	Type     string
+	Offset   uint64
+	Embedded bool
+}
+
+// GoItab records that a concrete type implements an interface (runtime.itab).
+type GoItab struct {
+	Addr      uint64
+	Interface string
+	Type      string
+	// Funcs are the addresses of the methods of Type implementing the interface methods.
+	Funcs []uint64
+}
+
+// GoTypes are the runtime types of a Go binary.
+type GoTypes struct {
+	// Types are sorted by name.
+	Types []GoType
+	Itabs []GoItab
+}
+
+// Lookup returns the type with the given name.
+func (t *GoTypes) Lookup(name string) (GoType, bool) {
+	i := sort.Search(len(t.Types), func(i int) bool { return t.Types[i].Name >= name })
+	if i < len(t.Types) && t.Types[i].Name == name {
+		return t.Types[i], true
+	}
+	return GoType{}, false
+}
+
+// Implementations returns the names of the types implementing an interface.
+func (t *GoTypes) Implementations(iface string) []string {
+	var res []string
+	for _, itab := range t.Itabs {
+		if itab.Interface == iface {
+			res = append(res, itab.Type)
+		}
+	}
+	return res
+}
+
+// Type flags, see internal/abi.TFlag.
+const (
+	tflagUncommon  = 1 << 0
+	tflagExtraStar = 1 << 1
+	tflagNamed     = 1 << 2
+)
+
+// moduledataLayout holds the indexes, in words, of the runtime.moduledata fields that are used.
+type moduledataLayout struct {
+	types, typelinks int
+}
+
+// moduledataLayoutFor returns the layout of runtime.moduledata, which changes with almost every release.
+// Go 1.18 added rodata and gofunc, Go 1.20 added covctrs and ecovctrs.
+func moduledataLayoutFor(v GoVersion) (moduledataLayout, error) {
+	switch {
+	case v.AtLeast(1, 20):
+		return moduledataLayout{types: 37, typelinks: 44}, nil
+	case v.AtLeast(1, 18):
+		return moduledataLayout{types: 35, typelinks: 42}, nil
+	case v.AtLeast(1, 16):
+		return moduledataLayout{types: 35, typelinks: 40}, nil
+	default:
+		return moduledataLayout{}, fmt.Errorf("unsupported moduledata layout for Go version %s", v.Raw)
+	}
+}
+
+// GoRuntimeTypes extracts the type descriptors and itabs referenced by the typelinks and itablinks
+// of runtime.firstmoduledata. It doesn't need symbols nor DWARF, the moduledata is found
+// through its pointer to the pclntab when the binary is stripped.
+//
+// The version selects the layouts of the runtime structures, Go 1.16 and later are supported.
+func GoRuntimeTypes(f *elf.File, version GoVersion) (*GoTypes, error) {
+	layout, err := moduledataLayoutFor(version)
+	if err != nil {
+		return nil, err
+	}
+	tab, err := FindGoPclntab(f)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &typeReader{mem: newVirtualMemory(f), order: f.ByteOrder, ptrSize: ptrSize(f), version: version}
+	md, err := r.findModuledata(f, tab)
+	if err != nil {
+		return nil, err
+	}
+
+	words := make([]uint64, layout.typelinks+6+1)
+	for i := range words {
+		if words[i], err = r.word(md + uint64(i*r.ptrSize)); err != nil {
+			return nil, fmt.Errorf("failed to read moduledata: %w", err)
+		}
+	}
+
+	r.types, r.etypes = words[layout.types], words[layout.types+1]
+	typelinks, itablinks := layout.typelinks, layout.typelinks+3
+	// Later versions have an extra word before textsectmap, the epclntab address.
+	if !r.validSlice(words[typelinks:]) || !r.validSlice(words[itablinks:]) {
+		typelinks, itablinks = typelinks+1, itablinks+1
+	}
+	if r.types == 0 || r.etypes <= r.types || !r.validSlice(words[typelinks:]) || !r.validSlice(words[itablinks:]) {
+		return nil, fmt.Errorf("unexpected moduledata layout for Go version %s", version.Raw)
+	}
+
+	r.seen = map[uint64]*GoType{}
+	links, err := r.mem.read(words[typelinks], 4*words[typelinks+1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to read typelinks: %w", err)
+	}
+	for i := 0; i < len(links); i += 4 {
+		if _, err := r.readType(r.types + uint64(r.order.Uint32(links[i:]))); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &GoTypes{}
+	for i := uint64(0); i < words[itablinks+1]; i++ {
+		addr, err := r.word(words[itablinks] + i*uint64(r.ptrSize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read itablinks: %w", err)
+		}
+		itab, err := r.readItab(addr)
+		if err != nil {
+			return nil, err
+		}
+		res.Itabs = append(res.Itabs, itab)
+	}
+
+	for _, t := range r.seen {
+		res.Types = append(res.Types, *t)
+	}
+	sort.Slice(res.Types, func(i, j int) bool {
+		if res.Types[i].Name != res.Types[j].Name {
+			return res.Types[i].Name < res.Types[j].Name
+		}
+		return res.Types[i].Addr < res.Types[j].Addr
+	})
+	return res, nil
+}
+
+// virtualMemory reads the loadable segments of an ELF file by virtual address.
+type virtualMemory struct {
+	segments []virtualSegment
+}
+
+type virtualSegment struct {
+	addr uint64
+	data []byte
+}
+
+func newVirtualMemory(f *elf.File) *virtualMemory {
+	m := &virtualMemory{}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Filesz == 0 {
+			continue
+		}
+		data, err := io.ReadAll(prog.Open())
+		if err != nil {
+			continue
+		}
+		m.segments = append(m.segments, virtualSegment{addr: prog.Vaddr, data: data})
+	}
+	return m
+}
+
+// newMappedMemory is like newVirtualMemory, but the segments are slices of mapped,
+// the contents of the whole file.
+func newMappedMemory(f *elf.File, mapped []byte) *virtualMemory {
+	m := &virtualMemory{}
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Filesz == 0 || prog.Off+prog.Filesz > uint64(len(mapped)) {
+			continue
+		}
+		m.segments = append(m.segments, virtualSegment{addr: prog.Vaddr, data: mapped[prog.Off : prog.Off+prog.Filesz]})
+	}
+	return m
+}
+
+// tail returns the data of the segment containing addr, starting at addr.
+func (m *virtualMemory) tail(addr uint64) ([]byte, error) {
+	for _, s := range m.segments {
+		if addr >= s.addr && addr < s.addr+uint64(len(s.data)) {
+			return s.data[addr-s.addr:], nil
+		}
+	}
+	return nil, fmt.Errorf("address %#x is not mapped from the file", addr)
+}
+
+func (m *virtualMemory) read(addr, size uint64) ([]byte, error) {
+	for _, s := range m.segments {
+		if addr >= s.addr && addr+size <= s.addr+uint64(len(s.data)) && addr+size >= addr {
+			return s.data[addr-s.addr : addr-s.addr+size], nil
+		}
+	}
+	return nil, fmt.Errorf("address %#x is not mapped from the file", addr)
+}
+
+// typeReader decodes runtime type descriptors.
+type typeReader struct {
+	mem     *virtualMemory
+	order   binary.ByteOrder
+	ptrSize int
+	version GoVersion
+
+	types, etypes uint64
+	seen          map[uint64]*GoType
+}
+
+func (r *typeReader) word(addr uint64) (uint64, error) {
+	b, err := r.mem.read(addr, uint64(r.ptrSize))
+	if err != nil {
+		return 0, err
+	}
+	return readUint(b, r.ptrSize, r.order), nil
+}
+
+func (r *typeReader) uint32(addr uint64) (uint32, error) {
+	b, err := r.mem.read(addr, 4)
+	if err != nil {
+		return 0, err
+	}
+	return r.order.Uint32(b), nil
+}
+
+// validSlice reports whether the three words are a plausible slice header of mapped data.
+func (r *typeReader) validSlice(words []uint64) bool {
+	if words[1] != words[2] {
+		return false
+	}
+	if words[1] == 0 {
+		return true
+	}
+	_, err := r.mem.read(words[0], words[1])
+	return err == nil
+}
+
+// findModuledata returns the address of runtime.firstmoduledata.
+func (r *typeReader) findModuledata(f *elf.File, tab *GoPclntab) (uint64, error) {
+	if syms, err := f.Symbols(); err == nil {
+		for _, s := range syms {
+			if s.Name == "runtime.firstmoduledata" {
+				return s.Value, nil
+			}
+		}
+	}
+
+	// The moduledata starts with a pointer to the pclntab header, followed by the funcnametab
+	// slice which points into the pclntab.
+	var want [8]byte
+	if r.ptrSize == 4 {
+		r.order.PutUint32(want[:], uint32(tab.Addr))
+	} else {
+		r.order.PutUint64(want[:], tab.Addr)
+	}
+	for _, s := range r.mem.segments {
+		for off := 0; off+r.ptrSize*4 <= len(s.data); off += r.ptrSize {
+			if string(s.data[off:off+r.ptrSize]) != string(want[:r.ptrSize]) {
+				continue
+			}
+			names := readUint(s.data[off+r.ptrSize:], r.ptrSize, r.order)
+			if names > tab.Addr && names < tab.Addr+uint64(len(tab.Data)) {
+				return s.addr + uint64(off), nil
+			}
+		}
+	}
+	return 0, errors.New("failed to find runtime.firstmoduledata")
+}
+
+// readName decodes a runtime name (internal/abi.Name) and returns the name,
+// whether it's embedded and the address following the tag.
+func (r *typeReader) readName(addr uint64) (string, bool, error) {
+	hdr, err := r.mem.read(addr, 1+binary.MaxVarintLen16)
+	if err != nil {
+		// The name may be at the very end of a segment.
+		if hdr, err = r.mem.read(addr, 3); err != nil {
+			return "", false, err
+		}
+	}
+	flags := hdr[0]
+
+	var n, l uint64
+	if r.version.AtLeast(1, 17) {
+		var size int
+		n, size = binary.Uvarint(hdr[1:])
+		if size <= 0 {
+			return "", false, errors.New("invalid name length")
+		}
+		l = uint64(size)
+	} else {
+		n, l = uint64(binary.BigEndian.Uint16(hdr[1:])), 2
+	}
+	b, err := r.mem.read(addr+1+l, n)
+	if err != nil {
+		return "", false, err
+	}
+	return string(b), flags&0x8 != 0, nil
+}
+
+// typeName returns the name of the type descriptor at addr.
+func (r *typeReader) typeName(addr uint64) (string, error) {
+	t, err := r.readType(addr)
+	if err != nil {
+		return "", err
+	}
+	return t.Name, nil
+}
+
+// readType decodes the type descriptor at addr and the types it refers to.
+//
+// The common part of a descriptor is: size, ptrdata uintptr | hash uint32 | tflag, align,
+// fieldAlign, kind uint8 | equal, gcdata uintptr | str, ptrToThis int32.
+// It's followed by kind specific data and, for types with methods, the uncommon type.
+func (r *typeReader) readType(addr uint64) (*GoType, error) {
+	if t, ok := r.seen[addr]; ok {
+		return t, nil
+	}
+
+	w := uint64(r.ptrSize)
+	hdrSize := 4*w + 16
+	hdr, err := r.mem.read(addr, hdrSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read type at %#x: %w", addr, err)
+	}
+
+	t := &GoType{
+		Addr:  addr,
+		Size:  readUint(hdr, r.ptrSize, r.order),
+		Align: hdr[2*w+5],
+		Kind:  reflect.Kind(hdr[2*w+7] & 0x1f),
+	}
+	tflag := hdr[2*w+4]
+	t.Named = tflag&tflagNamed != 0
+	r.seen[addr] = t
+
+	name, _, err := r.readName(r.types + uint64(r.order.Uint32(hdr[4*w+8:])))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read name of type at %#x: %w", addr, err)
+	}
+	if tflag&tflagExtraStar != 0 {
+		name = strings.TrimPrefix(name, "*")
+	}
+	t.Name = name
+
+	data := addr + hdrSize
+	var extra uint64
+	switch t.Kind {
+	case reflect.Array:
+		// elem, slice *_type | len uintptr
+		t.Elem, err = r.elemName(data)
+		extra = 3 * w
+	case reflect.Chan:
+		// elem *_type | dir uintptr
+		t.Elem, err = r.elemName(data)
+		extra = 2 * w
+	case reflect.Pointer, reflect.Slice:
+		t.Elem, err = r.elemName(data)
+		extra = w
+	case reflect.Map:
+		// key, elem, ... Only the element is recorded, the uncommon type isn't located.
+		t.Elem, err = r.elemName(data + w)
+		extra = 0
+		tflag &^= tflagUncommon
+	case reflect.Func:
+		// inCount, outCount uint16, padded to the pointer size.
+		extra = w
+	case reflect.Interface:
+		// pkgPath name | methods []imethod
+		err = r.readInterface(t, data)
+		extra = 4 * w
+	case reflect.Struct:
+		// pkgPath name | fields []structField
+		err = r.readStruct(t, data)
+		extra = 4 * w
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if tflag&tflagUncommon != 0 {
+		// uncommon: pkgPath nameOff | mcount, xcount uint16 | moff, _ uint32
+		if off, err := r.uint32(data + extra); err == nil && off != 0 {
+			t.PkgPath, _, _ = r.readName(r.types + uint64(off))
+		}
+	}
+	return t, nil
+}
+
+func (r *typeReader) elemName(addr uint64) (string, error) {
+	elem, err := r.word(addr)
+	if err != nil {
+		return "", err
+	}
+	return r.typeName(elem)
+}
+
+func (r *typeReader) readStruct(t *GoType, data uint64) error {
+	w := uint64(r.ptrSize)
+	fields, err := r.word(data + w)
+	if err != nil {
+		return err
+	}
+	n, err := r.word(data + 2*w)
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		// name name | typ *_type | offset uintptr. Before Go 1.19 the offset was shifted left by one,
+		// the lowest bit telling whether the field is embedded.
+		field := fields + i*3*w
+		nameAddr, err := r.word(field)
+		if err != nil {
+			return err
+		}
+		typ, err := r.word(field + w)
+		if err != nil {
+			return err
+		}
+		offset, err := r.word(field + 2*w)
+		if err != nil {
+			return err
+		}
+
+		name, embedded, err := r.readName(nameAddr)
+		if err != nil {
+			return err
+		}
+		if !r.version.AtLeast(1, 19) {
+			embedded = offset&1 != 0
+			offset >>= 1
+		}
+		typeName, err := r.typeName(typ)
+		if err != nil {
+			return err
+		}
+		t.Fields = append(t.Fields, GoStructField{Name: name, Type: typeName, Offset: offset, Embedded: embedded})
+	}
+	return nil
+}
+
+func (r *typeReader) readInterface(t *GoType, data uint64) error {
+	w := uint64(r.ptrSize)
+	methods, err := r.word(data + w)
+	if err != nil {
+		return err
+	}
+	n, err := r.word(data + 2*w)
+	if err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		// name nameOff | typ typeOff
+		off, err := r.uint32(methods + i*8)
+		if err != nil {
+			return err
+		}
+		name, _, err := r.readName(r.types + uint64(off))
+		if err != nil {
+			return err
+		}
+		t.Methods = append(t.Methods, name)
+	}
+	return nil
+}
+
+// readItab decodes an itab: inter *interfacetype | _type *_type | hash uint32, padding | fun [n]uintptr.
+func (r *typeReader) readItab(addr uint64) (GoItab, error) {
+	w := uint64(r.ptrSize)
+	inter, err := r.word(addr)
+	if err != nil {
+		return GoItab{}, fmt.Errorf("failed to read itab at %#x: %w", addr, err)
+	}
+	typ, err := r.word(addr + w)
+	if err != nil {
+		return GoItab{}, fmt.Errorf("failed to read itab at %#x: %w", addr, err)
+	}
+
+	it, err := r.readType(inter)
+	if err != nil {
+		return GoItab{}, err
+	}
+	tt, err := r.readType(typ)
+	if err != nil {
+		return GoItab{}, err
+	}
+
+	itab := GoItab{Addr: addr, Interface: it.Name, Type: tt.Name}
+	for i := range it.Methods {
+		fn, err := r.word(addr + 2*w + 8 + uint64(i)*w)
+		if err != nil {
+			return GoItab{}, err
+		}
+		itab.Funcs = append(itab.Funcs, fn)
+	}
+	return itab, nil
+}
@@ -0,0 +1,268 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfutils
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// GoVersionConfidence tells how reliable a detected Go version is.
+type GoVersionConfidence int
+
+const (
+	// ConfidenceLow means only the version range could be inferred,
+	// e.g. from the pclntab format.
+	ConfidenceLow GoVersionConfidence = iota
+	// ConfidenceMedium means the version was found by scanning the binary for version strings.
+	ConfidenceMedium
+	// ConfidenceHigh means the version was read from the build information or runtime.buildVersion.
+	ConfidenceHigh
+)
+
+func (c GoVersionConfidence) String() string {
+	switch c {
+	case ConfidenceLow:
+		return "low"
+	case ConfidenceMedium:
+		return "medium"
+	case ConfidenceHigh:
+		return "high"
+	default:
+		return fmt.Sprintf("GoVersionConfidence(%d)", int(c))
+	}
+}
+
+// GoVersion is the version of the Go toolchain that built a binary.
+type GoVersion struct {
+	Major, Minor, Patch int
+	// Raw is the version string as found in the binary, e.g. "go1.21.3", or a description
+	// of the inferred range, e.g. "go1.18-go1.19".
+	Raw        string
+	Source     string
+	Confidence GoVersionConfidence
+}
+
+func (v GoVersion) String() string {
+	return fmt.Sprintf("%s (source: %s, confidence: %s)", v.Raw, v.Source, v.Confidence)
+}
+
+// AtLeast reports whether v is at least go<major>.<minor>.
+func (v GoVersion) AtLeast(major, minor int) bool {
+	return v.Major > major || v.Major == major && v.Minor >= minor
+}
+
+var goVersionRegexp = regexp.MustCompile(`^go(\d+)\.(\d+)(?:\.(\d+))?`)
+
+// ParseGoVersion parses a Go version string such as "go1.21.3" or "go1.22rc1".
+func ParseGoVersion(s string) (GoVersion, error) {
+	m := goVersionRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return GoVersion{}, fmt.Errorf("invalid Go version %q", s)
+	}
+	v := GoVersion{Raw: s}
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.Patch, _ = strconv.Atoi(m[3])
+	}
+	return v, nil
+}
+
+// DetectGoVersion finds the version of the Go toolchain that built f.
+//
+// It tries, in order, the .go.buildinfo section, the runtime.buildVersion symbol,
+// version strings in the read-only data and the pclntab format. The last resort is the
+// Go build ID note, which only tells that f was built by the Go toolchain.
+func DetectGoVersion(f *elf.File) (GoVersion, error) {
+	return detectGoVersion(f, nil)
+}
+
+// MapGoVersion detects the version like DetectGoVersion, but the symbol table and the
+// read-only data are read from mapped, the contents of the whole file, e.g. memory mapped
+// with MmapFile, instead of being copied to the heap.
+func MapGoVersion(f *elf.File, mapped []byte) (GoVersion, error) {
+	if mapped == nil {
+		return GoVersion{}, errors.New("no mapped data")
+	}
+	return detectGoVersion(f, mapped)
+}
+
+func detectGoVersion(f *elf.File, mapped []byte) (GoVersion, error) {
+	if s, err := buildInfoVersion(f, mapped); err == nil {
+		if v, err := ParseGoVersion(s); err == nil {
+			v.Source, v.Confidence = "buildinfo", ConfidenceHigh
+			return v, nil
+		}
+	}
+
+	if s, err := buildVersionSymbol(f, mapped); err == nil {
+		if v, err := ParseGoVersion(s); err == nil {
+			v.Source, v.Confidence = "runtime.buildVersion", ConfidenceHigh
+			return v, nil
+		}
+	}
+
+	if s, ok := scanGoVersionString(f, mapped); ok {
+		if v, err := ParseGoVersion(s); err == nil {
+			v.Source, v.Confidence = "version string", ConfidenceMedium
+			return v, nil
+		}
+	}
+
+	if tab, err := findGoPclntab(f, mapped); err == nil {
+		v := GoVersion{Major: 1, Source: "pclntab magic", Confidence: ConfidenceLow}
+		switch tab.Magic {
+		case GoPclntabMagic12:
+			v.Minor, v.Raw = 2, "go1.2-go1.15"
+		case GoPclntabMagic116:
+			v.Minor, v.Raw = 16, "go1.16-go1.17"
+		case GoPclntabMagic118:
+			v.Minor, v.Raw = 18, "go1.18-go1.19"
+		case GoPclntabMagic120:
+			v.Minor, v.Raw = 20, "go1.20+"
+		}
+		return v, nil
+	}
+
+	if hasGoBuildIDNote(f) {
+		return GoVersion{Major: 1, Raw: "go1", Source: "build id note", Confidence: ConfidenceLow}, nil
+	}
+
+	return GoVersion{}, errors.New("failed to detect Go version")
+}
+
+// buildInfoMagic is the header of the .go.buildinfo section.
+var buildInfoMagic = []byte("\xff Go buildinf:")
+
+// buildInfoVersion reads the Go version from the .go.buildinfo section,
+// the same way as debug/buildinfo does.
+func buildInfoVersion(f *elf.File, mapped []byte) (string, error) {
+	sec := f.Section(".go.buildinfo")
+	if sec == nil {
+		return "", errors.New("no .go.buildinfo section")
+	}
+	data, err := sectionData(sec, mapped)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < 32 || !bytes.HasPrefix(data, buildInfoMagic) {
+		return "", errors.New("invalid .go.buildinfo header")
+	}
+
+	ptrSize, flags := int(data[14]), data[15]
+	if flags&0x2 != 0 {
+		// Go 1.18+: the version is stored inline as a varint-prefixed string.
+		n, l := binary.Uvarint(data[32:])
+		if l <= 0 || uint64(len(data)-32-l) < n {
+			return "", errors.New("invalid inline version string")
+		}
+		return string(data[32+l : 32+l+int(n)]), nil
+	}
+
+	// Older versions store a pointer to the version string header.
+	var order binary.ByteOrder = binary.LittleEndian
+	if flags&0x1 != 0 {
+		order = binary.BigEndian
+	}
+	if ptrSize != 4 && ptrSize != 8 {
+		return "", fmt.Errorf("invalid pointer size %d", ptrSize)
+	}
+	ptr := readUint(data[16:], ptrSize, order)
+	return readGoString(f, ptr, ptrSize, order)
+}
+
+// buildVersionSymbol reads the runtime.buildVersion string variable.
+func buildVersionSymbol(f *elf.File, mapped []byte) (string, error) {
+	addr, ok := lookupSymbols(f, mapped, "runtime.buildVersion")["runtime.buildVersion"]
+	if !ok {
+		return "", errors.New("no runtime.buildVersion symbol")
+	}
+	return readGoString(f, addr, ptrSize(f), f.ByteOrder)
+}
+
+// readGoString reads the string whose header (data pointer, length) is at addr.
+func readGoString(f *elf.File, addr uint64, ptrSize int, order binary.ByteOrder) (string, error) {
+	hdr, err := readVirtual(f, addr, uint64(2*ptrSize))
+	if err != nil {
+		return "", err
+	}
+	ptr, n := readUint(hdr, ptrSize, order), readUint(hdr[ptrSize:], ptrSize, order)
+	if n == 0 || n > 128 {
+		return "", fmt.Errorf("invalid string length %d", n)
+	}
+	b, err := readVirtual(f, ptr, n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+var goVersionStringRegexp = regexp.MustCompile(`go1\.\d{1,2}(?:\.\d{1,2})?(?:(?:rc|beta)\d+)?(?:[ \x00]|X:)`)
+
+// scanGoVersionString looks for the runtime.buildVersion string data in the read-only segments.
+// The highest version found wins, since the runtime refers to older versions in messages.
+func scanGoVersionString(f *elf.File, mapped []byte) (string, bool) {
+	var best string
+	var bestVersion GoVersion
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Flags&elf.PF_W != 0 || prog.Flags&elf.PF_X != 0 {
+			continue
+		}
+		data, err := segmentData(prog, mapped)
+		if err != nil {
+			continue
+		}
+		for _, m := range goVersionStringRegexp.FindAll(data, -1) {
+			s := string(bytes.TrimRight(bytes.TrimSuffix(m, []byte("X:")), " \x00"))
+			v, err := ParseGoVersion(s)
+			if err != nil {
+				continue
+			}
+			if best == "" || v.AtLeast(bestVersion.Major, bestVersion.Minor) && (v.Minor > bestVersion.Minor || v.Patch > bestVersion.Patch) {
+				best, bestVersion = s, v
+			}
+		}
+	}
+	return best, best != ""
+}
+
+// hasGoBuildIDNote reports whether f has the note written by the Go linker.
+func hasGoBuildIDNote(f *elf.File) bool {
+	if sec := f.Section(".note.go.buildid"); sec != nil {
+		return true
+	}
+	found := false
+	forEachNote(f, func(name string, typ uint32, _ []byte) bool {
+		found = name == "Go" && typ == noteGoBuildID
+		return !found
+	})
+	return found
+}
+
+func align4(n uint32) uint32 {
+	return (n + 3) &^ 3
+}
+
+func readUint(b []byte, size int, order binary.ByteOrder) uint64 {
+	if size == 4 {
+		return uint64(order.Uint32(b))
+	}
+	return order.Uint64(b)
+}
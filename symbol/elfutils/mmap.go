@@ -0,0 +1,64 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package elfutils
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// MappedFile is a file mapped read-only in memory.
+type MappedFile struct {
+	Data []byte
+}
+
+// MmapFile maps the whole file in memory. The pages are loaded by the kernel on access
+// and can be reclaimed under memory pressure, unlike a copy in the heap.
+func MmapFile(filename string) (*MappedFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		return &MappedFile{}, nil
+	}
+	if int64(int(fi.Size())) != fi.Size() {
+		return nil, fmt.Errorf("file %s is too large to be mapped", filename)
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(fi.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mmap %s: %w", filename, err)
+	}
+	return &MappedFile{Data: data}, nil
+}
+
+// Close unmaps the file. Data must not be used afterwards.
+func (m *MappedFile) Close() error {
+	if m.Data == nil {
+		return nil
+	}
+	data := m.Data
+	m.Data = nil
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,38 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !unix
+
+package elfutils
+
+import "os"
+
+// MappedFile is a file loaded in memory.
+type MappedFile struct {
+	Data []byte
+}
+
+// MmapFile reads the whole file, memory mapping isn't supported on this platform.
+func MmapFile(filename string) (*MappedFile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &MappedFile{Data: data}, nil
+}
+
+// Close releases the data.
+func (m *MappedFile) Close() error {
+	m.Data = nil
+	return nil
+}
@@ -0,0 +1,579 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfutils
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/go-delve/delve/pkg/dwarf/godwarf"
+	"github.com/go-delve/delve/pkg/dwarf/leb128"
+	"github.com/go-delve/delve/pkg/dwarf/regnum"
+)
+
+// DWARF location expression operations, see the DWARF 5 standard, section 2.5 and 2.6.
+const (
+	opConsts       = 0x11
+	opPlus         = 0x22
+	opPlusUconst   = 0x23
+	opReg0         = 0x50
+	opReg31        = 0x6f
+	opBreg0        = 0x70
+	opBreg31       = 0x8f
+	opRegx         = 0x90
+	opFbreg        = 0x91
+	opBregx        = 0x92
+	opPiece        = 0x93
+	opCallFrameCFA = 0x9c
+)
+
+// DWARF 5 location list entry kinds, DW_LLE_*.
+const (
+	lleEndOfList       = 0x00
+	lleBaseAddressx    = 0x01
+	lleStartxEndx      = 0x02
+	lleStartxLength    = 0x03
+	lleOffsetPair      = 0x04
+	lleDefaultLocation = 0x05
+	lleBaseAddress     = 0x06
+	lleStartEnd        = 0x07
+	lleStartLength     = 0x08
+)
+
+// LocationKind tells how a piece of a value is located.
+type LocationKind uint8
+
+const (
+	// LocationUnknown is a location expression that isn't decoded, see Location.Expr.
+	LocationUnknown LocationKind = iota
+	// LocationRegister is a value held in Register.
+	LocationRegister
+	// LocationRegisterOffset is a value in memory at the address in Register plus Offset.
+	LocationRegisterOffset
+	// LocationFrameBase is a value in memory at the frame base of the function plus Offset.
+	// The frame base of the functions built by the Go and C compilers is the CFA.
+	LocationFrameBase
+	// LocationCFA is a value in memory at the canonical frame address plus Offset,
+	// i.e. the stack pointer before the call plus Offset.
+	LocationCFA
+	// LocationAddress is a value in memory at the static address Offset.
+	LocationAddress
+	// LocationOptimizedOut is a value, or a piece of it, that isn't available.
+	LocationOptimizedOut
+)
+
+func (k LocationKind) String() string {
+	switch k {
+	case LocationRegister:
+		return "register"
+	case LocationRegisterOffset:
+		return "register offset"
+	case LocationFrameBase:
+		return "frame base"
+	case LocationCFA:
+		return "cfa"
+	case LocationAddress:
+		return "address"
+	case LocationOptimizedOut:
+		return "optimized out"
+	}
+	return "unknown"
+}
+
+// LocationPiece is where a value, or a piece of it, is.
+type LocationPiece struct {
+	Kind LocationKind
+	// Register is the DWARF number of the register, see RegisterName.
+	Register uint64
+	Offset   int64
+	// Size is the size in bytes of the piece of a value split across several locations,
+	// e.g. the pointer and the length of a Go string passed in two registers.
+	// It's 0 for a value in a single location.
+	Size uint64
+}
+
+// Location is the location of a value in an address interval.
+type Location struct {
+	// LowPC and HighPC are the address interval [LowPC, HighPC) in which the location is valid.
+	// Both are 0 for a location valid in the whole function.
+	LowPC, HighPC uint64
+	Pieces        []LocationPiece
+	// Expr is the raw location expression.
+	Expr []byte
+}
+
+// Parameter is a formal parameter of a function, described by a DW_TAG_formal_parameter entry.
+type Parameter struct {
+	Name string
+	Type dwarf.Type
+	// Result is set for the named or unnamed, e.g. "~r0", results of Go functions.
+	Result bool
+	// Locations are empty if the parameter has no location, e.g. when it's unused.
+	Locations []Location
+}
+
+// LocationAt returns the location of the parameter at pc.
+func (p Parameter) LocationAt(pc uint64) (Location, bool) {
+	for _, loc := range p.Locations {
+		if loc.LowPC == 0 && loc.HighPC == 0 || loc.LowPC <= pc && pc < loc.HighPC {
+			return loc, true
+		}
+	}
+	return Location{}, false
+}
+
+// RegisterName returns the name of the DWARF register number reg of the machine,
+// e.g. "Rax" for register 0 of x86-64.
+func RegisterName(machine elf.Machine, reg uint64) string {
+	switch machine {
+	case elf.EM_X86_64:
+		return regnum.AMD64ToName(reg)
+	case elf.EM_AARCH64:
+		return regnum.ARM64ToName(reg)
+	case elf.EM_386:
+		return regnum.I386ToName(reg)
+	}
+	return fmt.Sprintf("r%d", reg)
+}
+
+// FunctionParameters returns the formal parameters, in declaration order, of the function
+// named name, with its DW_AT_name or DW_AT_linkage_name, e.g. "main.handle" or "_ZN1a1bEv".
+// The locations come from the DWARF data, so they follow the calling convention the function
+// was compiled with: ABIInternal for Go functions, the System V ABI for C functions.
+func FunctionParameters(f *elf.File, debugData *dwarf.Data, name string) ([]Parameter, error) {
+	d, err := newParamDecoder(f, debugData)
+	if err != nil {
+		return nil, err
+	}
+
+	r := debugData.Reader()
+	var cu *dwarf.Entry
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, fmt.Errorf("read DWARF entry: %w", err)
+		}
+		if e == nil {
+			break
+		}
+		switch e.Tag {
+		case dwarf.TagCompileUnit, dwarf.TagPartialUnit:
+			cu = e
+			continue
+		case dwarf.TagSubprogram:
+		default:
+			continue
+		}
+
+		origin := d.origin(e)
+		subName, _ := origin.Val(dwarf.AttrName).(string)
+		linkageName, _ := origin.Val(dwarf.AttrLinkageName).(string)
+		if subName != name && linkageName != name {
+			continue
+		}
+		if ranges, err := debugData.Ranges(e); err != nil || len(ranges) == 0 {
+			// The declaration or the abstract instance of an inlined function.
+			continue
+		}
+		if !e.Children {
+			return nil, nil
+		}
+		return d.parameters(r, cu)
+	}
+	return nil, fmt.Errorf("function %s not found", name)
+}
+
+// paramDecoder decodes the parameters of functions and their location lists.
+type paramDecoder struct {
+	debugData *dwarf.Data
+	order     binary.ByteOrder
+	addrSize  int
+
+	loc       []byte
+	loclists  []byte
+	debugAddr *godwarf.DebugAddrSection
+	// versions are the versions of the units, by offset of their unit entry.
+	versions map[dwarf.Offset]uint16
+}
+
+func newParamDecoder(f *elf.File, debugData *dwarf.Data) (*paramDecoder, error) {
+	d := &paramDecoder{
+		debugData: debugData,
+		order:     f.ByteOrder,
+		addrSize:  8,
+	}
+	if f.Class == elf.ELFCLASS32 {
+		d.addrSize = 4
+	}
+
+	sections := map[string]*[]byte{".debug_loc": &d.loc, ".debug_loclists": &d.loclists}
+	for name, data := range sections {
+		if sec := f.Section(name); sec != nil {
+			b, err := sec.Data()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s section: %w", name, err)
+			}
+			*data = b
+		}
+	}
+	if sec := f.Section(".debug_addr"); sec != nil {
+		b, err := sec.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read .debug_addr section: %w", err)
+		}
+		d.debugAddr = godwarf.ParseAddr(b)
+	}
+	if sec := f.Section(".debug_info"); sec != nil {
+		b, err := sec.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read .debug_info section: %w", err)
+		}
+		d.versions = unitVersions(b, f.ByteOrder)
+	}
+	return d, nil
+}
+
+// unitVersions reads the headers of the units of .debug_info.
+func unitVersions(info []byte, order binary.ByteOrder) map[dwarf.Offset]uint16 {
+	versions := make(map[dwarf.Offset]uint16)
+	for off := 0; off+4 <= len(info); {
+		length, offSize, hdr := uint64(order.Uint32(info[off:])), 4, 4
+		if length == 0xffffffff {
+			if off+12 > len(info) {
+				break
+			}
+			length, offSize, hdr = order.Uint64(info[off+4:]), 8, 12
+		}
+		if off+hdr+2 > len(info) {
+			break
+		}
+		version := order.Uint16(info[off+hdr:])
+		size := hdr + 2 + offSize + 1 // abbrev offset and address size
+		if version >= 5 {
+			size = hdr + 2 + 1 + 1 + offSize // unit type, address size and abbrev offset
+			switch info[off+hdr+2] {
+			case 0x02, 0x06: // DW_UT_type, DW_UT_split_type
+				size += 8 + offSize
+			case 0x04, 0x05: // DW_UT_skeleton, DW_UT_split_compile
+				size += 8
+			}
+		}
+		versions[dwarf.Offset(off+size)] = version
+
+		next := uint64(off) + uint64(hdr) + length
+		if next > uint64(len(info)) {
+			break
+		}
+		off = int(next)
+	}
+	return versions
+}
+
+// origin returns the abstract origin of e, which holds the name and the type
+// of the concrete instances of inlined functions and of their parameters.
+func (d *paramDecoder) origin(e *dwarf.Entry) *dwarf.Entry {
+	off, ok := e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+	if !ok {
+		return e
+	}
+	r := d.debugData.Reader()
+	r.Seek(off)
+	origin, err := r.Next()
+	if err != nil || origin == nil {
+		return e
+	}
+	return origin
+}
+
+// parameters reads the parameters among the children of the subprogram just read by r.
+func (d *paramDecoder) parameters(r *dwarf.Reader, cu *dwarf.Entry) ([]Parameter, error) {
+	var params []Parameter
+	for {
+		e, err := r.Next()
+		if err != nil {
+			return nil, fmt.Errorf("read DWARF entry: %w", err)
+		}
+		if e == nil || e.Tag == 0 {
+			return params, nil
+		}
+		if e.Children {
+			r.SkipChildren()
+		}
+		if e.Tag != dwarf.TagFormalParameter {
+			continue
+		}
+
+		origin := d.origin(e)
+		p := Parameter{}
+		p.Name, _ = origin.Val(dwarf.AttrName).(string)
+		p.Result, _ = origin.Val(dwarf.AttrVarParam).(bool)
+		if typOff, ok := origin.Val(dwarf.AttrType).(dwarf.Offset); ok {
+			if typ, err := d.debugData.Type(typOff); err == nil {
+				p.Type = typ
+			}
+		}
+
+		field := e.AttrField(dwarf.AttrLocation)
+		switch {
+		case field == nil:
+		case field.Class == dwarf.ClassExprLoc:
+			expr, _ := field.Val.([]byte)
+			if len(expr) > 0 {
+				p.Locations = []Location{d.location(0, 0, expr)}
+			}
+		case field.Class == dwarf.ClassLocListPtr || field.Class == dwarf.ClassLocList:
+			var off uint64
+			switch v := field.Val.(type) {
+			case int64: // DW_FORM_sec_offset
+				off = uint64(v)
+			case uint64: // DW_FORM_loclistx
+				off = v
+			}
+			locs, err := d.locationList(cu, field.Class, off)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read location list of %s: %w", p.Name, err)
+			}
+			p.Locations = locs
+		}
+		params = append(params, p)
+	}
+}
+
+func (d *paramDecoder) location(low, high uint64, expr []byte) Location {
+	return Location{LowPC: low, HighPC: high, Pieces: decodeLocation(expr, d.order, d.addrSize), Expr: expr}
+}
+
+// locationList decodes the location list at off, or with the index off for DW_FORM_loclistx,
+// of an entry of the compile unit cu.
+func (d *paramDecoder) locationList(cu *dwarf.Entry, class dwarf.Class, off uint64) ([]Location, error) {
+	var base uint64
+	if cu != nil {
+		// debug/dwarf resolves the indexes of DW_FORM_addrx.
+		base, _ = cu.Val(dwarf.AttrLowpc).(uint64)
+	}
+	if cu == nil || d.versions[cu.Offset] < 5 {
+		return d.locList4(off, base)
+	}
+
+	if class == dwarf.ClassLocList {
+		// The index selects an offset, relative to DW_AT_loclists_base, of the offset table.
+		listsBase, _ := cu.Val(dwarf.AttrLoclistsBase).(int64)
+		pos := uint64(listsBase) + off*4
+		if pos+4 > uint64(len(d.loclists)) {
+			return nil, errors.New("location list index out of range")
+		}
+		off = uint64(listsBase) + uint64(d.order.Uint32(d.loclists[pos:]))
+	}
+	var debugAddr *godwarf.DebugAddr
+	if d.debugAddr != nil {
+		addrBase, _ := cu.Val(dwarf.AttrAddrBase).(int64)
+		debugAddr = d.debugAddr.GetSubsection(uint64(addrBase))
+	}
+	return d.locList5(off, base, debugAddr)
+}
+
+// locList4 decodes a location list of .debug_loc, used up to DWARF 4.
+func (d *paramDecoder) locList4(off, base uint64) ([]Location, error) {
+	if off >= uint64(len(d.loc)) {
+		return nil, errors.New("location list offset out of range")
+	}
+	maxAddr := uint64(math.MaxUint64)
+	if d.addrSize == 4 {
+		maxAddr = math.MaxUint32
+	}
+
+	var locs []Location
+	buf := d.loc[off:]
+	for {
+		if len(buf) < 2*d.addrSize {
+			return nil, errors.New("truncated location list")
+		}
+		start, end := d.addr(buf), d.addr(buf[d.addrSize:])
+		buf = buf[2*d.addrSize:]
+		switch {
+		case start == 0 && end == 0:
+			return locs, nil
+		case start == maxAddr:
+			base = end
+			continue
+		}
+		if len(buf) < 2 {
+			return nil, errors.New("truncated location list")
+		}
+		n := int(d.order.Uint16(buf))
+		if len(buf) < 2+n {
+			return nil, errors.New("truncated location list")
+		}
+		locs = append(locs, d.location(base+start, base+end, buf[2:2+n]))
+		buf = buf[2+n:]
+	}
+}
+
+// locList5 decodes a location list of .debug_loclists, used since DWARF 5.
+func (d *paramDecoder) locList5(off, base uint64, debugAddr *godwarf.DebugAddr) ([]Location, error) {
+	if off >= uint64(len(d.loclists)) {
+		return nil, errors.New("location list offset out of range")
+	}
+	buf := bytes.NewBuffer(d.loclists[off:])
+	uleb := func() uint64 {
+		v, _ := leb128.DecodeUnsigned(buf)
+		return v
+	}
+	addr := func() uint64 {
+		return d.addr(buf.Next(d.addrSize))
+	}
+	addrx := func() (uint64, error) {
+		if debugAddr == nil {
+			return 0, errors.New("no .debug_addr section")
+		}
+		return debugAddr.Get(uleb())
+	}
+
+	var locs []Location
+	for buf.Len() > 0 {
+		var (
+			start, end uint64
+			err        error
+		)
+		kind, _ := buf.ReadByte()
+		switch kind {
+		case lleEndOfList:
+			return locs, nil
+		case lleBaseAddressx:
+			base, err = addrx()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case lleBaseAddress:
+			base = addr()
+			continue
+		case lleStartxEndx:
+			if start, err = addrx(); err == nil {
+				end, err = addrx()
+			}
+		case lleStartxLength:
+			start, err = addrx()
+			end = start + uleb()
+		case lleOffsetPair:
+			start = base + uleb()
+			end = base + uleb()
+		case lleDefaultLocation:
+		case lleStartEnd:
+			start = addr()
+			end = addr()
+		case lleStartLength:
+			start = addr()
+			end = start + uleb()
+		default:
+			return nil, fmt.Errorf("unknown location list entry kind %#x", kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		n := uleb()
+		if n > uint64(buf.Len()) {
+			return nil, errors.New("truncated location list")
+		}
+		locs = append(locs, d.location(start, end, buf.Next(int(n))))
+	}
+	return nil, errors.New("truncated location list")
+}
+
+func (d *paramDecoder) addr(b []byte) uint64 {
+	if len(b) < d.addrSize {
+		return 0
+	}
+	if d.addrSize == 4 {
+		return uint64(d.order.Uint32(b))
+	}
+	return d.order.Uint64(b)
+}
+
+// decodeLocation decodes the location expressions made of registers, register or frame
+// relative addresses and static addresses, possibly split in pieces with DW_OP_piece.
+// Other expressions, e.g. computed values, are a single piece of kind LocationUnknown.
+func decodeLocation(expr []byte, order binary.ByteOrder, addrSize int) []LocationPiece {
+	unknown := []LocationPiece{{Kind: LocationUnknown}}
+
+	var (
+		pieces []LocationPiece
+		cur    = LocationPiece{Kind: LocationOptimizedOut}
+		buf    = bytes.NewBuffer(expr)
+	)
+	uleb := func() uint64 {
+		v, _ := leb128.DecodeUnsigned(buf)
+		return v
+	}
+	sleb := func() int64 {
+		v, _ := leb128.DecodeSigned(buf)
+		return v
+	}
+
+	for buf.Len() > 0 {
+		op, _ := buf.ReadByte()
+		switch {
+		case opReg0 <= op && op <= opReg31:
+			cur = LocationPiece{Kind: LocationRegister, Register: uint64(op - opReg0)}
+		case op == opRegx:
+			cur = LocationPiece{Kind: LocationRegister, Register: uleb()}
+		case opBreg0 <= op && op <= opBreg31:
+			cur = LocationPiece{Kind: LocationRegisterOffset, Register: uint64(op - opBreg0), Offset: sleb()}
+		case op == opBregx:
+			reg := uleb()
+			cur = LocationPiece{Kind: LocationRegisterOffset, Register: reg, Offset: sleb()}
+		case op == opFbreg:
+			cur = LocationPiece{Kind: LocationFrameBase, Offset: sleb()}
+		case op == opCallFrameCFA:
+			cur = LocationPiece{Kind: LocationCFA}
+		case op == opPlusUconst && cur.Kind != LocationOptimizedOut && cur.Kind != LocationRegister:
+			cur.Offset += int64(uleb())
+		case op == opConsts && cur.Kind != LocationOptimizedOut && cur.Kind != LocationRegister:
+			// DW_OP_consts n; DW_OP_plus
+			n := sleb()
+			if next, err := buf.ReadByte(); err != nil || next != opPlus {
+				return unknown
+			}
+			cur.Offset += n
+		case op == opAddr:
+			if buf.Len() < addrSize {
+				return unknown
+			}
+			b := buf.Next(addrSize)
+			var a uint64
+			if addrSize == 4 {
+				a = uint64(order.Uint32(b))
+			} else {
+				a = order.Uint64(b)
+			}
+			cur = LocationPiece{Kind: LocationAddress, Offset: int64(a)}
+		case op == opPiece:
+			cur.Size = uleb()
+			pieces = append(pieces, cur)
+			cur = LocationPiece{Kind: LocationOptimizedOut}
+		default:
+			return unknown
+		}
+	}
+	if len(pieces) == 0 {
+		return []LocationPiece{cur}
+	}
+	return pieces
+}
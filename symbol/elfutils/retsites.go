@@ -0,0 +1,104 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfutils
+
+import (
+	"debug/elf"
+	"fmt"
+
+	"golang.org/x/arch/arm64/arm64asm"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// ReturnAddrs disassembles the code in [start, end), usually the body of a function,
+// and returns the addresses of its return instructions. Only x86-64 and arm64 are supported.
+//
+// Uretprobes corrupt the stacks of goroutines, which the Go runtime moves, so Go
+// functions are traced by attaching uprobes to their return instructions instead.
+func ReturnAddrs(f *elf.File, start, end uint64) ([]uint64, error) {
+	if end <= start {
+		return nil, fmt.Errorf("invalid address range [%#x, %#x)", start, end)
+	}
+	code, err := readCode(f, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var offs []uint64
+	switch f.Machine {
+	case elf.EM_X86_64:
+		offs = x86ReturnOffsets(code)
+	case elf.EM_AARCH64:
+		offs = arm64ReturnOffsets(code)
+	default:
+		return nil, fmt.Errorf("unsupported machine %s", f.Machine)
+	}
+
+	addrs := make([]uint64, len(offs))
+	for i, off := range offs {
+		addrs[i] = start + off
+	}
+	return addrs, nil
+}
+
+// readCode reads the contents of the executable section containing [start, end).
+func readCode(f *elf.File, start, end uint64) ([]byte, error) {
+	for _, sec := range f.Sections {
+		if sec.Flags&elf.SHF_EXECINSTR == 0 || sec.Type != elf.SHT_PROGBITS {
+			continue
+		}
+		if sec.Addr <= start && end <= sec.Addr+sec.Size {
+			code := make([]byte, end-start)
+			if _, err := sec.ReadAt(code, int64(start-sec.Addr)); err != nil {
+				return nil, fmt.Errorf("failed to read %s section: %w", sec.Name, err)
+			}
+			return code, nil
+		}
+	}
+	return nil, fmt.Errorf("no executable section contains [%#x, %#x)", start, end)
+}
+
+// x86ReturnOffsets returns the offsets of the RET instructions of x86-64 code.
+// Undecodable bytes, e.g. in the padding between functions, are skipped one at a time.
+func x86ReturnOffsets(code []byte) []uint64 {
+	var offs []uint64
+	for off := 0; off < len(code); {
+		inst, err := x86asm.Decode(code[off:], 64)
+		if err != nil || inst.Len == 0 {
+			off++
+			continue
+		}
+		if inst.Op == x86asm.RET {
+			offs = append(offs, uint64(off))
+		}
+		off += inst.Len
+	}
+	return offs
+}
+
+// arm64ReturnOffsets returns the offsets of the RET instructions of arm64 code,
+// in which all instructions are 4 bytes long.
+func arm64ReturnOffsets(code []byte) []uint64 {
+	var offs []uint64
+	for off := 0; off+4 <= len(code); off += 4 {
+		inst, err := arm64asm.Decode(code[off : off+4])
+		if err != nil {
+			continue
+		}
+		if inst.Op == arm64asm.RET {
+			offs = append(offs, uint64(off))
+		}
+	}
+	return offs
+}
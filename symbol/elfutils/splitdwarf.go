@@ -0,0 +1,449 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elfutils
+
+import (
+	"bytes"
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-delve/delve/pkg/dwarf/leb128"
+)
+
+// The pre-DWARF 5 GNU split DWARF extensions, see https://gcc.gnu.org/wiki/DebugFission.
+const (
+	attrGNUDwoName    dwarf.Attr = 0x2130
+	attrGNUDwoID      dwarf.Attr = 0x2131
+	attrGNURangesBase dwarf.Attr = 0x2132
+	attrGNUAddrBase   dwarf.Attr = 0x2133
+
+	formGNUAddrIndex = 0x1f01
+	formGNUStrIndex  = 0x1f02
+
+	// formAddrx and formStrx are the DWARF 5 forms with the same encoding.
+	formAddrx = 0x1b
+	formStrx  = 0x1a
+)
+
+// Section identifiers used by the .debug_cu_index of DWARF package files.
+// See DWARF 5 section 7.3.5.3 and the GNU DebugFission proposal for version 2.
+const (
+	dwpSectInfo       = 1
+	dwpSectAbbrev     = 3
+	dwpSectLine       = 4
+	dwpSectStrOffsets = 6
+	dwpSectRnglists   = 8 // DWARF 5 only.
+)
+
+// SplitDWARF resolves the split units (.dwo files or a .dwp package)
+// referenced by the skeleton compile units of an executable built with -gsplit-dwarf.
+//
+// Both the DWARF 5 split units and the DWARF 4 GNU extension are supported.
+type SplitDWARF struct {
+	info        []byte
+	addr        []byte
+	ranges      []byte
+	byteOrder   binary.ByteOrder
+	searchPaths []string
+
+	dwp *dwpFile
+
+	mtx   sync.Mutex
+	units map[dwarf.Offset]splitUnit
+}
+
+// splitUnit is the result of the resolution of a split unit.
+type splitUnit struct {
+	data *dwarf.Data
+	err  error
+}
+
+// NewSplitDWARF creates a SplitDWARF for the executable f located at filename.
+//
+// The .dwo files are looked up by their DW_AT_dwo_name (or DW_AT_GNU_dwo_name),
+// relative to DW_AT_comp_dir first and then to every directory in searchPaths
+// and the directory of the executable. A DWARF package named <filename>.dwp,
+// found next to the executable or in searchPaths, takes precedence over .dwo files.
+func NewSplitDWARF(filename string, f *elf.File, searchPaths []string) (*SplitDWARF, error) {
+	s := &SplitDWARF{
+		byteOrder:   f.ByteOrder,
+		searchPaths: append(append([]string{}, searchPaths...), filepath.Dir(filename)),
+		units:       make(map[dwarf.Offset]splitUnit),
+	}
+
+	var err error
+	if sec := f.Section(".debug_info"); sec != nil {
+		if s.info, err = sec.Data(); err != nil {
+			return nil, fmt.Errorf("failed to read .debug_info section: %w", err)
+		}
+	}
+	if sec := f.Section(".debug_addr"); sec != nil {
+		if s.addr, err = sec.Data(); err != nil {
+			return nil, fmt.Errorf("failed to read .debug_addr section: %w", err)
+		}
+	}
+	if sec := f.Section(".debug_ranges"); sec != nil {
+		if s.ranges, err = sec.Data(); err != nil {
+			return nil, fmt.Errorf("failed to read .debug_ranges section: %w", err)
+		}
+	}
+
+	dwpName := filepath.Base(filename) + ".dwp"
+	for _, dir := range s.searchPaths {
+		path := filepath.Join(dir, dwpName)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if s.dwp, err = openDWP(path); err != nil {
+			return nil, fmt.Errorf("failed to open DWARF package %s: %w", path, err)
+		}
+		break
+	}
+	return s, nil
+}
+
+// IsSkeleton reports whether the compile unit entry cu refers to a split unit.
+func IsSkeleton(cu *dwarf.Entry) bool {
+	return dwoName(cu) != ""
+}
+
+// Unit returns the DWARF data of the split unit that belongs to the skeleton
+// compile unit cu. It returns nil without an error if cu is not a skeleton unit.
+// The split unit is merged with the skeleton, i.e. indexed addresses are resolved
+// against the .debug_addr section of the executable. A failure is cached: every call
+// for cu returns the same error.
+func (s *SplitDWARF) Unit(cu *dwarf.Entry) (*dwarf.Data, error) {
+	name := dwoName(cu)
+	if name == "" {
+		return nil, nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if u, ok := s.units[cu.Offset]; ok {
+		return u.data, u.err
+	}
+
+	d, err := s.loadUnit(cu, name)
+	s.units[cu.Offset] = splitUnit{data: d, err: err}
+	return d, err
+}
+
+func (s *SplitDWARF) loadUnit(cu *dwarf.Entry, name string) (*dwarf.Data, error) {
+	sections, err := s.unitSections(cu, name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Split units don't carry DW_AT_str_offsets_base nor DW_AT_addr_base,
+	// debug/dwarf assumes both are 0, so we strip the headers accordingly.
+	// The DWARF 4 .debug_str_offsets.dwo has no header.
+	version := unitVersion(sections["info"], s.byteOrder)
+	if version >= 5 && len(sections["str_offsets"]) >= 8 {
+		sections["str_offsets"] = sections["str_offsets"][8:]
+	}
+	sections["addr"] = s.addr
+	for _, attr := range []dwarf.Attr{dwarf.AttrAddrBase, attrGNUAddrBase} {
+		if addrBase, ok := cu.Val(attr).(int64); ok && s.addr != nil && int(addrBase) <= len(s.addr) {
+			sections["addr"] = s.addr[addrBase:]
+			break
+		}
+	}
+
+	var ranges []byte
+	if version < 5 {
+		// debug/dwarf doesn't know the GNU forms but their DWARF 5 counterparts.
+		if sections["abbrev"], err = rewriteGNUForms(sections["abbrev"]); err != nil {
+			return nil, fmt.Errorf("failed to decode abbreviations of split unit %s: %w", name, err)
+		}
+		// The ranges are relative to DW_AT_GNU_ranges_base in the .debug_ranges of the executable.
+		if base, ok := cu.Val(attrGNURangesBase).(int64); ok && int(base) <= len(s.ranges) {
+			ranges = s.ranges[base:]
+		}
+	}
+
+	d, err := dwarf.New(sections["abbrev"], nil, nil, sections["info"], nil, nil, ranges, sections["str"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode split unit %s: %w", name, err)
+	}
+	for _, sec := range []string{"str_offsets", "addr", "rnglists"} {
+		if len(sections[sec]) == 0 {
+			continue
+		}
+		if err := d.AddSection(".debug_"+sec, sections[sec]); err != nil {
+			return nil, fmt.Errorf("failed to add .debug_%s of split unit %s: %w", sec, name, err)
+		}
+	}
+	return d, nil
+}
+
+// unitVersion returns the version of the first unit of a .debug_info section, 0 if truncated.
+func unitVersion(info []byte, byteOrder binary.ByteOrder) uint16 {
+	off := 4
+	if len(info) >= 4 && byteOrder.Uint32(info) == 0xffffffff {
+		off = 12
+	}
+	if len(info) < off+2 {
+		return 0
+	}
+	return byteOrder.Uint16(info[off:])
+}
+
+// rewriteGNUForms returns a copy of the abbreviation table abbrev where DW_FORM_GNU_addr_index
+// and DW_FORM_GNU_str_index are replaced by DW_FORM_addrx and DW_FORM_strx. The new forms
+// are padded to the 2 bytes of the old ones, which keeps the offsets of the abbreviations.
+func rewriteGNUForms(abbrev []byte) ([]byte, error) {
+	res := append([]byte(nil), abbrev...)
+	buf := bytes.NewBuffer(abbrev)
+	pos := func() int { return len(abbrev) - buf.Len() }
+	for buf.Len() > 0 {
+		// A code of 0 ends a table, another one may follow.
+		if code, _ := leb128.DecodeUnsigned(buf); code == 0 {
+			continue
+		}
+		leb128.DecodeUnsigned(buf) // tag
+		if _, err := buf.ReadByte(); err != nil {
+			return nil, err
+		}
+		for {
+			attr, _ := leb128.DecodeUnsigned(buf)
+			start := pos()
+			form, _ := leb128.DecodeUnsigned(buf)
+			if attr == 0 && form == 0 {
+				break
+			}
+			if buf.Len() == 0 {
+				return nil, errors.New("truncated abbreviation")
+			}
+			switch {
+			case form == 0x21: // DW_FORM_implicit_const
+				leb128.DecodeSigned(buf)
+			case form == formGNUAddrIndex && pos()-start == 2:
+				res[start], res[start+1] = 0x80|formAddrx, 0
+			case form == formGNUStrIndex && pos()-start == 2:
+				res[start], res[start+1] = 0x80|formStrx, 0
+			}
+		}
+	}
+	return res, nil
+}
+
+// unitSections returns the raw split DWARF sections, without the .dwo suffix,
+// that contain the split unit for cu.
+func (s *SplitDWARF) unitSections(cu *dwarf.Entry, name string) (map[string][]byte, error) {
+	if s.dwp != nil {
+		id, ok := s.dwoID(cu)
+		if !ok {
+			return nil, fmt.Errorf("failed to read DWO id of split unit %s", name)
+		}
+		if sections, ok := s.dwp.unit(id); ok {
+			return sections, nil
+		}
+	}
+
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		candidates = candidates[:0]
+		if compDir, ok := cu.Val(dwarf.AttrCompDir).(string); ok {
+			candidates = append(candidates, filepath.Join(compDir, name))
+		}
+		for _, dir := range s.searchPaths {
+			candidates = append(candidates, filepath.Join(dir, name), filepath.Join(dir, filepath.Base(name)))
+		}
+	}
+
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		f, err := elf.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open split DWARF file %s: %w", path, err)
+		}
+		defer f.Close()
+		return dwoSections(f)
+	}
+	return nil, fmt.Errorf("failed to find split DWARF file %s", name)
+}
+
+// dwoID returns the DWO id of a skeleton compile unit. DWARF 5 stores it in the last
+// 8 bytes of the unit header, right before the unit DIE. DWARF 4 uses DW_AT_GNU_dwo_id.
+func (s *SplitDWARF) dwoID(cu *dwarf.Entry) (uint64, bool) {
+	if id, ok := cu.Val(attrGNUDwoID).(int64); ok {
+		return uint64(id), true
+	}
+	if cu.Tag != dwarf.TagSkeletonUnit || int(cu.Offset) < 8 || int(cu.Offset) > len(s.info) {
+		return 0, false
+	}
+	return s.byteOrder.Uint64(s.info[cu.Offset-8:]), true
+}
+
+func dwoName(cu *dwarf.Entry) string {
+	if name, ok := cu.Val(dwarf.AttrDwoName).(string); ok {
+		return name
+	}
+	if name, ok := cu.Val(attrGNUDwoName).(string); ok {
+		return name
+	}
+	return ""
+}
+
+// dwoSections reads the .debug_*.dwo sections of f.
+func dwoSections(f *elf.File) (map[string][]byte, error) {
+	sections := make(map[string][]byte)
+	for _, sec := range f.Sections {
+		if !strings.HasPrefix(sec.Name, ".debug_") || !strings.HasSuffix(sec.Name, ".dwo") {
+			continue
+		}
+		b, err := sec.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s section: %w", sec.Name, err)
+		}
+		sections[strings.TrimSuffix(sec.Name[len(".debug_"):], ".dwo")] = b
+	}
+	if sections["info"] == nil || sections["abbrev"] == nil {
+		return nil, errors.New("split DWARF file has no .debug_info.dwo or .debug_abbrev.dwo section")
+	}
+	return sections, nil
+}
+
+// dwpFile is a DWARF package file, which bundles many split units
+// and indexes them by their DWO id in the .debug_cu_index section.
+type dwpFile struct {
+	version  uint32
+	sections map[string][]byte
+	// contributions maps a DWO id to the offset and size of each section contribution.
+	contributions map[uint64]map[uint32][2]uint32
+}
+
+func openDWP(path string) (*dwpFile, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sections, err := dwoSections(f)
+	if err != nil {
+		return nil, err
+	}
+
+	sec := f.Section(".debug_cu_index")
+	if sec == nil {
+		return nil, errors.New("DWARF package has no .debug_cu_index section")
+	}
+	index, err := sec.Data()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .debug_cu_index section: %w", err)
+	}
+	version, contributions, err := parseCUIndex(index, f.ByteOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dwpFile{
+		version:       version,
+		sections:      sections,
+		contributions: contributions,
+	}, nil
+}
+
+// parseCUIndex decodes a .debug_cu_index section, version 2 (GNU) or 5.
+func parseCUIndex(b []byte, byteOrder binary.ByteOrder) (uint32, map[uint64]map[uint32][2]uint32, error) {
+	const headerSize = 16
+	if len(b) < headerSize {
+		return 0, nil, errors.New("truncated .debug_cu_index header")
+	}
+	// Version 5 is a 2-byte field followed by 2 bytes of padding.
+	version := byteOrder.Uint32(b[0:])
+	if version != 2 {
+		version = uint32(byteOrder.Uint16(b[0:]))
+	}
+	if version != 2 && version != 5 {
+		return 0, nil, fmt.Errorf("unsupported .debug_cu_index version %d", version)
+	}
+	sectionCount := uint64(byteOrder.Uint32(b[4:]))
+	unitCount := uint64(byteOrder.Uint32(b[8:]))
+	slotCount := uint64(byteOrder.Uint32(b[12:]))
+
+	hashes := uint64(headerSize)
+	indexes := hashes + 8*slotCount
+	offsets := indexes + 4*slotCount
+	sizes := offsets + 4*sectionCount*(unitCount+1)
+	end := sizes + 4*sectionCount*unitCount
+	if end > uint64(len(b)) {
+		return 0, nil, errors.New("truncated .debug_cu_index tables")
+	}
+
+	ids := make([]uint32, sectionCount)
+	for i := range ids {
+		ids[i] = byteOrder.Uint32(b[offsets+4*uint64(i):])
+	}
+
+	contributions := make(map[uint64]map[uint32][2]uint32, unitCount)
+	for slot := uint64(0); slot < slotCount; slot++ {
+		row := uint64(byteOrder.Uint32(b[indexes+4*slot:]))
+		if row == 0 || row > unitCount {
+			continue
+		}
+		id := byteOrder.Uint64(b[hashes+8*slot:])
+
+		unit := make(map[uint32][2]uint32, sectionCount)
+		for i := uint64(0); i < sectionCount; i++ {
+			off := byteOrder.Uint32(b[offsets+4*(row*sectionCount+i):])
+			size := byteOrder.Uint32(b[sizes+4*((row-1)*sectionCount+i):])
+			unit[ids[i]] = [2]uint32{off, size}
+		}
+		contributions[id] = unit
+	}
+	return version, contributions, nil
+}
+
+// unit returns the sections of the split unit with the given DWO id.
+func (p *dwpFile) unit(id uint64) (map[string][]byte, bool) {
+	unit, ok := p.contributions[id]
+	if !ok {
+		return nil, false
+	}
+
+	sections := map[string][]byte{
+		// The string table is shared by all units of the package.
+		"str": p.sections["str"],
+	}
+	for sect, name := range map[uint32]string{
+		dwpSectInfo:       "info",
+		dwpSectAbbrev:     "abbrev",
+		dwpSectLine:       "line",
+		dwpSectStrOffsets: "str_offsets",
+		dwpSectRnglists:   "rnglists",
+	} {
+		c, ok := unit[sect]
+		if !ok || sect == dwpSectRnglists && p.version != 5 {
+			continue
+		}
+		data := p.sections[name]
+		if uint64(c[0])+uint64(c[1]) > uint64(len(data)) {
+			continue
+		}
+		sections[name] = data[c[0] : c[0]+c[1]]
+	}
+	return sections, true
+}
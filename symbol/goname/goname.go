@@ -0,0 +1,224 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package goname parses the symbol names emitted by the Go toolchain,
+// e.g. "example.com/m/pkg.(*List[go.shape.int]).Push.func1.2".
+package goname
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Name is a parsed Go symbol name.
+type Name struct {
+	// Raw is the symbol name as given to Parse.
+	Raw string
+	// ImportPath is the import path of the package, e.g. "gopkg.in/yaml.v3".
+	// It's empty for symbols without a package, e.g. assembly entry points.
+	ImportPath string
+	// Receiver is the receiver type of a method without the pointer and the type arguments.
+	Receiver string
+	// PointerReceiver tells whether the method has a pointer receiver.
+	PointerReceiver bool
+	// Func is the name of the function or the method.
+	Func string
+	// Closures are the closures nested in Func from the outermost one,
+	// e.g. ["func1", "2"] for "pkg.F.func1.2".
+	Closures []string
+	// TypeArgs are the type arguments of the generic function or receiver type.
+	TypeArgs []string
+	// MethodValue tells whether the symbol is a method value wrapper ("-fm" suffix).
+	MethodValue bool
+}
+
+// IsMethod reports whether the symbol is a method or belongs to one.
+func (n Name) IsMethod() bool {
+	return n.Receiver != ""
+}
+
+// IsClosure reports whether the symbol is a closure.
+func (n Name) IsClosure() bool {
+	return len(n.Closures) > 0
+}
+
+// IsGeneric reports whether the symbol is a generic instantiation.
+func (n Name) IsGeneric() bool {
+	return len(n.TypeArgs) > 0
+}
+
+// PackageName returns the last element of the import path, which usually is the package name.
+func (n Name) PackageName() string {
+	return n.ImportPath[strings.LastIndex(n.ImportPath, "/")+1:]
+}
+
+// Parse parses a Go symbol name. Names which don't follow the Go conventions,
+// e.g. of C functions, are returned in Func.
+func Parse(sym string) Name {
+	n := Name{Raw: sym}
+
+	// The import path ends at the first dot after the last slash. Dots in the last element
+	// are escaped by the linker (%2e), and slashes in type arguments come after the path.
+	limit := strings.IndexAny(sym, "[(")
+	if limit < 0 {
+		limit = len(sym)
+	}
+	slash := strings.LastIndex(sym[:limit], "/")
+	dot := strings.Index(sym[slash+1:limit], ".")
+	if dot < 0 {
+		n.Func = sym
+		return n
+	}
+	pathEnd := slash + 1 + dot
+	n.ImportPath = unescape(sym[:pathEnd])
+
+	rest := sym[pathEnd+1:]
+	if strings.HasSuffix(rest, "-fm") {
+		n.MethodValue = true
+		rest = strings.TrimSuffix(rest, "-fm")
+	}
+
+	var tokens []string
+	for _, tok := range splitTopLevel(rest, '.') {
+		// Package level closures of older versions are named "glob..func1".
+		if tok != "" {
+			tokens = append(tokens, tok)
+		}
+	}
+	if len(tokens) == 0 {
+		n.Func = rest
+		return n
+	}
+
+	i := 1
+	switch first := tokens[0]; {
+	case strings.HasPrefix(first, "(") && strings.HasSuffix(first, ")"):
+		recv := first[1 : len(first)-1]
+		if strings.HasPrefix(recv, "*") {
+			n.PointerReceiver = true
+			recv = recv[1:]
+		}
+		n.Receiver, n.TypeArgs = splitTypeArgs(recv)
+		if len(tokens) > 1 {
+			n.Func = tokens[1]
+			i = 2
+		}
+	case len(tokens) > 1 && first == "init" && isDigits(tokens[1]):
+		// Multiple init functions of a package are numbered: "pkg.init.0".
+		n.Func = first + "." + tokens[1]
+		i = 2
+	case len(tokens) > 1 && !isClosure(tokens[1]):
+		n.Receiver, n.TypeArgs = splitTypeArgs(first)
+		n.Func = tokens[1]
+		i = 2
+	default:
+		n.Func, n.TypeArgs = splitTypeArgs(first)
+	}
+
+	for _, tok := range tokens[i:] {
+		closure, _ := splitTypeArgs(tok)
+		n.Closures = append(n.Closures, closure)
+	}
+	return n
+}
+
+// Normalize replaces the type arguments of generic instantiations by "...", like the Go runtime
+// does in tracebacks, so that the instantiations for different shapes aggregate in profiles.
+func Normalize(sym string) string {
+	if !strings.Contains(sym, "[") {
+		return sym
+	}
+	var b strings.Builder
+	depth := 0
+	for i := 0; i < len(sym); i++ {
+		switch c := sym[i]; c {
+		case '[':
+			if depth == 0 {
+				b.WriteString("[...]")
+			}
+			depth++
+		case ']':
+			if depth > 0 {
+				depth--
+			}
+		default:
+			if depth == 0 {
+				b.WriteByte(c)
+			}
+		}
+	}
+	return b.String()
+}
+
+// splitTopLevel splits s at the separators that aren't nested in brackets or parentheses.
+func splitTopLevel(s string, sep byte) []string {
+	var res []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '[', '(':
+			depth++
+		case ']', ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				res = append(res, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(res, s[start:])
+}
+
+// splitTypeArgs splits "F[A,B]" into "F" and ["A", "B"].
+func splitTypeArgs(s string) (string, []string) {
+	i := strings.IndexByte(s, '[')
+	if i < 0 || !strings.HasSuffix(s, "]") {
+		return s, nil
+	}
+	return s[:i], splitTopLevel(s[i+1:len(s)-1], ',')
+}
+
+// isClosure reports whether a name element is generated for a function literal,
+// a go or a defer statement.
+func isClosure(s string) bool {
+	s, _ = splitTypeArgs(s)
+	for _, prefix := range []string{"func", "gowrap", "deferwrap"} {
+		if strings.HasPrefix(s, prefix) && isDigits(s[len(prefix):]) {
+			return true
+		}
+	}
+	return isDigits(s)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func unescape(path string) string {
+	if !strings.Contains(path, "%") {
+		return path
+	}
+	if p, err := url.PathUnescape(path); err == nil {
+		return p
+	}
+	return path
+}
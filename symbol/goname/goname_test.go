@@ -0,0 +1,92 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package goname
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		sym  string
+		want Name
+	}{
+		{
+			sym:  "main.main",
+			want: Name{ImportPath: "main", Func: "main"},
+		},
+		{
+			sym:  "runtime.(*m).init",
+			want: Name{ImportPath: "runtime", Receiver: "m", PointerReceiver: true, Func: "init"},
+		},
+		{
+			sym:  "gopkg.in/yaml%2ev3.(*parser).parse",
+			want: Name{ImportPath: "gopkg.in/yaml.v3", Receiver: "parser", PointerReceiver: true, Func: "parse"},
+		},
+		{
+			sym:  "github.com/foo/bar.Handler.ServeHTTP",
+			want: Name{ImportPath: "github.com/foo/bar", Receiver: "Handler", Func: "ServeHTTP"},
+		},
+		{
+			sym:  "example.com/m/pkg.(*T).M.func1.2",
+			want: Name{ImportPath: "example.com/m/pkg", Receiver: "T", PointerReceiver: true, Func: "M", Closures: []string{"func1", "2"}},
+		},
+		{
+			sym:  "example.com/m/pkg.Map[go.shape.string,go.shape.int]",
+			want: Name{ImportPath: "example.com/m/pkg", Func: "Map", TypeArgs: []string{"go.shape.string", "go.shape.int"}},
+		},
+		{
+			sym:  "example.com/m/pkg.(*List[example.com/m/other.T]).Push.func1",
+			want: Name{ImportPath: "example.com/m/pkg", Receiver: "List", PointerReceiver: true, Func: "Push", Closures: []string{"func1"}, TypeArgs: []string{"example.com/m/other.T"}},
+		},
+		{
+			sym:  "example.com/m/pkg.Pair[...].String",
+			want: Name{ImportPath: "example.com/m/pkg", Receiver: "Pair", Func: "String", TypeArgs: []string{"..."}},
+		},
+		{
+			sym:  "net/http.(*Server).Serve-fm",
+			want: Name{ImportPath: "net/http", Receiver: "Server", PointerReceiver: true, Func: "Serve", MethodValue: true},
+		},
+		{
+			sym:  "main.init.0",
+			want: Name{ImportPath: "main", Func: "init.0"},
+		},
+		{
+			sym:  "main.glob..func1",
+			want: Name{ImportPath: "main", Func: "glob", Closures: []string{"func1"}},
+		},
+		{
+			sym:  "runtime.gcenable.gowrap1",
+			want: Name{ImportPath: "runtime", Func: "gcenable", Closures: []string{"gowrap1"}},
+		},
+		{
+			sym:  "_rt0_amd64_linux",
+			want: Name{Func: "_rt0_amd64_linux"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sym, func(t *testing.T) {
+			tt.want.Raw = tt.sym
+			require.Equal(t, tt.want, Parse(tt.sym))
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	require.Equal(t, "pkg.Map[...]", Normalize("pkg.Map[go.shape.string,go.shape.[]int]"))
+	require.Equal(t, "pkg.(*List[...]).Push.func1", Normalize("pkg.(*List[go.shape.*uint8]).Push.func1"))
+	require.Equal(t, "main.main", Normalize("main.main"))
+}
@@ -0,0 +1,349 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jitdump reads the jitdump files, jit-<pid>.dump, written by JIT compilers
+// for perf, see tools/perf/Documentation/jitdump-specification.txt in the Linux tree.
+package jitdump
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	magic = 0x4A695444 // "JiTD" in the byte order of the writer.
+
+	headerSize = 40
+	prefixSize = 16
+
+	// maxRecordSize bounds the size of a record, to not allocate a corrupted size.
+	maxRecordSize = 1 << 30
+)
+
+// FlagArchTimestamp is set in Header.Flags when the timestamps are read from the
+// architecture clock, e.g. TSC, instead of a kernel clock.
+const FlagArchTimestamp = 1 << 0
+
+// Header is the header of a jitdump file.
+type Header struct {
+	Version uint32
+	// Machine is the architecture of the JIT-compiled code.
+	Machine   elf.Machine
+	Pid       uint32
+	Timestamp uint64
+	Flags     uint64
+}
+
+// RecordType is the type of a record.
+type RecordType uint32
+
+const (
+	RecordCodeLoad      RecordType = 0
+	RecordCodeMove      RecordType = 1
+	RecordDebugInfo     RecordType = 2
+	RecordCodeClose     RecordType = 3
+	RecordUnwindingInfo RecordType = 4
+)
+
+func (t RecordType) String() string {
+	switch t {
+	case RecordCodeLoad:
+		return "JIT_CODE_LOAD"
+	case RecordCodeMove:
+		return "JIT_CODE_MOVE"
+	case RecordDebugInfo:
+		return "JIT_CODE_DEBUG_INFO"
+	case RecordCodeClose:
+		return "JIT_CODE_CLOSE"
+	case RecordUnwindingInfo:
+		return "JIT_CODE_UNWINDING_INFO"
+	}
+	return fmt.Sprintf("RecordType(%d)", uint32(t))
+}
+
+// RecordHeader is the prefix common to all records.
+type RecordHeader struct {
+	Type RecordType
+	// Size is the size of the record, including the header.
+	Size      uint32
+	Timestamp uint64
+}
+
+// Header returns the header of the record.
+func (h RecordHeader) Header() RecordHeader {
+	return h
+}
+
+// Record is one of *CodeLoad, *CodeMove, *DebugInfo, *CodeClose, *UnwindingInfo
+// or *UnknownRecord.
+type Record interface {
+	Header() RecordHeader
+}
+
+// CodeLoad describes a function which was compiled.
+type CodeLoad struct {
+	RecordHeader
+	Pid, Tid uint32
+	// VMA is the virtual address of the code, usually the same as CodeAddr.
+	VMA      uint64
+	CodeAddr uint64
+	CodeSize uint64
+	// CodeIndex uniquely identifies the compiled code, e.g. in a CodeMove.
+	CodeIndex uint64
+	Name      string
+	Code      []byte
+}
+
+// CodeMove describes compiled code which was moved.
+type CodeMove struct {
+	RecordHeader
+	Pid, Tid    uint32
+	VMA         uint64
+	OldCodeAddr uint64
+	NewCodeAddr uint64
+	CodeSize    uint64
+	CodeIndex   uint64
+}
+
+// DebugInfo maps the code loaded at CodeAddr to source lines. It precedes the CodeLoad.
+type DebugInfo struct {
+	RecordHeader
+	CodeAddr uint64
+	Entries  []DebugEntry
+}
+
+// DebugEntry is the source line of the code from Addr up to the address of the next entry.
+type DebugEntry struct {
+	Addr          uint64
+	Line          int32
+	Discriminator int32
+	File          string
+}
+
+// CodeClose marks the end of the file.
+type CodeClose struct {
+	RecordHeader
+}
+
+// UnwindingInfo holds the .eh_frame and .eh_frame_hdr data of the next loaded code.
+type UnwindingInfo struct {
+	RecordHeader
+	// EHFrameHdrSize is the size of the .eh_frame_hdr at the end of Data.
+	EHFrameHdrSize uint64
+	// MappedSize is the size of the unwinding data mapped with the code.
+	MappedSize uint64
+	Data       []byte
+}
+
+// UnknownRecord is a record of a type this package doesn't know.
+type UnknownRecord struct {
+	RecordHeader
+	Data []byte
+}
+
+// Reader reads the records of a jitdump file.
+type Reader struct {
+	Header Header
+
+	r     *bufio.Reader
+	order binary.ByteOrder
+}
+
+// NewReader reads the header of a jitdump file and returns a reader of its records.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	buf := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return nil, fmt.Errorf("failed to read jitdump header: %w", err)
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint32(buf) == magic:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint32(buf) == magic:
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("not a jitdump file: bad magic")
+	}
+
+	size := order.Uint32(buf[8:])
+	if size < headerSize {
+		return nil, fmt.Errorf("invalid jitdump header size %d", size)
+	}
+	// Newer versions may have a larger header.
+	if _, err := br.Discard(int(size - headerSize)); err != nil {
+		return nil, fmt.Errorf("failed to read jitdump header: %w", err)
+	}
+
+	return &Reader{
+		Header: Header{
+			Version:   order.Uint32(buf[4:]),
+			Machine:   elf.Machine(order.Uint32(buf[12:])),
+			Pid:       order.Uint32(buf[20:]),
+			Timestamp: order.Uint64(buf[24:]),
+			Flags:     order.Uint64(buf[32:]),
+		},
+		r:     br,
+		order: order,
+	}, nil
+}
+
+// Next returns the next record, or io.EOF at the end of the file. A record cut short,
+// e.g. because the JIT compiler is still writing it, returns io.ErrUnexpectedEOF.
+func (r *Reader) Next() (Record, error) {
+	prefix := make([]byte, prefixSize)
+	if _, err := io.ReadFull(r.r, prefix); err != nil {
+		return nil, err
+	}
+	h := RecordHeader{
+		Type:      RecordType(r.order.Uint32(prefix)),
+		Size:      r.order.Uint32(prefix[4:]),
+		Timestamp: r.order.Uint64(prefix[8:]),
+	}
+	if h.Size < prefixSize || h.Size > maxRecordSize {
+		return nil, fmt.Errorf("invalid size %d of %s record", h.Size, h.Type)
+	}
+	body := make([]byte, h.Size-prefixSize)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	rec, err := r.decode(h, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s record: %w", h.Type, err)
+	}
+	return rec, nil
+}
+
+// ReadAll reads the records up to the end of the file. The records read before an error
+// are returned with it.
+func (r *Reader) ReadAll() ([]Record, error) {
+	var recs []Record
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return recs, nil
+		}
+		if err != nil {
+			return recs, err
+		}
+		recs = append(recs, rec)
+	}
+}
+
+var errShortRecord = errors.New("record too short")
+
+func (r *Reader) decode(h RecordHeader, body []byte) (Record, error) {
+	switch h.Type {
+	case RecordCodeLoad:
+		if len(body) < 40 {
+			return nil, errShortRecord
+		}
+		name, code, ok := bytes.Cut(body[40:], []byte{0})
+		if !ok {
+			return nil, errors.New("unterminated name")
+		}
+		return &CodeLoad{
+			RecordHeader: h,
+			Pid:          r.order.Uint32(body),
+			Tid:          r.order.Uint32(body[4:]),
+			VMA:          r.order.Uint64(body[8:]),
+			CodeAddr:     r.order.Uint64(body[16:]),
+			CodeSize:     r.order.Uint64(body[24:]),
+			CodeIndex:    r.order.Uint64(body[32:]),
+			Name:         string(name),
+			Code:         code,
+		}, nil
+	case RecordCodeMove:
+		if len(body) < 48 {
+			return nil, errShortRecord
+		}
+		return &CodeMove{
+			RecordHeader: h,
+			Pid:          r.order.Uint32(body),
+			Tid:          r.order.Uint32(body[4:]),
+			VMA:          r.order.Uint64(body[8:]),
+			OldCodeAddr:  r.order.Uint64(body[16:]),
+			NewCodeAddr:  r.order.Uint64(body[24:]),
+			CodeSize:     r.order.Uint64(body[32:]),
+			CodeIndex:    r.order.Uint64(body[40:]),
+		}, nil
+	case RecordDebugInfo:
+		return r.decodeDebugInfo(h, body)
+	case RecordCodeClose:
+		return &CodeClose{RecordHeader: h}, nil
+	case RecordUnwindingInfo:
+		if len(body) < 24 {
+			return nil, errShortRecord
+		}
+		size := r.order.Uint64(body)
+		if size > uint64(len(body)-24) {
+			return nil, errShortRecord
+		}
+		return &UnwindingInfo{
+			RecordHeader:   h,
+			EHFrameHdrSize: r.order.Uint64(body[8:]),
+			MappedSize:     r.order.Uint64(body[16:]),
+			Data:           body[24 : 24+size],
+		}, nil
+	}
+	return &UnknownRecord{RecordHeader: h, Data: body}, nil
+}
+
+// sameFile is the file name of the debug entries with the same file as the previous one.
+var sameFile = []byte{0xff}
+
+func (r *Reader) decodeDebugInfo(h RecordHeader, body []byte) (*DebugInfo, error) {
+	if len(body) < 16 {
+		return nil, errShortRecord
+	}
+	rec := &DebugInfo{RecordHeader: h, CodeAddr: r.order.Uint64(body)}
+	n := r.order.Uint64(body[8:])
+	body = body[16:]
+	// Each entry takes at least 17 bytes.
+	if n > uint64(len(body))/17 {
+		return nil, errShortRecord
+	}
+
+	rec.Entries = make([]DebugEntry, 0, n)
+	var file string
+	for i := uint64(0); i < n; i++ {
+		if len(body) < 16 {
+			return nil, errShortRecord
+		}
+		name, rest, ok := bytes.Cut(body[16:], []byte{0})
+		if !ok {
+			return nil, errors.New("unterminated file name")
+		}
+		if !bytes.Equal(name, sameFile) {
+			file = string(name)
+		}
+		rec.Entries = append(rec.Entries, DebugEntry{
+			Addr:          r.order.Uint64(body),
+			Line:          int32(r.order.Uint32(body[8:])),
+			Discriminator: int32(r.order.Uint32(body[12:])),
+			File:          file,
+		})
+		body = rest
+	}
+	return rec, nil
+}
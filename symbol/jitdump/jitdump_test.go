@@ -0,0 +1,170 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jitdump
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// dump builds a jitdump file in the byte order order.
+type dump struct {
+	order binary.ByteOrder
+	buf   bytes.Buffer
+}
+
+func newDump(order binary.ByteOrder, headerSize uint32) *dump {
+	d := &dump{order: order}
+	d.put(uint32(magic), uint32(1), headerSize, uint32(elf.EM_X86_64), uint32(0), uint32(42), uint64(1000), uint64(FlagArchTimestamp))
+	d.buf.Write(make([]byte, headerSize-40))
+	return d
+}
+
+func (d *dump) put(vs ...any) {
+	for _, v := range vs {
+		switch v := v.(type) {
+		case string:
+			d.buf.WriteString(v)
+			d.buf.WriteByte(0)
+		case []byte:
+			d.buf.Write(v)
+		default:
+			binary.Write(&d.buf, d.order, v)
+		}
+	}
+}
+
+// record appends a record of type t with the fields vs.
+func (d *dump) record(t RecordType, timestamp uint64, vs ...any) {
+	body := &dump{order: d.order}
+	body.put(vs...)
+	d.put(uint32(t), uint32(16+body.buf.Len()), timestamp)
+	d.buf.Write(body.buf.Bytes())
+}
+
+func TestReader(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			d := newDump(order, 48)
+			d.record(RecordDebugInfo, 1, uint64(0x1000), uint64(3),
+				uint64(0x1000), int32(10), int32(0), "a.js",
+				uint64(0x1008), int32(11), int32(1), []byte{0xff, 0},
+				uint64(0x1010), int32(20), int32(0), "b.js")
+			d.record(RecordCodeLoad, 2, uint32(42), uint32(43), uint64(0x1000), uint64(0x1000), uint64(4), uint64(7), "foo", []byte{1, 2, 3, 4})
+			d.record(RecordCodeMove, 3, uint32(42), uint32(43), uint64(0x2000), uint64(0x1000), uint64(0x2000), uint64(4), uint64(7))
+			d.record(RecordUnwindingInfo, 4, uint64(3), uint64(1), uint64(8), []byte{5, 6, 7, 0})
+			d.record(RecordType(9), 5, uint32(1))
+			d.record(RecordCodeClose, 6)
+
+			r, err := NewReader(bytes.NewReader(d.buf.Bytes()))
+			require.NoError(t, err)
+			require.Equal(t, Header{
+				Version:   1,
+				Machine:   elf.EM_X86_64,
+				Pid:       42,
+				Timestamp: 1000,
+				Flags:     FlagArchTimestamp,
+			}, r.Header)
+
+			recs, err := r.ReadAll()
+			require.NoError(t, err)
+			unknown := make([]byte, 4)
+			order.PutUint32(unknown, 1)
+			require.Equal(t, []Record{
+				&DebugInfo{
+					RecordHeader: RecordHeader{Type: RecordDebugInfo, Size: 92, Timestamp: 1},
+					CodeAddr:     0x1000,
+					Entries: []DebugEntry{
+						{Addr: 0x1000, Line: 10, File: "a.js"},
+						{Addr: 0x1008, Line: 11, Discriminator: 1, File: "a.js"},
+						{Addr: 0x1010, Line: 20, File: "b.js"},
+					},
+				},
+				&CodeLoad{
+					RecordHeader: RecordHeader{Type: RecordCodeLoad, Size: 64, Timestamp: 2},
+					Pid:          42,
+					Tid:          43,
+					VMA:          0x1000,
+					CodeAddr:     0x1000,
+					CodeSize:     4,
+					CodeIndex:    7,
+					Name:         "foo",
+					Code:         []byte{1, 2, 3, 4},
+				},
+				&CodeMove{
+					RecordHeader: RecordHeader{Type: RecordCodeMove, Size: 64, Timestamp: 3},
+					Pid:          42,
+					Tid:          43,
+					VMA:          0x2000,
+					OldCodeAddr:  0x1000,
+					NewCodeAddr:  0x2000,
+					CodeSize:     4,
+					CodeIndex:    7,
+				},
+				&UnwindingInfo{
+					RecordHeader:   RecordHeader{Type: RecordUnwindingInfo, Size: 44, Timestamp: 4},
+					EHFrameHdrSize: 1,
+					MappedSize:     8,
+					Data:           []byte{5, 6, 7},
+				},
+				&UnknownRecord{
+					RecordHeader: RecordHeader{Type: RecordType(9), Size: 20, Timestamp: 5},
+					Data:         unknown,
+				},
+				&CodeClose{
+					RecordHeader: RecordHeader{Type: RecordCodeClose, Size: 16, Timestamp: 6},
+				},
+			}, recs)
+		})
+	}
+}
+
+func TestReader_Errors(t *testing.T) {
+	_, err := NewReader(bytes.NewReader([]byte("not a jitdump file, really not one at all")))
+	require.ErrorContains(t, err, "bad magic")
+
+	_, err = NewReader(bytes.NewReader([]byte("JiTD")))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// A record still being written.
+	d := newDump(binary.LittleEndian, 40)
+	d.record(RecordCodeLoad, 1, uint32(1), uint32(1), uint64(0x1000), uint64(0x1000), uint64(0), uint64(0), "foo")
+	d.record(RecordCodeLoad, 2, uint32(1), uint32(1), uint64(0x2000), uint64(0x2000), uint64(0), uint64(1), "bar")
+	data := d.buf.Bytes()
+	r, err := NewReader(bytes.NewReader(data[:len(data)-3]))
+	require.NoError(t, err)
+	recs, err := r.ReadAll()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Len(t, recs, 1)
+	require.Equal(t, "foo", recs[0].(*CodeLoad).Name)
+
+	d = newDump(binary.LittleEndian, 40)
+	d.record(RecordCodeLoad, 1, uint32(1), uint32(1), uint64(0x1000), uint64(0x1000), uint64(0), uint64(0), []byte("foo"))
+	r, err = NewReader(bytes.NewReader(d.buf.Bytes()))
+	require.NoError(t, err)
+	_, err = r.Next()
+	require.ErrorContains(t, err, "unterminated name")
+
+	d = newDump(binary.LittleEndian, 40)
+	d.record(RecordDebugInfo, 1, uint64(0x1000), uint64(1000))
+	r, err = NewReader(bytes.NewReader(d.buf.Bytes()))
+	require.NoError(t, err)
+	_, err = r.Next()
+	require.ErrorIs(t, err, errShortRecord)
+}
@@ -0,0 +1,152 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pathmap
+
+import (
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// StdModule is the module name used for the files of the standard library.
+const StdModule = "std"
+
+type goModule struct {
+	path, version string
+}
+
+type goModulesRule struct {
+	goVersion string
+	goroot    string
+	mainDir   string
+	main      goModule
+	// modules are sorted by decreasing path length, for the longest prefix to match first.
+	modules []goModule
+}
+
+// GoModules returns a rule normalizing the paths of Go source files to "module@version/relpath",
+// using the module list of the build information. The files of the standard library are
+// attributed to StdModule, with the toolchain version, e.g. "std@go1.21.0/runtime/proc.go".
+//
+// The files of the module cache, of vendor directories and of -trimpath builds are recognized
+// from the path. For the others goroot, the GOROOT the binary was built with, and mainDir, the
+// directory of the main module, are needed. They can be left empty if unknown.
+func GoModules(bi *debug.BuildInfo, goroot, mainDir string) Rule {
+	r := &goModulesRule{
+		goroot:  strings.TrimSuffix(goroot, "/"),
+		mainDir: strings.TrimSuffix(mainDir, "/"),
+	}
+	if bi == nil {
+		return r
+	}
+
+	r.goVersion = bi.GoVersion
+	if bi.Main.Path != "" {
+		r.main = goModule{path: bi.Main.Path, version: bi.Main.Version}
+		r.modules = append(r.modules, r.main)
+	}
+	for _, m := range bi.Deps {
+		if m != nil && m.Path != "" {
+			r.modules = append(r.modules, goModule{path: m.Path, version: m.Version})
+		}
+	}
+	sort.SliceStable(r.modules, func(i, j int) bool {
+		return len(r.modules[i].path) > len(r.modules[j].path)
+	})
+	return r
+}
+
+func (r *goModulesRule) Remap(path string) (string, bool) {
+	// Module cache: $GOMODCACHE/github.com/!burnt!sushi/toml@v1.2.0/decode.go.
+	if i := strings.LastIndex(path, "/pkg/mod/"); i >= 0 {
+		if p, ok := moduleCachePath(path[i+len("/pkg/mod/"):]); ok {
+			return p, true
+		}
+	}
+
+	if r.goroot != "" {
+		if rel, ok := strings.CutPrefix(path, r.goroot+"/src/"); ok {
+			return r.std(rel), true
+		}
+	}
+
+	// Vendored packages have the import path below the vendor directory.
+	if i := strings.LastIndex(path, "/vendor/"); i >= 0 {
+		path = path[i+len("/vendor/"):]
+	} else if strings.HasPrefix(path, "/") {
+		if r.mainDir != "" && r.main.path != "" {
+			if rel, ok := strings.CutPrefix(path, r.mainDir+"/"); ok {
+				return r.main.path + "@" + r.main.version + "/" + rel, true
+			}
+		}
+		return "", false
+	}
+
+	// Relative paths are import paths, -trimpath replaces the module directories by
+	// "module@version" and removes GOROOT/src.
+	if isVersioned(path) {
+		return path, true
+	}
+	for _, m := range r.modules {
+		if rel, ok := strings.CutPrefix(path, m.path+"/"); ok {
+			return m.path + "@" + m.version + "/" + rel, true
+		}
+	}
+	if first, _, ok := strings.Cut(path, "/"); ok && !strings.Contains(first, ".") {
+		// The first element of the import paths of the standard library has no dot.
+		return r.std(path), true
+	}
+	return "", false
+}
+
+func (r *goModulesRule) std(rel string) string {
+	return StdModule + "@" + r.goVersion + "/" + rel
+}
+
+// isVersioned reports whether path already starts with "module@version/".
+func isVersioned(path string) bool {
+	at := strings.Index(path, "@")
+	return at > 0 && strings.Contains(path[:at], ".") && strings.Contains(path[at:], "/")
+}
+
+// moduleCachePath returns "module@version/relpath" for a path relative to the module cache,
+// in which upper case letters are escaped as "!" followed by the lower case letter.
+func moduleCachePath(p string) (string, bool) {
+	at := strings.Index(p, "@")
+	if at <= 0 {
+		return "", false
+	}
+	end := strings.Index(p[at:], "/")
+	if end < 0 {
+		return "", false
+	}
+	end += at
+	return unescapeModulePath(p[:end]) + p[end:], true
+}
+
+func unescapeModulePath(s string) string {
+	if !strings.Contains(s, "!") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '!' && i+1 < len(s) && 'a' <= s[i+1] && s[i+1] <= 'z' {
+			b.WriteByte(s[i+1] - 'a' + 'A')
+			i++
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,125 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pathmap rewrites the source file paths recorded in binaries, which depend on
+// where and how they were built, e.g. "/home/runner/work/...", "/usr/local/go/src/..."
+// or "github.com/x/y@v1.2.3/..." for -trimpath builds.
+package pathmap
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rule rewrites a source file path.
+type Rule interface {
+	// Remap returns the rewritten path and true if the rule applies to path.
+	Remap(path string) (string, bool)
+}
+
+// Mapper rewrites source file paths with an ordered list of rules.
+type Mapper struct {
+	rules []Rule
+}
+
+// New creates a new Mapper. The rules are tried in order and the first one that
+// applies rewrites the path.
+func New(rules ...Rule) *Mapper {
+	return &Mapper{rules: rules}
+}
+
+// Remap rewrites path with the first matching rule, it's returned unchanged if none applies.
+// A nil Mapper doesn't rewrite anything.
+func (m *Mapper) Remap(path string) string {
+	if m == nil {
+		return path
+	}
+	for _, r := range m.rules {
+		if p, ok := r.Remap(path); ok {
+			return p
+		}
+	}
+	return path
+}
+
+type prefixRule struct {
+	from, to string
+}
+
+// Prefix returns a rule replacing the leading directory from by to,
+// like the -fdebug-prefix-map option of the C compilers.
+func Prefix(from, to string) Rule {
+	return prefixRule{from: strings.TrimSuffix(from, "/"), to: strings.TrimSuffix(to, "/")}
+}
+
+func (r prefixRule) Remap(path string) (string, bool) {
+	if !strings.HasPrefix(path, r.from) {
+		return "", false
+	}
+	rest := path[len(r.from):]
+	if rest != "" && rest[0] != '/' {
+		// Only whole path elements match, "/src" isn't a prefix of "/srv/a.go".
+		return "", false
+	}
+	if r.to == "" {
+		return strings.TrimPrefix(rest, "/"), true
+	}
+	return r.to + rest, true
+}
+
+type regexpRule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// Regexp returns a rule replacing the matches of expr by repl,
+// which can refer to submatches, see regexp.Regexp.Expand.
+func Regexp(expr, repl string) (Rule, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile path regexp: %w", err)
+	}
+	return regexpRule{re: re, repl: repl}, nil
+}
+
+func (r regexpRule) Remap(path string) (string, bool) {
+	if !r.re.MatchString(path) {
+		return "", false
+	}
+	return r.re.ReplaceAllString(path, r.repl), true
+}
+
+// ParseRule parses a rule given on the command line or in a configuration file:
+//
+//	from=to                   prefix rule
+//	regexp:expr=replacement   regular expression rule, split at the last "="
+func ParseRule(s string) (Rule, error) {
+	if expr, ok := strings.CutPrefix(s, "regexp:"); ok {
+		i := strings.LastIndex(expr, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid path rule %q: missing \"=\"", s)
+		}
+		return Regexp(expr[:i], expr[i+1:])
+	}
+
+	from, to, ok := strings.Cut(s, "=")
+	if !ok {
+		return nil, fmt.Errorf("invalid path rule %q: missing \"=\"", s)
+	}
+	if from == "" {
+		return nil, errors.New("invalid path rule: empty prefix")
+	}
+	return Prefix(from, to), nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pathmap
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapper(t *testing.T) {
+	re, err := Regexp(`^/tmp/bazel-[0-9a-f]+/execroot/`, "bazel/")
+	require.NoError(t, err)
+	m := New(
+		Prefix("/home/runner/work/app/app/", "app"),
+		Prefix("/build", ""),
+		re,
+	)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/home/runner/work/app/app/cmd/main.go", want: "app/cmd/main.go"},
+		{path: "/build/src/foo.c", want: "src/foo.c"},
+		{path: "/builder/src/foo.c", want: "/builder/src/foo.c"},
+		{path: "/tmp/bazel-0f3a/execroot/lib/a.cc", want: "bazel/lib/a.cc"},
+		{path: "/usr/include/stdio.h", want: "/usr/include/stdio.h"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			require.Equal(t, tt.want, m.Remap(tt.path))
+		})
+	}
+
+	var nilMapper *Mapper
+	require.Equal(t, "/a/b.go", nilMapper.Remap("/a/b.go"))
+}
+
+func TestParseRule(t *testing.T) {
+	r, err := ParseRule("/usr/src/debug=/src")
+	require.NoError(t, err)
+	p, ok := r.Remap("/usr/src/debug/glibc/malloc.c")
+	require.True(t, ok)
+	require.Equal(t, "/src/glibc/malloc.c", p)
+
+	r, err = ParseRule(`regexp:^/go/pkg/mod/([^@]+)@=$1@`)
+	require.NoError(t, err)
+	p, ok = r.Remap("/go/pkg/mod/github.com/x/y@v1.0.0/y.go")
+	require.True(t, ok)
+	require.Equal(t, "github.com/x/y@v1.0.0/y.go", p)
+
+	_, err = ParseRule("/usr/src")
+	require.Error(t, err)
+	_, err = ParseRule("regexp:(=x")
+	require.Error(t, err)
+}
+
+func TestGoModules(t *testing.T) {
+	bi := &debug.BuildInfo{
+		GoVersion: "go1.21.3",
+		Path:      "example.com/app/cmd/app",
+		Main:      debug.Module{Path: "example.com/app", Version: "(devel)"},
+		Deps: []*debug.Module{
+			{Path: "github.com/BurntSushi/toml", Version: "v1.2.0"},
+			{Path: "golang.org/x/sys", Version: "v0.18.0"},
+			{Path: "golang.org/x/sys/unix/extra", Version: "v0.1.0"},
+		},
+	}
+	r := GoModules(bi, "/usr/local/go", "/home/runner/work/app/app")
+
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{path: "/usr/local/go/src/runtime/proc.go", want: "std@go1.21.3/runtime/proc.go", ok: true},
+		{path: "/home/runner/work/app/app/cmd/app/main.go", want: "example.com/app@(devel)/cmd/app/main.go", ok: true},
+		{path: "/root/go/pkg/mod/github.com/!burnt!sushi/toml@v1.2.0/decode.go", want: "github.com/BurntSushi/toml@v1.2.0/decode.go", ok: true},
+		{path: "/home/runner/work/app/app/vendor/golang.org/x/sys/unix/syscall.go", want: "golang.org/x/sys@v0.18.0/unix/syscall.go", ok: true},
+		{path: "/home/runner/work/app/app/vendor/golang.org/x/sys/unix/extra/e.go", want: "golang.org/x/sys/unix/extra@v0.1.0/e.go", ok: true},
+		// -trimpath
+		{path: "github.com/BurntSushi/toml@v1.2.0/decode.go", want: "github.com/BurntSushi/toml@v1.2.0/decode.go", ok: true},
+		{path: "example.com/app/cmd/app/main.go", want: "example.com/app@(devel)/cmd/app/main.go", ok: true},
+		{path: "runtime/proc.go", want: "std@go1.21.3/runtime/proc.go", ok: true},
+		{path: "internal/runtime/maps/map.go", want: "std@go1.21.3/internal/runtime/maps/map.go", ok: true},
+		// Not Go source files.
+		{path: "/usr/include/stdio.h", ok: false},
+		{path: "<autogenerated>", ok: false},
+		{path: "_cgo_gotypes.go", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := r.Remap(tt.path)
+			require.Equal(t, tt.ok, ok)
+			if tt.ok {
+				require.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolsearcher
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"gitlab.com/Raven-IO/GoSymTable/symbol/elfutils"
+)
+
+// The index file format, all integers are little endian:
+//
+//	header       magic "GSTI", version uint16, reserved uint16, symbol count uint32,
+//	             build ID length uint32, string table length uint32, reserved uint32
+//	build ID     padded to 8 bytes
+//	symbols      sorted by address: value uint64, size uint64, name offset uint32,
+//	             info uint8, other uint8, reserved uint16
+//	string table NUL terminated names
+const (
+	// IndexVersion is the version of the index format written by WriteIndex.
+	IndexVersion = 1
+
+	indexMagic      = "GSTI"
+	indexHeaderSize = 24
+	indexEntrySize  = 24
+)
+
+// IndexFileExt is the extension of index files, named after the build ID of the binary.
+const IndexFileExt = ".symidx"
+
+// Index is a Searcher stored in the compact on-disk format written by WriteIndex.
+// It's searched in place, without decoding the symbols up front.
+type Index struct {
+	buildID string
+	count   int
+	entries []byte
+	strtab  []byte
+
+	mapping *elfutils.MappedFile
+
+	// Strict has the same meaning as Searcher.Strict.
+	Strict bool
+}
+
+// WriteIndex writes the symbols of s and the build ID of their binary as an index.
+// Of the symbols at the same address only the one Search returns is kept.
+func WriteIndex(w io.Writer, buildID string, s Searcher) error {
+	syms := make([]elf.Symbol, 0, len(s.symbols))
+	for i, sym := range s.symbols {
+		if i+1 < len(s.symbols) && s.symbols[i+1].Value == sym.Value {
+			continue
+		}
+		syms = append(syms, sym)
+	}
+
+	var strtab bytes.Buffer
+	names := make(map[string]uint32, len(syms))
+	for _, sym := range syms {
+		if _, ok := names[sym.Name]; !ok {
+			names[sym.Name] = uint32(strtab.Len())
+			strtab.WriteString(sym.Name)
+			strtab.WriteByte(0)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	header := make([]byte, indexHeaderSize)
+	copy(header, indexMagic)
+	binary.LittleEndian.PutUint16(header[4:], IndexVersion)
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(syms)))
+	binary.LittleEndian.PutUint32(header[12:], uint32(len(buildID)))
+	binary.LittleEndian.PutUint32(header[16:], uint32(strtab.Len()))
+	bw.Write(header)
+	bw.WriteString(buildID)
+	bw.Write(make([]byte, pad8(len(buildID))))
+
+	entry := make([]byte, indexEntrySize)
+	for _, sym := range syms {
+		binary.LittleEndian.PutUint64(entry, sym.Value)
+		binary.LittleEndian.PutUint64(entry[8:], sym.Size)
+		binary.LittleEndian.PutUint32(entry[16:], names[sym.Name])
+		entry[20], entry[21] = sym.Info, sym.Other
+		bw.Write(entry)
+	}
+	bw.Write(strtab.Bytes())
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write symbol index: %w", err)
+	}
+	return nil
+}
+
+// NewIndex returns the index stored in data, which must stay unchanged while it's used.
+func NewIndex(data []byte) (*Index, error) {
+	if len(data) < indexHeaderSize || string(data[:4]) != indexMagic {
+		return nil, errors.New("invalid symbol index header")
+	}
+	if v := binary.LittleEndian.Uint16(data[4:]); v != IndexVersion {
+		return nil, fmt.Errorf("unsupported symbol index version %d", v)
+	}
+	count := uint64(binary.LittleEndian.Uint32(data[8:]))
+	buildIDLen := uint64(binary.LittleEndian.Uint32(data[12:]))
+	strtabLen := uint64(binary.LittleEndian.Uint32(data[16:]))
+
+	entriesOff := indexHeaderSize + buildIDLen + uint64(pad8(int(buildIDLen)))
+	strtabOff := entriesOff + count*indexEntrySize
+	if strtabOff+strtabLen != uint64(len(data)) {
+		return nil, errors.New("invalid symbol index size")
+	}
+	return &Index{
+		buildID: string(data[indexHeaderSize : indexHeaderSize+buildIDLen]),
+		count:   int(count),
+		entries: data[entriesOff:strtabOff],
+		strtab:  data[strtabOff:],
+	}, nil
+}
+
+// OpenIndex memory maps the index file filename.
+func OpenIndex(filename string) (*Index, error) {
+	m, err := elfutils.MmapFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map symbol index: %w", err)
+	}
+	idx, err := NewIndex(m.Data)
+	if err != nil {
+		m.Close()
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	idx.mapping = m
+	return idx, nil
+}
+
+// Close unmaps the file of an index opened with OpenIndex.
+func (x *Index) Close() error {
+	if x.mapping == nil {
+		return nil
+	}
+	return x.mapping.Close()
+}
+
+// BuildID returns the build ID of the binary the index was built from.
+func (x *Index) BuildID() string {
+	return x.buildID
+}
+
+// Len returns the number of symbols of the index.
+func (x *Index) Len() int {
+	return x.count
+}
+
+func (x *Index) value(i int) uint64 {
+	return binary.LittleEndian.Uint64(x.entries[i*indexEntrySize:])
+}
+
+// symbol decodes the i-th symbol.
+func (x *Index) symbol(i int) elf.Symbol {
+	e := x.entries[i*indexEntrySize : (i+1)*indexEntrySize]
+	name := binary.LittleEndian.Uint32(e[16:])
+	var s string
+	if uint64(name) < uint64(len(x.strtab)) {
+		b := x.strtab[name:]
+		if end := bytes.IndexByte(b, 0); end >= 0 {
+			b = b[:end]
+		}
+		s = string(b)
+	}
+	return elf.Symbol{
+		Name:  s,
+		Info:  e[20],
+		Other: e[21],
+		Value: binary.LittleEndian.Uint64(e),
+		Size:  binary.LittleEndian.Uint64(e[8:]),
+	}
+}
+
+func (x *Index) Search(addr uint64) (string, error) {
+	sym, err := x.SearchSymbol(addr)
+	if err != nil {
+		return "", err
+	}
+	return sym.Name, nil
+}
+
+// SearchSymbol returns the symbol with the highest start address
+// that is lower than or equal to addr, see Searcher.SearchSymbol.
+func (x *Index) SearchSymbol(addr uint64) (elf.Symbol, error) {
+	i := sort.Search(x.count, func(i int) bool {
+		return x.value(i) > addr
+	})
+	if i == 0 {
+		return elf.Symbol{}, x.notFound(addr, i)
+	}
+
+	sym := x.symbol(i - 1)
+	if x.Strict && sym.Size > 0 && addr >= sym.Value+sym.Size {
+		return elf.Symbol{}, x.notFound(addr, i)
+	}
+	return sym, nil
+}
+
+func (x *Index) notFound(addr uint64, next int) *NotFoundError {
+	err := &NotFoundError{Addr: addr}
+	if next > 0 {
+		prev := x.symbol(next - 1)
+		err.Prev = &prev
+	}
+	if next < x.count {
+		n := x.symbol(next)
+		err.Next = &n
+	}
+	return err
+}
+
+func (x *Index) PCRange() ([2]uint64, error) {
+	if x.count == 0 {
+		return [2]uint64{}, errors.New("no symbols found")
+	}
+	last := x.symbol(x.count - 1)
+	return [2]uint64{x.value(0), last.Value + last.Size}, nil
+}
+
+func pad8(n int) int {
+	return -n & 7
+}
@@ -0,0 +1,99 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolsearcher
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	fn := func(name string, bind elf.SymBind, value, size uint64) elf.Symbol {
+		return elf.Symbol{
+			Name:    name,
+			Info:    elf.ST_INFO(bind, elf.STT_FUNC),
+			Section: elf.SectionIndex(1),
+			Value:   value,
+			Size:    size,
+		}
+	}
+	s := New([]elf.Symbol{
+		fn("foo", elf.STB_GLOBAL, 0x1000, 0x20),
+		fn("foo_alias", elf.STB_WEAK, 0x1000, 0x20),
+		fn("bar", elf.STB_LOCAL, 0x1040, 0x10),
+		fn("unsized", elf.STB_GLOBAL, 0x1080, 0),
+		fn("baz", elf.STB_GLOBAL, 0x1100, 0x40),
+	})
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteIndex(&buf, "49b1b3a9", s))
+
+	// Write the index to a file to search it memory mapped.
+	path := filepath.Join(t.TempDir(), "49b1b3a9"+IndexFileExt)
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+	idx, err := OpenIndex(path)
+	require.NoError(t, err)
+	defer idx.Close()
+
+	require.Equal(t, "49b1b3a9", idx.BuildID())
+	require.Equal(t, 4, idx.Len()) // foo_alias is never returned.
+
+	want, err := s.PCRange()
+	require.NoError(t, err)
+	got, err := idx.PCRange()
+	require.NoError(t, err)
+	require.Equal(t, want, got)
+
+	for _, strict := range []bool{false, true} {
+		s.Strict, idx.Strict = strict, strict
+		for addr := uint64(0xff0); addr < 0x1150; addr += 4 {
+			want, wantErr := s.SearchSymbol(addr)
+			got, err := idx.SearchSymbol(addr)
+			if wantErr != nil {
+				require.Equal(t, wantErr.Error(), err.Error(), "addr %#x", addr)
+				continue
+			}
+			require.NoError(t, err, "addr %#x", addr)
+			require.Equal(t, want.Name, got.Name, "addr %#x", addr)
+			require.Equal(t, want.Value, got.Value, "addr %#x", addr)
+			require.Equal(t, want.Size, got.Size, "addr %#x", addr)
+			require.Equal(t, want.Info, got.Info, "addr %#x", addr)
+		}
+	}
+}
+
+func TestNewIndex_Invalid(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteIndex(&buf, "id", New(nil)))
+	data := buf.Bytes()
+
+	_, err := NewIndex(data)
+	require.NoError(t, err)
+
+	_, err = NewIndex(data[:len(data)-1])
+	require.Error(t, err)
+
+	bad := append([]byte{}, data...)
+	bad[4] = IndexVersion + 1
+	_, err = NewIndex(bad)
+	require.ErrorContains(t, err, "unsupported symbol index version")
+
+	_, err = NewIndex([]byte("ELF"))
+	require.Error(t, err)
+}
@@ -0,0 +1,157 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolsearcher
+
+import (
+	"debug/elf"
+	"errors"
+	"sort"
+)
+
+// IntervalSearcher looks up symbols by the address interval they cover, so that nested
+// or overlapping symbols resolve deterministically: aliases of different sizes, labels of
+// hand-written assembly inside functions or outlined regions placed inside their parent.
+//
+// The symbols without a size are assumed to extend up to the next symbol, or to cover
+// only their address for the last one.
+type IntervalSearcher struct {
+	// symbols are sorted by address, then from the worst to the best alias.
+	symbols []elf.Symbol
+	ends    []uint64
+	// maxEnd is the highest end of the symbols of the subtree rooted at each index,
+	// of the implicit balanced binary search tree over symbols.
+	maxEnd []uint64
+}
+
+// NewInterval creates an IntervalSearcher over the function symbols of syms.
+func NewInterval(syms []elf.Symbol) *IntervalSearcher {
+	s := &IntervalSearcher{symbols: New(syms).symbols}
+
+	s.ends = make([]uint64, len(s.symbols))
+	next := 0
+	for i, sym := range s.symbols {
+		if sym.Size > 0 {
+			s.ends[i] = sym.Value + sym.Size
+			continue
+		}
+		for next < len(s.symbols) && s.symbols[next].Value <= sym.Value {
+			next++
+		}
+		if next < len(s.symbols) {
+			s.ends[i] = s.symbols[next].Value
+		} else {
+			s.ends[i] = sym.Value + 1
+		}
+	}
+
+	s.maxEnd = make([]uint64, len(s.symbols))
+	s.buildMaxEnd(0, len(s.symbols))
+	return s
+}
+
+func (s *IntervalSearcher) buildMaxEnd(lo, hi int) uint64 {
+	if lo >= hi {
+		return 0
+	}
+	mid := int(uint(lo+hi) >> 1)
+	m := max(s.ends[mid], s.buildMaxEnd(lo, mid), s.buildMaxEnd(mid+1, hi))
+	s.maxEnd[mid] = m
+	return m
+}
+
+// SearchAll returns the symbols containing addr, from the outermost to the innermost,
+// see SearchSymbol for the order.
+func (s *IntervalSearcher) SearchAll(addr uint64) []elf.Symbol {
+	var idx []int
+	s.collect(0, len(s.symbols), addr, &idx)
+	sort.SliceStable(idx, func(a, b int) bool {
+		return s.inner(idx[b], idx[a])
+	})
+
+	syms := make([]elf.Symbol, len(idx))
+	for i, j := range idx {
+		syms[i] = s.symbols[j]
+	}
+	return syms
+}
+
+// collect appends the indexes of the symbols of symbols[lo:hi] containing addr.
+func (s *IntervalSearcher) collect(lo, hi int, addr uint64, idx *[]int) {
+	if lo >= hi {
+		return
+	}
+	mid := int(uint(lo+hi) >> 1)
+	if s.maxEnd[mid] <= addr {
+		// Everything in the subtree ends before addr.
+		return
+	}
+	s.collect(lo, mid, addr, idx)
+	if s.symbols[mid].Value > addr {
+		// The symbols on the right start after addr.
+		return
+	}
+	if addr < s.ends[mid] {
+		*idx = append(*idx, mid)
+	}
+	s.collect(mid+1, hi, addr, idx)
+}
+
+// inner reports whether the symbol i is nested in the symbol j: it's the smaller, or the
+// one starting last for symbols of the same size, or the best of aliases, see chooseBestSymbol.
+func (s *IntervalSearcher) inner(i, j int) bool {
+	si, sj := s.ends[i]-s.symbols[i].Value, s.ends[j]-s.symbols[j].Value
+	if si != sj {
+		return si < sj
+	}
+	if s.symbols[i].Value != s.symbols[j].Value {
+		return s.symbols[i].Value > s.symbols[j].Value
+	}
+	return i > j
+}
+
+func (s *IntervalSearcher) Search(addr uint64) (string, error) {
+	sym, err := s.SearchSymbol(addr)
+	if err != nil {
+		return "", err
+	}
+	return sym.Name, nil
+}
+
+// SearchSymbol returns the innermost symbol containing addr, i.e. the smallest one.
+// A *NotFoundError is returned if no symbol contains addr.
+func (s *IntervalSearcher) SearchSymbol(addr uint64) (elf.Symbol, error) {
+	var idx []int
+	s.collect(0, len(s.symbols), addr, &idx)
+	if len(idx) == 0 {
+		next := sort.Search(len(s.symbols), func(i int) bool {
+			return s.symbols[i].Value > addr
+		})
+		return elf.Symbol{}, Searcher{symbols: s.symbols}.notFound(addr, next)
+	}
+
+	best := idx[0]
+	for _, i := range idx[1:] {
+		if s.inner(i, best) {
+			best = i
+		}
+	}
+	return s.symbols[best], nil
+}
+
+func (s *IntervalSearcher) PCRange() ([2]uint64, error) {
+	if len(s.symbols) == 0 {
+		return [2]uint64{}, errors.New("no symbols found")
+	}
+	return [2]uint64{s.symbols[0].Value, s.maxEnd[len(s.symbols)/2]}, nil
+}
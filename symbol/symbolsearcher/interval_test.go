@@ -0,0 +1,115 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolsearcher
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntervalSearcher(t *testing.T) {
+	fn := func(name string, value, size uint64) elf.Symbol {
+		return elf.Symbol{
+			Name:    name,
+			Info:    elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
+			Section: elf.SectionIndex(1),
+			Value:   value,
+			Size:    size,
+		}
+	}
+	s := NewInterval([]elf.Symbol{
+		fn("outer", 0x100, 0x100),
+		fn("outer_alias", 0x100, 0x100),
+		fn("outer_head", 0x100, 0x10),
+		fn("asm_label", 0x140, 0x20),
+		fn("omp_outlined", 0x150, 0x8),
+		fn("unsized", 0x300, 0),
+		fn("last", 0x400, 0x10),
+	})
+
+	tests := []struct {
+		addr    uint64
+		want    string
+		wantAll []string
+	}{
+		{addr: 0x100, want: "outer_head", wantAll: []string{"outer", "outer_alias", "outer_head"}},
+		{addr: 0x120, want: "outer_alias", wantAll: []string{"outer", "outer_alias"}},
+		{addr: 0x145, want: "asm_label", wantAll: []string{"outer", "outer_alias", "asm_label"}},
+		{addr: 0x154, want: "omp_outlined", wantAll: []string{"outer", "outer_alias", "asm_label", "omp_outlined"}},
+		{addr: 0x1ff, want: "outer_alias", wantAll: []string{"outer", "outer_alias"}},
+		{addr: 0x3ff, want: "unsized", wantAll: []string{"unsized"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%#x", tt.addr), func(t *testing.T) {
+			sym, err := s.SearchSymbol(tt.addr)
+			require.NoError(t, err)
+			require.Equal(t, tt.want, sym.Name)
+
+			var names []string
+			for _, sym := range s.SearchAll(tt.addr) {
+				names = append(names, sym.Name)
+			}
+			require.Equal(t, tt.wantAll, names)
+		})
+	}
+
+	// Gaps between the symbols.
+	_, err := s.SearchSymbol(0x200)
+	var nf *NotFoundError
+	require.True(t, errors.As(err, &nf))
+	require.Equal(t, "omp_outlined", nf.Prev.Name)
+	require.Equal(t, "unsized", nf.Next.Name)
+	_, err = s.SearchSymbol(0x10)
+	require.Error(t, err)
+	require.Empty(t, s.SearchAll(0x410))
+
+	r, err := s.PCRange()
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{0x100, 0x410}, r)
+}
+
+func TestIntervalSearcher_Random(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	var syms []elf.Symbol
+	for i := 0; i < 500; i++ {
+		syms = append(syms, elf.Symbol{
+			Name:    fmt.Sprintf("f%d", i),
+			Info:    elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
+			Section: elf.SectionIndex(1),
+			Value:   uint64(rnd.Intn(10000)),
+			Size:    uint64(1 + rnd.Intn(300)),
+		})
+	}
+	s := NewInterval(syms)
+
+	// Same result as checking every symbol.
+	for addr := uint64(0); addr < 10400; addr += 7 {
+		var want []string
+		for _, sym := range syms {
+			if sym.Value <= addr && addr < sym.Value+sym.Size {
+				want = append(want, sym.Name)
+			}
+		}
+		var got []string
+		for _, sym := range s.SearchAll(addr) {
+			got = append(got, sym.Name)
+		}
+		require.ElementsMatch(t, want, got, "addr %#x", addr)
+	}
+}
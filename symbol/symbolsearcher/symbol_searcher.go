@@ -16,18 +16,57 @@ package symbolsearcher
 import (
 	"debug/elf"
 	"errors"
+	"fmt"
 	"sort"
 	"strings"
 )
 
 type Searcher struct {
 	symbols []elf.Symbol
+
+	// Strict makes the searches fail with a *NotFoundError for addresses past the end
+	// of the closest lower symbol, e.g. in padding or in code without symbols.
+	// Symbols without a size are assumed to extend up to the next symbol.
+	Strict bool
+}
+
+// NotFoundError is returned by the searches when no symbol contains the address.
+// The symbols around the gap, if any, tell where the address is.
+type NotFoundError struct {
+	Addr uint64
+	// Prev is the closest symbol before Addr, nil if Addr is below the first symbol.
+	Prev *elf.Symbol
+	// Next is the closest symbol after Addr, nil if Addr is above the last symbol.
+	Next *elf.Symbol
+}
+
+func (e *NotFoundError) Error() string {
+	switch {
+	case e.Prev != nil && e.Next != nil:
+		return fmt.Sprintf("failed to find symbol for address %#x: in the gap between %s and %s", e.Addr, e.Prev.Name, e.Next.Name)
+	case e.Prev != nil:
+		return fmt.Sprintf("failed to find symbol for address %#x: past the end of %s", e.Addr, e.Prev.Name)
+	case e.Next != nil:
+		return fmt.Sprintf("failed to find symbol for address %#x: before %s", e.Addr, e.Next.Name)
+	}
+	return fmt.Sprintf("failed to find symbol for address %#x", e.Addr)
 }
 
+// New creates a Searcher over the function symbols of syms.
 func New(syms []elf.Symbol) Searcher {
+	return newSearcher(syms, isFunction)
+}
+
+// NewData creates a Searcher over the data object symbols of syms,
+// e.g. global and static variables.
+func NewData(syms []elf.Symbol) Searcher {
+	return newSearcher(syms, isObject)
+}
+
+func newSearcher(syms []elf.Symbol, keep func(elf.Symbol) bool) Searcher {
 	newSyms := make([]elf.Symbol, 0, len(syms))
 	for _, s := range syms {
-		if isFunction(s) {
+		if keep(s) {
 			newSyms = append(newSyms, s)
 		}
 	}
@@ -44,20 +83,57 @@ func New(syms []elf.Symbol) Searcher {
 	}
 }
 
+// Symbols returns the symbols of the searcher sorted by address, the aliases at the same
+// address from the worst to the best, see chooseBestSymbol. The returned slice must not
+// be modified.
+func (s Searcher) Symbols() []elf.Symbol {
+	return s.symbols
+}
+
 func (s Searcher) Search(addr uint64) (string, error) {
+	sym, err := s.SearchSymbol(addr)
+	if err != nil {
+		return "", err
+	}
+	return sym.Name, nil
+}
+
+// SearchSymbol returns the symbol with the highest start address
+// that is lower than or equal to addr.
+// In strict mode, the symbol must also contain addr if it has a size.
+func (s Searcher) SearchSymbol(addr uint64) (elf.Symbol, error) {
 	i := sort.Search(len(s.symbols), func(i int) bool {
 		sym := s.symbols[i]
 		return sym.Value > addr
 	})
-	if i == 0 ||
-		// addr < sym[i-1]
-		addr < s.symbols[i-1].Value {
-		return "", errors.New("failed to find symbol for address")
+	if i == 0 {
+		return elf.Symbol{}, s.notFound(addr, i)
 	}
 
 	// sym[i-1] <= addr < sym[i]
-	i--
-	return s.symbols[i].Name, nil
+	sym := s.symbols[i-1]
+	if s.Strict && sym.Size > 0 && addr >= sym.Value+sym.Size {
+		return elf.Symbol{}, s.notFound(addr, i)
+	}
+	return sym, nil
+}
+
+// notFound returns the error for addr, the symbols from next on being above it.
+func (s Searcher) notFound(addr uint64, next int) *NotFoundError {
+	err := &NotFoundError{Addr: addr}
+	if next > 0 {
+		prev := s.symbols[next-1]
+		err.Prev = &prev
+	}
+	if next < len(s.symbols) {
+		// The aliases at the same address are sorted from the worst to the best symbol.
+		for next+1 < len(s.symbols) && s.symbols[next+1].Value == s.symbols[next].Value {
+			next++
+		}
+		n := s.symbols[next]
+		err.Next = &n
+	}
+	return err
 }
 
 func (s Searcher) PCRange() ([2]uint64, error) {
@@ -76,6 +152,11 @@ func isFunction(s elf.Symbol) bool {
 	return elf.ST_TYPE(s.Info) == elf.STT_FUNC && s.Name != "" && s.Section != elf.SHN_UNDEF
 }
 
+// isObject reports whether s is a defined data object symbol.
+func isObject(s elf.Symbol) bool {
+	return elf.ST_TYPE(s.Info) == elf.STT_OBJECT && s.Name != "" && s.Section != elf.SHN_UNDEF
+}
+
 // copy from symbol.c/choose_best_symbol.
 func chooseBestSymbol(syma, symb elf.Symbol) bool {
 	/* Prefer a symbol with non zero length */
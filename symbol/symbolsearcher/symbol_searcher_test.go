@@ -0,0 +1,80 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package symbolsearcher
+
+import (
+	"debug/elf"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearcher_SearchSymbol(t *testing.T) {
+	fn := func(name string, value, size uint64) elf.Symbol {
+		return elf.Symbol{
+			Name:    name,
+			Info:    elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
+			Section: elf.SectionIndex(1),
+			Value:   value,
+			Size:    size,
+		}
+	}
+	syms := []elf.Symbol{
+		fn("foo", 0x10, 0x8),
+		fn("unsized", 0x20, 0),
+		fn("bar", 0x30, 0x10),
+	}
+
+	tests := []struct {
+		name     string
+		addr     uint64
+		strict   bool
+		want     string
+		wantPrev string
+		wantNext string
+	}{
+		{name: "inside", addr: 0x14, strict: true, want: "foo"},
+		{name: "padding", addr: 0x18, want: "foo"},
+		{name: "strict padding", addr: 0x18, strict: true, wantPrev: "foo", wantNext: "unsized"},
+		{name: "strict unsized", addr: 0x2f, strict: true, want: "unsized"},
+		{name: "strict past the last symbol", addr: 0x40, strict: true, wantPrev: "bar"},
+		{name: "below the first symbol", addr: 0x8, wantNext: "foo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(syms)
+			s.Strict = tt.strict
+
+			sym, err := s.SearchSymbol(tt.addr)
+			if tt.want != "" {
+				require.NoError(t, err)
+				require.Equal(t, tt.want, sym.Name)
+				return
+			}
+
+			var nf *NotFoundError
+			require.True(t, errors.As(err, &nf))
+			require.Equal(t, tt.addr, nf.Addr)
+			name := func(s *elf.Symbol) string {
+				if s == nil {
+					return ""
+				}
+				return s.Name
+			}
+			require.Equal(t, tt.wantPrev, name(nf.Prev))
+			require.Equal(t, tt.wantNext, name(nf.Next))
+		})
+	}
+}
@@ -0,0 +1,432 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unwind
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-delve/delve/pkg/dwarf/frame"
+	"github.com/go-delve/delve/pkg/dwarf/leb128"
+)
+
+// Call frame instructions, see DWARF 5 section 6.4.2.
+const (
+	cfaNop                       = 0x00
+	cfaSetLoc                    = 0x01
+	cfaAdvanceLoc1               = 0x02
+	cfaAdvanceLoc2               = 0x03
+	cfaAdvanceLoc4               = 0x04
+	cfaOffsetExtended            = 0x05
+	cfaRestoreExtended           = 0x06
+	cfaUndefined                 = 0x07
+	cfaSameValue                 = 0x08
+	cfaRegister                  = 0x09
+	cfaRememberState             = 0x0a
+	cfaRestoreState              = 0x0b
+	cfaDefCFA                    = 0x0c
+	cfaDefCFARegister            = 0x0d
+	cfaDefCFAOffset              = 0x0e
+	cfaDefCFAExpression          = 0x0f
+	cfaExpression                = 0x10
+	cfaOffsetExtendedSf          = 0x11
+	cfaDefCFASf                  = 0x12
+	cfaDefCFAOffsetSf            = 0x13
+	cfaValOffset                 = 0x14
+	cfaValOffsetSf               = 0x15
+	cfaValExpression             = 0x16
+	cfaGNUWindowSave             = 0x2d // Also DW_CFA_AARCH64_negate_ra_state.
+	cfaGNUArgsSize               = 0x2e
+	cfaGNUNegativeOffsetExtended = 0x2f
+
+	cfaAdvanceLoc = 0x1 << 6
+	cfaOffset     = 0x2 << 6
+	cfaRestore    = 0x3 << 6
+	cfaHighMask   = 0x3 << 6
+	cfaLowMask    = 0x3f
+)
+
+// rowState is the state of the CFI virtual machine.
+type rowState struct {
+	cfa  CFARule
+	regs map[uint64]RegisterRule
+}
+
+func (s rowState) clone() rowState {
+	regs := make(map[uint64]RegisterRule, len(s.regs))
+	for reg, rule := range s.regs {
+		regs[reg] = rule
+	}
+	return rowState{cfa: s.cfa, regs: regs}
+}
+
+// ehFDE is how the addresses of an FDE of .eh_frame are encoded.
+type ehFDE struct {
+	// begin is the initial location of the FDE.
+	begin uint64
+	// enc is the pointer encoding of the FDE, from the 'R' augmentation of its CIE.
+	enc byte
+	// addr is the address of the call frame instructions of the FDE.
+	addr uint64
+	// dataBase is the base of the data relative addresses.
+	dataBase uint64
+}
+
+// parseEHFDEs returns the pointer encodings of the FDEs of the .eh_frame data mapped at addr,
+// in the order of the section. The frame package doesn't expose them.
+func parseEHFDEs(data []byte, addr, dataBase uint64, ptrSize int, order binary.ByteOrder) ([]ehFDE, error) {
+	type cie struct {
+		enc byte
+		// augmented is set when the FDEs have augmentation data.
+		augmented bool
+	}
+	var (
+		fdes []ehFDE
+		cies = map[int]cie{}
+	)
+	for off := 0; off+4 <= len(data); {
+		start := off
+		length := uint64(order.Uint32(data[off:]))
+		off += 4
+		if length == 0xffffffff {
+			if off+8 > len(data) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			length = order.Uint64(data[off:])
+			off += 8
+		}
+		if length == 0 {
+			// The terminator.
+			break
+		}
+		if length > uint64(len(data)-off) || length < 4 {
+			return nil, fmt.Errorf("invalid entry length at %#x", start)
+		}
+		end := off + int(length)
+		idOff := off
+		id := order.Uint32(data[off:])
+		off += 4
+
+		if id == 0 {
+			enc, augmented, err := parseCIEEncoding(data[off:end], order, ptrSize)
+			if err != nil {
+				return nil, fmt.Errorf("CIE at %#x: %w", start, err)
+			}
+			cies[start] = cie{enc: enc, augmented: augmented}
+			off = end
+			continue
+		}
+
+		// The CIE pointer is the offset of the CIE from the pointer itself.
+		c, ok := cies[idOff-int(id)]
+		if !ok {
+			return nil, fmt.Errorf("FDE at %#x: unknown CIE", start)
+		}
+		enc := c.enc
+		d := &ehDecoder{data: data[:end], addr: addr, order: order, pos: off, ptrSize: ptrSize, dataBase: dataBase}
+		begin, err := d.read(enc)
+		if err != nil {
+			return nil, fmt.Errorf("FDE at %#x: %w", start, err)
+		}
+		if _, err := d.read(enc & 0x0f); err != nil {
+			return nil, fmt.Errorf("FDE at %#x: %w", start, err)
+		}
+		if c.augmented {
+			r := bytes.NewBuffer(data[d.pos:end])
+			n, _ := leb128.DecodeUnsigned(r)
+			if n > uint64(r.Len()) {
+				return nil, fmt.Errorf("FDE at %#x: invalid augmentation data length", start)
+			}
+			d.pos = end - r.Len() + int(n)
+		}
+		fdes = append(fdes, ehFDE{begin: begin, enc: enc, addr: addr + uint64(d.pos), dataBase: dataBase})
+		off = end
+	}
+	return fdes, nil
+}
+
+// parseCIEEncoding returns the pointer encoding of the FDEs of a CIE of .eh_frame, data
+// following its CIE_id, and whether they have augmentation data.
+func parseCIEEncoding(data []byte, order binary.ByteOrder, ptrSize int) (byte, bool, error) {
+	buf := bytes.NewBuffer(data)
+	version, err := buf.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	augmentation, err := buf.ReadString(0)
+	if err != nil {
+		return 0, false, err
+	}
+	augmentation = augmentation[:len(augmentation)-1]
+	if augmentation == "" {
+		return pePtr, false, nil
+	}
+	if augmentation[0] != 'z' {
+		return 0, false, fmt.Errorf("unsupported augmentation %q", augmentation)
+	}
+	leb128.DecodeUnsigned(buf) // code alignment factor
+	leb128.DecodeSigned(buf)   // data alignment factor
+	if version == 1 {
+		buf.ReadByte() // return address register
+	} else {
+		leb128.DecodeUnsigned(buf)
+	}
+	leb128.DecodeUnsigned(buf) // augmentation data length
+
+	for _, c := range augmentation[1:] {
+		switch c {
+		case 'R':
+			enc, err := buf.ReadByte()
+			return enc, true, err
+		case 'L':
+			buf.ReadByte()
+		case 'P':
+			enc, err := buf.ReadByte()
+			if err != nil {
+				return 0, false, err
+			}
+			d := &ehDecoder{data: buf.Bytes(), order: order, ptrSize: ptrSize}
+			if _, err := d.read(enc &^ 0x80); err != nil {
+				return 0, false, fmt.Errorf("personality: %w", err)
+			}
+			buf.Next(d.pos)
+		case 'S', 'B':
+		default:
+			return 0, false, fmt.Errorf("unsupported augmentation %q", augmentation)
+		}
+	}
+	return pePtr, true, nil
+}
+
+// cfiMachine executes the call frame instructions of an FDE and records the rows of the table.
+type cfiMachine struct {
+	fde     *frame.FrameDescriptionEntry
+	ptrSize int
+	order   binary.ByteOrder
+	// eh is the encoding of the addresses of an FDE of .eh_frame, nil for .debug_frame.
+	eh *ehFDE
+
+	loc     uint64
+	state   rowState
+	initial rowState
+	stack   []rowState
+	rows    []Row
+}
+
+// executeFDE returns the rows of the table described by the call frame instructions of fde.
+// eh is the encoding of the addresses of fde if it comes from .eh_frame, nil otherwise.
+func executeFDE(fde *frame.FrameDescriptionEntry, eh *ehFDE, ptrSize int, order binary.ByteOrder) ([]Row, error) {
+	m := &cfiMachine{
+		fde:     fde,
+		eh:      eh,
+		ptrSize: ptrSize,
+		order:   order,
+		loc:     fde.Begin(),
+		state:   rowState{regs: map[uint64]RegisterRule{}},
+	}
+
+	// The initial instructions of the CIE set up the default rules,
+	// which DW_CFA_restore goes back to.
+	if err := m.execute(fde.CIE.InitialInstructions, false); err != nil {
+		return nil, fmt.Errorf("CIE initial instructions: %w", err)
+	}
+	m.initial = m.state.clone()
+
+	if err := m.execute(fde.Instructions, true); err != nil {
+		return nil, err
+	}
+	m.emit(fde.End())
+	return m.rows, nil
+}
+
+// emit records the current state as a row covering [m.loc, end).
+func (m *cfiMachine) emit(end uint64) {
+	if end <= m.loc {
+		return
+	}
+	state := m.state.clone()
+	m.rows = append(m.rows, Row{
+		Start:                 m.loc,
+		End:                   end,
+		CFA:                   state.cfa,
+		Registers:             state.regs,
+		ReturnAddressRegister: m.fde.CIE.ReturnAddressRegister,
+	})
+}
+
+// advance moves the location of the machine to loc, emitting the row for the previous location.
+func (m *cfiMachine) advance(loc uint64, inFDE bool) {
+	if inFDE {
+		m.emit(loc)
+	}
+	m.loc = loc
+}
+
+func (m *cfiMachine) execute(instructions []byte, inFDE bool) error {
+	var (
+		cie   = m.fde.CIE
+		order = m.order
+		buf   = bytes.NewBuffer(instructions)
+	)
+
+	uleb := func() uint64 {
+		v, _ := leb128.DecodeUnsigned(buf)
+		return v
+	}
+	sleb := func() int64 {
+		v, _ := leb128.DecodeSigned(buf)
+		return v
+	}
+	block := func() ([]byte, error) {
+		n := uleb()
+		if n > uint64(buf.Len()) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return append([]byte(nil), buf.Next(int(n))...), nil
+	}
+	restore := func(reg uint64) {
+		if rule, ok := m.initial.regs[reg]; ok {
+			m.state.regs[reg] = rule
+		} else {
+			delete(m.state.regs, reg)
+		}
+	}
+	factored := func(v uint64) int64 {
+		return int64(v) * cie.DataAlignmentFactor
+	}
+
+	for buf.Len() > 0 {
+		op, _ := buf.ReadByte()
+
+		switch op & cfaHighMask {
+		case cfaAdvanceLoc:
+			m.advance(m.loc+uint64(op&cfaLowMask)*cie.CodeAlignmentFactor, inFDE)
+			continue
+		case cfaOffset:
+			m.state.regs[uint64(op&cfaLowMask)] = RegisterRule{Type: RuleOffset, Offset: factored(uleb())}
+			continue
+		case cfaRestore:
+			restore(uint64(op & cfaLowMask))
+			continue
+		}
+
+		switch op {
+		case cfaNop:
+		case cfaSetLoc:
+			// The operand is encoded like the initial location of the FDE, an absolute
+			// address of .debug_frame.
+			d := &ehDecoder{data: instructions, order: order, pos: len(instructions) - buf.Len(), ptrSize: m.ptrSize}
+			enc := byte(pePtr)
+			if m.eh != nil && inFDE {
+				d.addr, d.dataBase, enc = m.eh.addr, m.eh.dataBase, m.eh.enc
+			}
+			start := d.pos
+			loc, err := d.read(enc)
+			if err != nil {
+				return fmt.Errorf("DW_CFA_set_loc: %w", err)
+			}
+			buf.Next(d.pos - start)
+			m.advance(loc, inFDE)
+		case cfaAdvanceLoc1:
+			delta, err := buf.ReadByte()
+			if err != nil {
+				return io.ErrUnexpectedEOF
+			}
+			m.advance(m.loc+uint64(delta)*cie.CodeAlignmentFactor, inFDE)
+		case cfaAdvanceLoc2:
+			if buf.Len() < 2 {
+				return io.ErrUnexpectedEOF
+			}
+			m.advance(m.loc+uint64(order.Uint16(buf.Next(2)))*cie.CodeAlignmentFactor, inFDE)
+		case cfaAdvanceLoc4:
+			if buf.Len() < 4 {
+				return io.ErrUnexpectedEOF
+			}
+			m.advance(m.loc+uint64(order.Uint32(buf.Next(4)))*cie.CodeAlignmentFactor, inFDE)
+		case cfaOffsetExtended:
+			reg := uleb()
+			m.state.regs[reg] = RegisterRule{Type: RuleOffset, Offset: factored(uleb())}
+		case cfaOffsetExtendedSf:
+			reg := uleb()
+			m.state.regs[reg] = RegisterRule{Type: RuleOffset, Offset: sleb() * cie.DataAlignmentFactor}
+		case cfaGNUNegativeOffsetExtended:
+			reg := uleb()
+			m.state.regs[reg] = RegisterRule{Type: RuleOffset, Offset: -factored(uleb())}
+		case cfaValOffset:
+			reg := uleb()
+			m.state.regs[reg] = RegisterRule{Type: RuleValOffset, Offset: factored(uleb())}
+		case cfaValOffsetSf:
+			reg := uleb()
+			m.state.regs[reg] = RegisterRule{Type: RuleValOffset, Offset: sleb() * cie.DataAlignmentFactor}
+		case cfaRestoreExtended:
+			restore(uleb())
+		case cfaUndefined:
+			m.state.regs[uleb()] = RegisterRule{Type: RuleUndefined}
+		case cfaSameValue:
+			m.state.regs[uleb()] = RegisterRule{Type: RuleSameValue}
+		case cfaRegister:
+			reg := uleb()
+			m.state.regs[reg] = RegisterRule{Type: RuleRegister, Reg: uleb()}
+		case cfaRememberState:
+			m.stack = append(m.stack, m.state.clone())
+		case cfaRestoreState:
+			if len(m.stack) == 0 {
+				return errors.New("DW_CFA_restore_state with an empty stack")
+			}
+			// The CFA rule is part of the saved state as well.
+			m.state = m.stack[len(m.stack)-1]
+			m.stack = m.stack[:len(m.stack)-1]
+		case cfaDefCFA:
+			m.state.cfa = CFARule{Reg: uleb(), Offset: int64(uleb())}
+		case cfaDefCFASf:
+			reg := uleb()
+			m.state.cfa = CFARule{Reg: reg, Offset: sleb() * cie.DataAlignmentFactor}
+		case cfaDefCFARegister:
+			m.state.cfa.Reg = uleb()
+			m.state.cfa.Expression = nil
+		case cfaDefCFAOffset:
+			m.state.cfa.Offset = int64(uleb())
+		case cfaDefCFAOffsetSf:
+			m.state.cfa.Offset = sleb() * cie.DataAlignmentFactor
+		case cfaDefCFAExpression:
+			expr, err := block()
+			if err != nil {
+				return err
+			}
+			m.state.cfa = CFARule{Expression: expr}
+		case cfaExpression, cfaValExpression:
+			reg := uleb()
+			expr, err := block()
+			if err != nil {
+				return err
+			}
+			typ := RuleExpression
+			if op == cfaValExpression {
+				typ = RuleValExpression
+			}
+			m.state.regs[reg] = RegisterRule{Type: typ, Expression: expr}
+		case cfaGNUArgsSize:
+			uleb()
+		case cfaGNUWindowSave:
+			// Only relevant for SPARC register windows and AArch64 pointer authentication,
+			// it doesn't affect the location of the saved registers.
+		default:
+			return fmt.Errorf("unknown call frame instruction %#x", op)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unwind
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// CFAType is the way the CFA is computed in a compact unwind table row.
+type CFAType uint8
+
+const (
+	// CFATypeEnd marks the end of a function, i.e. a gap without unwind information.
+	CFATypeEnd CFAType = iota
+	// CFATypeSP means the CFA is SP+CFAOffset.
+	CFATypeSP
+	// CFATypeFP means the CFA is FP+CFAOffset.
+	CFATypeFP
+	// CFATypeUnsupported means the CFA uses any other register or an expression,
+	// e.g. in PLT stubs.
+	CFATypeUnsupported
+)
+
+// CompactRuleType is the way a register is recovered in a compact unwind table row.
+type CompactRuleType uint8
+
+const (
+	// CompactRuleUnchanged means the register keeps its value in the caller frame.
+	CompactRuleUnchanged CompactRuleType = iota
+	// CompactRuleOffset means the register is saved at CFA+offset.
+	CompactRuleOffset
+	// CompactRuleUnsupported means the register is recovered in any other way.
+	CompactRuleUnsupported
+)
+
+// CompactRow is a row of the compact unwind table.
+// It only describes the CFA, the frame pointer and the return address,
+// which is all a frame-pointer-less stack unwinder needs.
+type CompactRow struct {
+	PC        uint64
+	CFAType   CFAType
+	FPType    CompactRuleType
+	RAType    CompactRuleType
+	CFAOffset int16
+	FPOffset  int16
+	RAOffset  int16
+}
+
+// CompactTable is a flat, sorted unwind table that can be loaded in an eBPF map.
+// The row for a PC is the last row whose PC is lower than or equal to it.
+type CompactTable struct {
+	Machine elf.Machine
+	Rows    []CompactRow
+}
+
+// Binary format of a compact table, all fields are little endian:
+//
+//	header: magic "UNWT" | version uint32 | machine uint32 | row count uint32
+//	row:    pc uint64 | cfa_offset int16 | fp_offset int16 | ra_offset int16 |
+//	        cfa_type uint8 | fp_type (low nibble), ra_type (high nibble) uint8
+const (
+	compactMagic   = "UNWT"
+	compactVersion = 1
+
+	// CompactRowSize is the size in bytes of an encoded compact row.
+	CompactRowSize = 16
+)
+
+// archRegisters are the DWARF register numbers an unwinder needs on an architecture.
+type archRegisters struct {
+	sp, fp, ra uint64
+}
+
+func registersFor(machine elf.Machine) (archRegisters, error) {
+	switch machine {
+	case elf.EM_X86_64:
+		return archRegisters{sp: 7, fp: 6, ra: 16}, nil
+	case elf.EM_AARCH64:
+		return archRegisters{sp: 31, fp: 29, ra: 30}, nil
+	default:
+		return archRegisters{}, fmt.Errorf("unsupported machine %s", machine)
+	}
+}
+
+// Compact flattens the table into a CompactTable.
+func (t *Table) Compact() (*CompactTable, error) {
+	regs, err := registersFor(t.machine)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := t.Rows()
+	if err != nil {
+		return nil, err
+	}
+
+	ct := &CompactTable{Machine: t.machine}
+	for i, row := range rows {
+		cr := compactRow(row, regs)
+		if n := len(ct.Rows); n == 0 || !sameRules(ct.Rows[n-1], cr) {
+			ct.Rows = append(ct.Rows, cr)
+		}
+		// Mark the gap between two functions.
+		if i+1 == len(rows) || rows[i+1].Start > row.End {
+			ct.Rows = append(ct.Rows, CompactRow{PC: row.End, CFAType: CFATypeEnd})
+		}
+	}
+	return ct, nil
+}
+
+func compactRow(row Row, regs archRegisters) CompactRow {
+	cr := CompactRow{PC: row.Start, CFAType: CFATypeUnsupported}
+	if row.CFA.Expression == nil && fitsInt16(row.CFA.Offset) {
+		switch row.CFA.Reg {
+		case regs.sp:
+			cr.CFAType, cr.CFAOffset = CFATypeSP, int16(row.CFA.Offset)
+		case regs.fp:
+			cr.CFAType, cr.CFAOffset = CFATypeFP, int16(row.CFA.Offset)
+		}
+	}
+	cr.FPType, cr.FPOffset = compactRule(row.Registers[regs.fp])
+	ra := regs.ra
+	if row.ReturnAddressRegister != 0 {
+		ra = row.ReturnAddressRegister
+	}
+	cr.RAType, cr.RAOffset = compactRule(row.Registers[ra])
+	return cr
+}
+
+func compactRule(rule RegisterRule) (CompactRuleType, int16) {
+	switch rule.Type {
+	case RuleUndefined, RuleSameValue:
+		return CompactRuleUnchanged, 0
+	case RuleOffset:
+		if fitsInt16(rule.Offset) {
+			return CompactRuleOffset, int16(rule.Offset)
+		}
+	}
+	return CompactRuleUnsupported, 0
+}
+
+func fitsInt16(v int64) bool {
+	return v >= math.MinInt16 && v <= math.MaxInt16
+}
+
+func sameRules(a, b CompactRow) bool {
+	a.PC, b.PC = 0, 0
+	return a == b
+}
+
+// Lookup returns the row that applies to pc.
+func (ct *CompactTable) Lookup(pc uint64) (CompactRow, bool) {
+	lo, hi := 0, len(ct.Rows)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		if ct.Rows[mid].PC <= pc {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	if lo == 0 || ct.Rows[lo-1].CFAType == CFATypeEnd {
+		return CompactRow{}, false
+	}
+	return ct.Rows[lo-1], true
+}
+
+// MarshalBinary encodes the table in its binary format.
+func (ct *CompactTable) MarshalBinary() ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, 16+CompactRowSize*len(ct.Rows)))
+	buf.WriteString(compactMagic)
+	for _, v := range []uint32{compactVersion, uint32(ct.Machine), uint32(len(ct.Rows))} {
+		if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+			return nil, err
+		}
+	}
+
+	var row [CompactRowSize]byte
+	for _, r := range ct.Rows {
+		binary.LittleEndian.PutUint64(row[0:], r.PC)
+		binary.LittleEndian.PutUint16(row[8:], uint16(r.CFAOffset))
+		binary.LittleEndian.PutUint16(row[10:], uint16(r.FPOffset))
+		binary.LittleEndian.PutUint16(row[12:], uint16(r.RAOffset))
+		row[14] = byte(r.CFAType)
+		row[15] = byte(r.FPType)&0x0f | byte(r.RAType)<<4
+		buf.Write(row[:])
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes a table encoded by MarshalBinary.
+func (ct *CompactTable) UnmarshalBinary(data []byte) error {
+	if len(data) < 16 || string(data[:4]) != compactMagic {
+		return errors.New("invalid compact unwind table header")
+	}
+	if v := binary.LittleEndian.Uint32(data[4:]); v != compactVersion {
+		return fmt.Errorf("unsupported compact unwind table version %d", v)
+	}
+	machine := elf.Machine(binary.LittleEndian.Uint32(data[8:]))
+	count := uint64(binary.LittleEndian.Uint32(data[12:]))
+	data = data[16:]
+	if uint64(len(data)) != count*CompactRowSize {
+		return io.ErrUnexpectedEOF
+	}
+
+	rows := make([]CompactRow, count)
+	for i := range rows {
+		row := data[i*CompactRowSize:]
+		rows[i] = CompactRow{
+			PC:        binary.LittleEndian.Uint64(row[0:]),
+			CFAOffset: int16(binary.LittleEndian.Uint16(row[8:])),
+			FPOffset:  int16(binary.LittleEndian.Uint16(row[10:])),
+			RAOffset:  int16(binary.LittleEndian.Uint16(row[12:])),
+			CFAType:   CFAType(row[14]),
+			FPType:    CompactRuleType(row[15] & 0x0f),
+			RAType:    CompactRuleType(row[15] >> 4),
+		}
+	}
+	ct.Machine, ct.Rows = machine, rows
+	return nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unwind
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Pointer encodings used in .eh_frame_hdr, see
+// https://refspecs.linuxfoundation.org/LSB_5.0.0/LSB-Core-generic/LSB-Core-generic/ehframechpt.html.
+const (
+	pePtr     = 0x00
+	peUdata2  = 0x02
+	peUdata4  = 0x03
+	peUdata8  = 0x04
+	peSdata2  = 0x0a
+	peSdata4  = 0x0b
+	peSdata8  = 0x0c
+	peOmit    = 0xff
+	pePCRel   = 0x10
+	peDataRel = 0x30
+)
+
+// EHFrameHdrEntry is an entry of the binary search table of .eh_frame_hdr.
+type EHFrameHdrEntry struct {
+	// InitialLocation is the address of the first instruction covered by the FDE.
+	InitialLocation uint64
+	// FDEAddress is the address of the FDE in .eh_frame.
+	FDEAddress uint64
+}
+
+// EHFrameHdr is the decoded .eh_frame_hdr section.
+type EHFrameHdr struct {
+	// EHFrameAddress is the address of the .eh_frame section.
+	EHFrameAddress uint64
+	// Table is sorted by initial location. It's empty if the linker didn't emit one.
+	Table []EHFrameHdrEntry
+}
+
+// ParseEHFrameHdr decodes the .eh_frame_hdr section data mapped at addr.
+func ParseEHFrameHdr(data []byte, addr uint64, order binary.ByteOrder) (*EHFrameHdr, error) {
+	if len(data) < 4 {
+		return nil, errors.New("truncated .eh_frame_hdr header")
+	}
+	if data[0] != 1 {
+		return nil, fmt.Errorf("unsupported .eh_frame_hdr version %d", data[0])
+	}
+	ehFramePtrEnc, fdeCountEnc, tableEnc := data[1], data[2], data[3]
+
+	d := &ehDecoder{data: data, addr: addr, order: order, pos: 4, dataBase: addr}
+	ehFramePtr, err := d.read(ehFramePtrEnc)
+	if err != nil {
+		return nil, fmt.Errorf("eh_frame_ptr: %w", err)
+	}
+	hdr := &EHFrameHdr{EHFrameAddress: ehFramePtr}
+	if fdeCountEnc == peOmit || tableEnc == peOmit {
+		return hdr, nil
+	}
+
+	count, err := d.read(fdeCountEnc)
+	if err != nil {
+		return nil, fmt.Errorf("fde_count: %w", err)
+	}
+	if count > uint64(len(data)) {
+		return nil, fmt.Errorf("invalid fde_count %d", count)
+	}
+	hdr.Table = make([]EHFrameHdrEntry, 0, count)
+	for i := uint64(0); i < count; i++ {
+		loc, err := d.read(tableEnc)
+		if err != nil {
+			return nil, fmt.Errorf("table entry %d: %w", i, err)
+		}
+		fde, err := d.read(tableEnc)
+		if err != nil {
+			return nil, fmt.Errorf("table entry %d: %w", i, err)
+		}
+		hdr.Table = append(hdr.Table, EHFrameHdrEntry{InitialLocation: loc, FDEAddress: fde})
+	}
+	return hdr, nil
+}
+
+// Lookup returns the address of the FDE which may cover pc, found with a binary search.
+// The caller is responsible for checking the range of the FDE itself.
+func (h *EHFrameHdr) Lookup(pc uint64) (uint64, bool) {
+	i := sort.Search(len(h.Table), func(i int) bool {
+		return h.Table[i].InitialLocation > pc
+	})
+	if i == 0 {
+		return 0, false
+	}
+	return h.Table[i-1].FDEAddress, true
+}
+
+// ehDecoder reads encoded pointers from .eh_frame_hdr and .eh_frame.
+type ehDecoder struct {
+	data  []byte
+	addr  uint64
+	order binary.ByteOrder
+	pos   int
+	// ptrSize is the size of the absolute pointers, 8 if 0.
+	ptrSize int
+	// dataBase is the base of the data relative values: the start of .eh_frame_hdr,
+	// the GOT for .eh_frame.
+	dataBase uint64
+}
+
+func (d *ehDecoder) read(enc byte) (uint64, error) {
+	start := d.pos
+
+	var v uint64
+	size := enc & 0x0f
+	if size == pePtr && d.ptrSize == 4 {
+		size = peUdata4
+	}
+	switch size {
+	case pePtr, peUdata8, peSdata8:
+		if d.pos+8 > len(d.data) {
+			return 0, errors.New("unexpected end of data")
+		}
+		v = d.order.Uint64(d.data[d.pos:])
+		d.pos += 8
+	case peUdata4:
+		if d.pos+4 > len(d.data) {
+			return 0, errors.New("unexpected end of data")
+		}
+		v = uint64(d.order.Uint32(d.data[d.pos:]))
+		d.pos += 4
+	case peSdata4:
+		if d.pos+4 > len(d.data) {
+			return 0, errors.New("unexpected end of data")
+		}
+		v = uint64(int64(int32(d.order.Uint32(d.data[d.pos:]))))
+		d.pos += 4
+	case peUdata2:
+		if d.pos+2 > len(d.data) {
+			return 0, errors.New("unexpected end of data")
+		}
+		v = uint64(d.order.Uint16(d.data[d.pos:]))
+		d.pos += 2
+	case peSdata2:
+		if d.pos+2 > len(d.data) {
+			return 0, errors.New("unexpected end of data")
+		}
+		v = uint64(int64(int16(d.order.Uint16(d.data[d.pos:]))))
+		d.pos += 2
+	default:
+		return 0, fmt.Errorf("unsupported pointer encoding %#x", enc)
+	}
+
+	switch enc & 0x70 {
+	case 0:
+	case pePCRel:
+		v += d.addr + uint64(start)
+	case peDataRel:
+		v += d.dataBase
+	default:
+		return 0, fmt.Errorf("unsupported pointer encoding %#x", enc)
+	}
+	return v, nil
+}
@@ -0,0 +1,236 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package unwind reads the call frame information (CFI) of ELF files
+// from .eh_frame, .eh_frame_hdr and .debug_frame sections, to unwind
+// stacks of code compiled without frame pointers.
+package unwind
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-delve/delve/pkg/dwarf/frame"
+)
+
+// RuleType is the kind of rule used to recover the value of a register in the caller frame.
+type RuleType uint8
+
+const (
+	// RuleUndefined means the register has no recoverable value.
+	RuleUndefined RuleType = iota
+	// RuleSameValue means the register hasn't been modified.
+	RuleSameValue
+	// RuleOffset means the register is saved at the address CFA+Offset.
+	RuleOffset
+	// RuleValOffset means the value of the register is CFA+Offset.
+	RuleValOffset
+	// RuleRegister means the register is saved in the register Reg.
+	RuleRegister
+	// RuleExpression means the register is saved at the address computed by Expression.
+	RuleExpression
+	// RuleValExpression means the value of the register is computed by Expression.
+	RuleValExpression
+)
+
+func (t RuleType) String() string {
+	switch t {
+	case RuleUndefined:
+		return "undefined"
+	case RuleSameValue:
+		return "same_value"
+	case RuleOffset:
+		return "offset"
+	case RuleValOffset:
+		return "val_offset"
+	case RuleRegister:
+		return "register"
+	case RuleExpression:
+		return "expression"
+	case RuleValExpression:
+		return "val_expression"
+	default:
+		return fmt.Sprintf("RuleType(%d)", t)
+	}
+}
+
+// RegisterRule describes how to recover the value of a register in the caller frame.
+type RegisterRule struct {
+	Type       RuleType
+	Offset     int64
+	Reg        uint64
+	Expression []byte
+}
+
+// CFARule describes how to compute the canonical frame address (CFA),
+// either as Reg+Offset or, if Expression is set, by evaluating a DWARF expression.
+type CFARule struct {
+	Reg        uint64
+	Offset     int64
+	Expression []byte
+}
+
+// Row is a row of the CFI table, i.e. the unwind rules that apply
+// to the program counters in [Start, End).
+type Row struct {
+	Start uint64
+	End   uint64
+	CFA   CFARule
+	// Registers holds the rules of the registers that don't have the default rule,
+	// keyed by DWARF register number.
+	Registers map[uint64]RegisterRule
+	// ReturnAddressRegister is the DWARF register number that holds the return address.
+	ReturnAddressRegister uint64
+}
+
+// Table is the call frame information of an ELF file.
+type Table struct {
+	machine elf.Machine
+	ptrSize int
+	order   binary.ByteOrder
+	fdes    frame.FrameDescriptionEntries
+	// eh are the address encodings of the FDEs of .eh_frame.
+	eh map[*frame.FrameDescriptionEntry]*ehFDE
+
+	// Hdr is the decoded .eh_frame_hdr section, nil if the file doesn't have one.
+	Hdr *EHFrameHdr
+}
+
+// New reads the call frame information from the .eh_frame and .debug_frame sections of f.
+// Entries of .eh_frame take precedence over the ones of .debug_frame for the same addresses.
+func New(f *elf.File) (*Table, error) {
+	ptrSize := 8
+	if f.Class == elf.ELFCLASS32 {
+		ptrSize = 4
+	}
+	t := &Table{
+		machine: f.Machine,
+		ptrSize: ptrSize,
+		order:   f.ByteOrder,
+	}
+
+	if sec := f.Section(".eh_frame"); sec != nil && sec.Type != elf.SHT_NOBITS {
+		data, err := sec.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read .eh_frame section: %w", err)
+		}
+		// Data relative addresses are relative to the GOT, see the i386 psABI.
+		var got uint64
+		if sec := f.Section(".got.plt"); sec != nil {
+			got = sec.Addr
+		} else if sec := f.Section(".got"); sec != nil {
+			got = sec.Addr
+		}
+		if err := t.addEHFrame(data, sec.Addr, got); err != nil {
+			return nil, fmt.Errorf("failed to parse .eh_frame section: %w", err)
+		}
+	}
+
+	if sec := f.Section(".debug_frame"); sec != nil && sec.Type != elf.SHT_NOBITS {
+		data, err := sec.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read .debug_frame section: %w", err)
+		}
+		if err := t.addDebugFrame(data); err != nil {
+			return nil, fmt.Errorf("failed to parse .debug_frame section: %w", err)
+		}
+	}
+
+	if sec := f.Section(".eh_frame_hdr"); sec != nil && sec.Type != elf.SHT_NOBITS {
+		data, err := sec.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read .eh_frame_hdr section: %w", err)
+		}
+		if t.Hdr, err = ParseEHFrameHdr(data, sec.Addr, f.ByteOrder); err != nil {
+			return nil, fmt.Errorf("failed to parse .eh_frame_hdr section: %w", err)
+		}
+	}
+
+	if len(t.fdes) == 0 {
+		return nil, errors.New("no call frame information found")
+	}
+	return t, nil
+}
+
+// addEHFrame adds the FDEs of the .eh_frame data mapped at addr, dataBase being the base of
+// the data relative addresses.
+func (t *Table) addEHFrame(data []byte, addr, dataBase uint64) error {
+	fdes, err := frame.Parse(data, t.order, 0, t.ptrSize, addr)
+	if err != nil {
+		return err
+	}
+	encs, err := parseEHFDEs(data, addr, dataBase, t.ptrSize, t.order)
+	if err != nil {
+		return err
+	}
+	if len(encs) != len(fdes) {
+		return fmt.Errorf("found %d FDEs instead of %d", len(encs), len(fdes))
+	}
+	t.eh = make(map[*frame.FrameDescriptionEntry]*ehFDE, len(fdes))
+	for i, fde := range fdes {
+		if encs[i].begin != fde.Begin() {
+			return fmt.Errorf("FDE %d starts at %#x instead of %#x", i, encs[i].begin, fde.Begin())
+		}
+		t.eh[fde] = &encs[i]
+	}
+
+	t.fdes = fdes
+	sort.SliceStable(t.fdes, func(i, j int) bool {
+		return t.fdes[i].Begin() < t.fdes[j].Begin()
+	})
+	return nil
+}
+
+// addDebugFrame adds the FDEs of the .debug_frame data, after the ones of .eh_frame.
+func (t *Table) addDebugFrame(data []byte) error {
+	fdes, err := frame.Parse(data, t.order, 0, t.ptrSize, 0)
+	if err != nil {
+		return err
+	}
+	t.fdes = t.fdes.Append(fdes)
+	return nil
+}
+
+// RowForPC returns the unwind rules that apply at pc.
+func (t *Table) RowForPC(pc uint64) (*Row, error) {
+	fde, err := t.fdes.FDEForPC(pc)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := executeFDE(fde, t.eh[fde], t.ptrSize, t.order)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		if rows[i].Start <= pc && pc < rows[i].End {
+			return &rows[i], nil
+		}
+	}
+	return nil, &frame.ErrNoFDEForPC{PC: pc}
+}
+
+// Rows returns every row of the table, sorted by address.
+func (t *Table) Rows() ([]Row, error) {
+	var rows []Row
+	for _, fde := range t.fdes {
+		fdeRows, err := executeFDE(fde, t.eh[fde], t.ptrSize, t.order)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute CFI program of FDE at %#x: %w", fde.Begin(), err)
+		}
+		rows = append(rows, fdeRows...)
+	}
+	return rows, nil
+}
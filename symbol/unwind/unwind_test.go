@@ -0,0 +1,137 @@
+// Copyright 2022-2023 The Parca Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package unwind
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTable(t *testing.T) {
+	f, err := elf.Open("../addr2line/testdata/data-c-with-debuginfo")
+	require.NoError(t, err)
+	defer f.Close()
+
+	table, err := New(f)
+	require.NoError(t, err)
+
+	// main: push %rbp; mov %rsp,%rbp; ...; pop %rbp; ret
+	row, err := table.RowForPC(0x401110)
+	require.NoError(t, err)
+	require.Equal(t, CFARule{Reg: 6, Offset: 16}, row.CFA)
+	require.Equal(t, RegisterRule{Type: RuleOffset, Offset: -16}, row.Registers[6])
+	require.Equal(t, RegisterRule{Type: RuleOffset, Offset: -8}, row.Registers[16])
+
+	require.NotNil(t, table.Hdr)
+	fde, ok := table.Hdr.Lookup(0x401110)
+	require.True(t, ok)
+	require.Equal(t, uint64(0x402028+0x58), fde)
+
+	ct, err := table.Compact()
+	require.NoError(t, err)
+
+	tests := []struct {
+		pc   uint64
+		want CompactRow
+		ok   bool
+	}{
+		{pc: 0x401106, want: CompactRow{PC: 0x401106, CFAType: CFATypeSP, CFAOffset: 8, RAType: CompactRuleOffset, RAOffset: -8}, ok: true},
+		{pc: 0x401107, want: CompactRow{PC: 0x401107, CFAType: CFATypeSP, CFAOffset: 16, FPType: CompactRuleOffset, FPOffset: -16, RAType: CompactRuleOffset, RAOffset: -8}, ok: true},
+		{pc: 0x401115, want: CompactRow{PC: 0x40110a, CFAType: CFATypeFP, CFAOffset: 16, FPType: CompactRuleOffset, FPOffset: -16, RAType: CompactRuleOffset, RAOffset: -8}, ok: true},
+		{pc: 0x401122, ok: false},
+	}
+	for _, tt := range tests {
+		got, ok := ct.Lookup(tt.pc)
+		require.Equal(t, tt.ok, ok, "pc %#x", tt.pc)
+		require.Equal(t, tt.want, got, "pc %#x", tt.pc)
+	}
+
+	b, err := ct.MarshalBinary()
+	require.NoError(t, err)
+	require.Len(t, b, 16+CompactRowSize*len(ct.Rows))
+
+	var decoded CompactTable
+	require.NoError(t, decoded.UnmarshalBinary(b))
+	require.Equal(t, ct, &decoded)
+}
+
+// cfiEntry appends a CIE or FDE to the .eh_frame or .debug_frame data sec, padded with
+// DW_CFA_nop.
+func cfiEntry(sec []byte, id uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, cfaNop)
+	}
+	sec = binary.LittleEndian.AppendUint32(sec, uint32(4+len(body)))
+	sec = binary.LittleEndian.AppendUint32(sec, id)
+	return append(sec, body...)
+}
+
+// setLocRows are the rows of an FDE of [begin, begin+size) whose CFA offset changes at loc.
+func setLocRows(begin, size, loc uint64) []Row {
+	regs := map[uint64]RegisterRule{16: {Type: RuleOffset, Offset: -8}}
+	return []Row{
+		{Start: begin, End: loc, CFA: CFARule{Reg: 7, Offset: 16}, Registers: regs, ReturnAddressRegister: 16},
+		{Start: loc, End: begin + size, CFA: CFARule{Reg: 7, Offset: 24}, Registers: regs, ReturnAddressRegister: 16},
+	}
+}
+
+func TestTable_EHFrameSetLoc(t *testing.T) {
+	const addr = 0x2000
+	le := binary.LittleEndian
+
+	// Version 1, "zR", code and data alignment factors 1 and -8, return address in r16,
+	// FDE addresses encoded as DW_EH_PE_pcrel|DW_EH_PE_sdata4, CFA = r7+8 and r16 at CFA-8.
+	ehFrame := cfiEntry(nil, 0, []byte{1, 'z', 'R', 0, 1, 0x78, 16, 1, pePCRel | peSdata4, cfaDefCFA, 7, 8, cfaOffset | 16, 1})
+	fde := func(sec []byte, begin, size, loc uint64) []byte {
+		body := addr + uint64(len(sec)) + 8
+		b := le.AppendUint32(nil, uint32(begin-body))
+		b = le.AppendUint32(b, uint32(size))
+		b = append(b, 0, cfaDefCFAOffset, 16, cfaSetLoc)
+		b = le.AppendUint32(b, uint32(loc-(body+uint64(len(b)))))
+		b = append(b, cfaDefCFAOffset, 24)
+		// The CIE pointer is the offset of the CIE from the pointer.
+		return cfiEntry(sec, uint32(len(sec)+4), b)
+	}
+	ehFrame = fde(ehFrame, 0x1000, 0x10, 0x1004)
+	ehFrame = fde(ehFrame, 0x1100, 0x20, 0x1108)
+	ehFrame = le.AppendUint32(ehFrame, 0)
+
+	table := &Table{ptrSize: 8, order: le}
+	require.NoError(t, table.addEHFrame(ehFrame, addr, 0))
+	rows, err := table.Rows()
+	require.NoError(t, err)
+	require.Equal(t, append(setLocRows(0x1000, 0x10, 0x1004), setLocRows(0x1100, 0x20, 0x1108)...), rows)
+}
+
+func TestTable_DebugFrameSetLoc(t *testing.T) {
+	le := binary.LittleEndian
+
+	// Version 1, no augmentation, the addresses are absolute.
+	debugFrame := cfiEntry(nil, 0xffffffff, []byte{1, 0, 1, 0x78, 16, cfaDefCFA, 7, 8, cfaOffset | 16, 1})
+	b := le.AppendUint64(nil, 0x1000)
+	b = le.AppendUint64(b, 0x10)
+	b = append(b, cfaDefCFAOffset, 16, cfaSetLoc)
+	b = le.AppendUint64(b, 0x1006)
+	b = append(b, cfaDefCFAOffset, 24)
+	debugFrame = cfiEntry(debugFrame, 0, b)
+
+	table := &Table{ptrSize: 8, order: le}
+	require.NoError(t, table.addDebugFrame(debugFrame))
+	rows, err := table.Rows()
+	require.NoError(t, err)
+	require.Equal(t, setLocRows(0x1000, 0x10, 0x1006), rows)
+}